@@ -4,66 +4,52 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"time"
 	"net/http"
 	"strings"
-	"encoding/json"
+	"time"
+
+	"cwms/api"
 )
 
-// Wms is Warehouse Management System inventory database record structure that matches the fields in the v_inventory view
-// 	xml reflection tags are included for xml marshalling
-type Wms struct {
-	Id          int    `xml:"id,attr" json:"id" csv:"-"`
-	StartTime   time.Time `xml:"time>start" json:"startTime"  csv:"-"`
-	StopTime    time.Time `xml:"time>stop" json:"stopTime"  csv:"-"`
-	SKU         NullString `xml:"item>SKU" json:"sku"  csv:"sku"`
-	Discrepancy NullString `xml:"item>Discrepancy,omitempty" json:"discrepancy"  csv:"-"`
-	Aisle       string `xml:"position>Aisle" json:"aisle"  csv:"aisle"`
-	Block       string `xml:"position>Block" json:"block"  csv:"block"`
-	Slot        string `xml:"position>Slot" json:"slot"  csv:"slot"`
-	Shelf       string `xml:"position>Shelf" json:"shelf"  csv:"-"`
-	DisplayName       string `xml:"position>DisplayName" json:"displayname"  csv:"display_name"`
-	Image       NullString `xml:"position>Image" json:"image"  csv:"image_url"`
-}
+// Wms is an inventory record of the v_inventory view, NullString a nullable column and WmsList a slice of Wms
+type (
+	Wms        = api.Wms
+	NullString = api.NullString
+	WmsList    = api.WmsList
+)
 
-// NullString is an alias for sql.NullString data type
-type NullString struct {
-	sql.NullString
-}
+// wmsColumns selects the v_inventory columns in the order scanned by scanFields
+const wmsColumns = `inventoryId, startTime, stopTime, sku, aisle, block, slot, shelf, displayName, discrepancy, imageUrl, thumbnailUrl, gtin, lot, expiry, quantity`
 
+// scanTime scans a nullable scan time, null for a record that was imported but never scanned, as the zero time
+type scanTime struct{ t *time.Time }
 
-// MarshalJSON for NullString
-func (ns *NullString) MarshalJSON() ([]byte, error) {
-	if !ns.Valid {
-		return []byte("\"\""), nil //TODO this is dumb, should be []byte("null")
+// Scan implements sql.Scanner
+func (st scanTime) Scan(v interface{}) error {
+	if v == nil {
+		*st.t = time.Time{}
+		return nil
 	}
-	return json.Marshal(ns.String)
-}
-
-// UnmarshalJSON for NullString
-func (ns *NullString) UnmarshalJSON(b []byte) error {
-	err := json.Unmarshal(b, &ns.String)
-	ns.Valid = (err == nil)
-	return err
-}
-
-
-// MarshalCSV for NullString
-func (ns *NullString) MarshalCSV() ([]byte, error) {
-	if !ns.Valid {
-		return []byte(""), nil //TODO this is dumb, should be []byte("null")
+	var nt sql.NullTime
+	if err := nt.Scan(v); err != nil {
+		return err
 	}
-	return []byte(ns.String), nil
+	*st.t = nt.Time
+	return nil
 }
 
-// WmsList is a slice of Wms
-type WmsList []Wms
+// scanFields returns pointers to the record fields in wmsColumns order
+func scanFields(record *Wms) []interface{} {
+	return []interface{}{&record.Id, scanTime{&record.StartTime}, scanTime{&record.StopTime}, &record.SKU, &record.Aisle, &record.Block, &record.Slot, &record.Shelf, &record.DisplayName, &record.Discrepancy, &record.Image, &record.Thumbnail, &record.Gtin, &record.Lot, &record.Expiry, &record.Quantity}
+}
 
 // FetchInventory performs a query on v_inventory and returns the results in a WmsList.
 func FetchInventory(af AisleFilter) (wl WmsList, err error) {
+	defer observeQuery("FetchInventory", time.Now())
 	// Execute database query
 	var rows *sql.Rows
-	rows, err = db.Query(af.toSqlStmt())
+	sqlstmt, args := af.toSqlStmt()
+	rows, err = db.Query(sqlstmt, args...)
 
 	if err != nil {
 		return
@@ -73,7 +59,7 @@ func FetchInventory(af AisleFilter) (wl WmsList, err error) {
 	// Process database query results
 	var record Wms
 	for rows.Next() {
-		err = rows.Scan(&record.Id, &record.StartTime, &record.StopTime, &record.SKU, &record.Aisle, &record.Block, &record.Slot, &record.Shelf, &record.DisplayName, &record.Discrepancy, &record.Image)
+		err = rows.Scan(scanFields(&record)...)
 		if err != nil {
 			return
 		}
@@ -82,11 +68,11 @@ func FetchInventory(af AisleFilter) (wl WmsList, err error) {
 	return
 }
 
-// fetchAisles performs a query on v_inventory and returns the results in a aisleList
-func fetchAisles(filter string) (aisleList []string, err error) {
+// fetchAisles performs a query on v_inventory and returns the aisles of a site in a aisleList
+func fetchAisles(siteId int, filter string) (aisleList []string, err error) {
 	// Execute database query
 	var rows *sql.Rows
-	rows, err = db.Query(`select distinct aisle from v_inventory order by aisle`)
+	rows, err = db.Query(`select distinct aisle from v_inventory where siteId = ? order by aisle`, siteId)
 	if err != nil {
 		return
 	}
@@ -109,20 +95,32 @@ func fetchAisles(filter string) (aisleList []string, err error) {
 type AisleFilter struct {
 	Aisle       string // Filter on Aisle
 	Discrepancy string // Filter on Discrepancies
+	PositionId  int    // Filter on Position
+	SiteId      int    // Filter on Site
 }
 
-// toSqlStmt generates a sql statement
-func (af AisleFilter) toSqlStmt() (sqlstmt string) {
+// toSqlStmt generates a sql statement and its arguments
+func (af AisleFilter) toSqlStmt() (sqlstmt string, args []interface{}) {
 	var sel, order string
 	var where []string
-	sel = `select inventoryId, startTime, stopTime, sku, aisle, block, slot, shelf, displayName, discrepancy, imageUrl from v_inventory `
+	sel = `select ` + wmsColumns + ` from v_inventory `
 	if af.Aisle != "" {
-		where = append(where, fmt.Sprintf(`aisle ='%s'`, af.Aisle))
+		where = append(where, `aisle = ?`)
+		args = append(args, af.Aisle)
 	}
 	if af.Discrepancy == "all" {
 		where = append(where, `discrepancy !="" `)
 	} else if af.Discrepancy != "" {
-		where = append(where, fmt.Sprintf(`discrepancy ='%s'`, af.Discrepancy))
+		where = append(where, `discrepancy = ?`)
+		args = append(args, af.Discrepancy)
+	}
+	if af.PositionId != 0 {
+		where = append(where, `positionId = ?`)
+		args = append(args, af.PositionId)
+	}
+	if af.SiteId != 0 {
+		where = append(where, `siteId = ?`)
+		args = append(args, af.SiteId)
 	}
 	order = `order by aisle, block, slot`
 	if len(where) > 0 {
@@ -133,23 +131,24 @@ func (af AisleFilter) toSqlStmt() (sqlstmt string) {
 	return
 }
 
+// handleApiAisles is the endpoint for aisles restful api
+// accepts:
+//
+//	GET /api/v1/aisles          statistics of each aisle
+//	GET /api/v1/aisles/:aisle   inventory of the aisle
 func handleApiAisles(w http.ResponseWriter, r *http.Request) {
 	// Fetch inventory based on page controls
 	var af AisleFilter
+	af.SiteId = requestSite(r).Id
 
-	// Get segment list from request, set aisle filter if the last segment is a specific aisle
-	sl := strings.Split(r.URL.Path, "/")
-	if len(sl) > 0 {
-		ls := sl[len(sl)-1]
-		if ls != "" {
-			af.Aisle = ls
-		}
-	}
+	// Set aisle filter if the path names a specific aisle
+	af.Aisle = pathParam(r, "aisle")
 
 	if af.Aisle == "" {
-		asl, err := fetchAisleStats()
+		asl, err := fetchAisleStats(af.SiteId)
 		if err != nil {
-			log.Println(err)
+			apiFail(w, r, err)
+			return
 		}
 		// Send filtered inventory in json response
 		if err = jsonApi(w, r, asl, false); err != nil {
@@ -157,9 +156,17 @@ func handleApiAisles(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		// Fetch inventory filtered by aisle filter
+		if ok, err := aisleExists(af.SiteId, af.Aisle); err != nil {
+			apiFail(w, r, err)
+			return
+		} else if !ok {
+			apiFail(w, r, errNotFound("aisle %q not found", af.Aisle))
+			return
+		}
 		wl, err := FetchInventory(af)
 		if err != nil {
-			log.Println(err)
+			apiFail(w, r, err)
+			return
 		}
 		// Send filtered inventory in json response
 		if err = jsonApi(w, r, wl, true); err != nil {
@@ -168,26 +175,28 @@ func handleApiAisles(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleApiDiscrepancies is the endpoint for inventory discrepancies restful api
+// accepts:
+//
+//	GET /api/v1/discrepancies
+//	GET /api/v1/discrepancies/:discrepancy   inventory with the given discrepancy
 func handleApiDiscrepancies(w http.ResponseWriter, r *http.Request) {
 	// Fetch inventory based on page controls
 	var af AisleFilter
 
 	af.Discrepancy = "all"
+	af.SiteId = requestSite(r).Id
 
-	// Get segment list from request, set discrepancy filter if the last segment is a specific aisle
-	sl := strings.Split(r.URL.Path, "/")
-	if len(sl) > 0 {
-		ls := sl[len(sl)-1]
-		log.Println(ls)
-		if ls != "" {
-			af.Discrepancy = ls
-		}
+	// Set discrepancy filter if the path names a specific discrepancy
+	if d := pathParam(r, "discrepancy"); d != "" {
+		af.Discrepancy = d
 	}
 
 	// Fetch inventory filtered by aisle filter
 	wl, err := FetchInventory(af)
 	if err != nil {
-		log.Println(err)
+		apiFail(w, r, err)
+		return
 	}
 
 	// Send filter inventory in json response
@@ -196,26 +205,28 @@ func handleApiDiscrepancies(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-type aisleStats struct {
-	Id              string `db:"aisle" json:"id"`
-	NumberOccupied  int    `db:"numberOccupied" json:"numberOccupied"`
-	NumberEmpty     int    `db:"numberEmpty" json:"numberEmpty"`
-	NumberException int    `db:"numberException" json:"numberException"`
-	NumberUnscanned int    `db:"numberUnscanned" json:"numberUnscanned"`
-	LastScanned     string `db:"lastScanned" json:"lastScanned"`
+// aisleExists reports whether a site has positions in an aisle
+func aisleExists(siteId int, aisle string) (exists bool, err error) {
+	err = db.QueryRow(`select exists (select 1 from positions where siteId = ? and json_extract(json_position, '$.aisle') = ?)`, siteId, aisle).Scan(&exists)
+	return
 }
 
-type aisleStatsList []aisleStats
+// AisleStats summarizes the scanned state of an aisle
+type (
+	AisleStats     = api.AisleStats
+	AisleStatsList = api.AisleStatsList
+)
 
-func fetchAisleStats() (asl aisleStatsList, err error) {
-	// Execute database query
+// fetchAisleStats performs a query on aisleSummaries and returns the statistics of each aisle of a site
+func fetchAisleStats(siteId int) (asl AisleStatsList, err error) {
+	// Execute database query, columns are in AisleStats field order
 	var rows *sql.Rows
-	if rows, err = db.Query("select distinct aisle, numberException, numberEmpty, numberOccupied, numberUnscanned, lastScanned from v_aisleStats"); err != nil {
+	if rows, err = db.Query("select aisle, numberOccupied, numberEmpty, numberException, numberUnscanned, IFNULL(lastScanned, ''), numberQuantityMismatch, expectedQuantity from aisleSummaries where siteId = ? order by aisle", siteId); err != nil {
 		return
 	}
 	defer rows.Close()
 
-	var as aisleStats
+	var as AisleStats
 	// Process query results
 	for rows.Next() {
 		// Load query results into interface list via the pointers
@@ -227,4 +238,4 @@ func fetchAisleStats() (asl aisleStatsList, err error) {
 		asl = append(asl, as)
 	}
 	return
-}
\ No newline at end of file
+}
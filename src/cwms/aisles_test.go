@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestFiltersBindValues(t *testing.T) {
+	seedTestDb(t)
+	const injected = "x' OR siteId=2 OR '"
+	wl, err := FetchInventory(AisleFilter{SiteId: defaultSiteId, Discrepancy: injected})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wl) != 0 {
+		t.Errorf("discrepancy %q selected %d records", injected, len(wl))
+	}
+	if wl, err = FetchInventory(AisleFilter{SiteId: defaultSiteId, Aisle: injected}); err != nil || len(wl) != 0 {
+		t.Errorf("aisle %q selected %d records: %v", injected, len(wl), err)
+	}
+	rl, err := FetchRestrictions(RestrictionFilter{SiteId: defaultSiteId, Name: "x' OR '1'='1"})
+	if err != nil || len(rl) != 0 {
+		t.Errorf("restriction name selected %d restrictions: %v", len(rl), err)
+	}
+	fl, err := FetchFlights(flightFilter{SiteId: defaultSiteId, Sku: "%' OR siteId=2 OR '"})
+	if err != nil || len(fl) != 0 {
+		t.Errorf("flight sku selected %d scans: %v", len(fl), err)
+	}
+}
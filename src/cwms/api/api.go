@@ -0,0 +1,57 @@
+// Package api holds the types the Corvus WMS api exchanges, shared by the server and its Go client
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+)
+
+// NullString is an alias for sql.NullString data type
+type NullString struct {
+	sql.NullString
+}
+
+// MarshalJSON for NullString
+func (ns *NullString) MarshalJSON() ([]byte, error) {
+	if !ns.Valid {
+		return []byte("\"\""), nil //TODO this is dumb, should be []byte("null")
+	}
+	return json.Marshal(ns.String)
+}
+
+// UnmarshalJSON for NullString
+func (ns *NullString) UnmarshalJSON(b []byte) error {
+	err := json.Unmarshal(b, &ns.String)
+	ns.Valid = (err == nil)
+	return err
+}
+
+// UnmarshalCSV for NullString, an empty field is null
+func (ns *NullString) UnmarshalCSV(b []byte) error {
+	ns.String = string(b)
+	ns.Valid = len(b) > 0
+	return nil
+}
+
+// MarshalXML for NullString, the string value as the element text
+func (ns *NullString) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(ns.String, start)
+}
+
+// MarshalCSV for NullString
+func (ns *NullString) MarshalCSV() ([]byte, error) {
+	if !ns.Valid {
+		return []byte(""), nil //TODO this is dumb, should be []byte("null")
+	}
+	return []byte(ns.String), nil
+}
+
+// Problem is an RFC 7807 problem document, the body of every api error response
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance"`
+}
@@ -0,0 +1,46 @@
+package api
+
+// BasicFlight identifies a flight, the drone that flew it and when
+type BasicFlight struct {
+	FlightId int    `json:"id" db:"flightId"`
+	Time     string `json:"time" db:"time"`
+	DroneId  int    `json:"droneId" db:"droneId"`
+}
+
+// Flight is a position scan of a flight, its fields match the columns of the v_flightList view
+type Flight struct {
+	FlightId  int    `json:"id" db:"flightId"`
+	Time      string `json:"time" db:"time"`
+	DroneId   int    `json:"droneId" db:"droneId"`
+	Sku       string `json:"sku" db:"sku"`
+	Occupancy string `json:"occupancy" db:"occupancy"`
+	Aisle     string `json:"aisle" db:"aisle"`
+	Shelf     string `json:"shelf" db:"shelf"`
+	Slot      string `json:"slot" db:"slot"`
+	Barcode   string `json:"barcode" db:"barcode"`
+	Gtin      string `json:"gtin" db:"gtin"`
+	Lot       string `json:"lot" db:"lot"`
+	Expiry    string `json:"expiry" db:"expiry"`
+	Sscc      string `json:"sscc" db:"sscc"`
+	Quantity  *int   `json:"quantity" db:"quantity"`
+}
+
+// BasicFlightList is a slice of BasicFlight
+type BasicFlightList []BasicFlight
+
+// FlightList is a slice of Flight
+type FlightList []Flight
+
+// FlightUploadResult is a stored flight upload
+type FlightUploadResult struct {
+	FlightId int        `json:"flightId"`
+	Scans    FlightList `json:"scans"`
+	Warnings []string   `json:"warnings"` // barcode elements of the scans that could not be decoded
+}
+
+// ReconcileResult summarises a reconciliation run
+type ReconcileResult struct {
+	FlightId      int `json:"flightId"`
+	Reconciled    int `json:"reconciled"`
+	Discrepancies int `json:"discrepancies"`
+}
@@ -0,0 +1,63 @@
+package api
+
+import "time"
+
+// Wms is Warehouse Management System inventory database record structure that matches the fields in the v_inventory view
+// xml reflection tags are included for xml marshalling
+type Wms struct {
+	Id          int        `xml:"id,attr" json:"id" csv:"-"`
+	StartTime   time.Time  `xml:"time>start" json:"startTime"  csv:"-"`
+	StopTime    time.Time  `xml:"time>stop" json:"stopTime"  csv:"-"`
+	SKU         NullString `xml:"item>SKU" json:"sku"  csv:"sku"`
+	Discrepancy NullString `xml:"item>Discrepancy,omitempty" json:"discrepancy"  csv:"-"`
+	Aisle       string     `xml:"position>Aisle" json:"aisle"  csv:"aisle"`
+	Block       string     `xml:"position>Block" json:"block"  csv:"block"`
+	Slot        string     `xml:"position>Slot" json:"slot"  csv:"slot"`
+	Shelf       string     `xml:"position>Shelf" json:"shelf"  csv:"-"`
+	DisplayName string     `xml:"position>DisplayName" json:"displayname"  csv:"display_name"`
+	Image       NullString `xml:"position>Image" json:"image"  csv:"image_url"`
+	Thumbnail   NullString `xml:"position>Thumbnail" json:"thumbnail"  csv:"-"`
+	Gtin        NullString `xml:"item>GTIN,omitempty" json:"gtin"  csv:"gtin"`
+	Lot         NullString `xml:"item>Lot,omitempty" json:"lot"  csv:"lot"`
+	Expiry      NullString `xml:"item>Expiry,omitempty" json:"expiry"  csv:"expiry"`
+	Quantity    int        `xml:"item>Quantity" json:"quantity"  csv:"quantity"`
+}
+
+// WmsList is a slice of Wms
+type WmsList []Wms
+
+// InventoryPage is a page of inventory with the total number of records matching the query
+type InventoryPage struct {
+	Total  int     `xml:"total,attr" json:"total"`
+	Limit  int     `xml:"limit,attr" json:"limit"`
+	Offset int     `xml:"offset,attr" json:"offset"`
+	Next   string  `xml:"next,attr,omitempty" json:"next"` // query string of the next page, blank on the last page
+	Items  WmsList `xml:"Wms" json:"items"`
+}
+
+// AisleStats summarizes the scanned state of the positions of an aisle
+type AisleStats struct {
+	Id                     string `db:"aisle" json:"id"`
+	NumberOccupied         int    `db:"numberOccupied" json:"numberOccupied"`
+	NumberEmpty            int    `db:"numberEmpty" json:"numberEmpty"`
+	NumberException        int    `db:"numberException" json:"numberException"`
+	NumberUnscanned        int    `db:"numberUnscanned" json:"numberUnscanned"`
+	LastScanned            string `db:"lastScanned" json:"lastScanned"`
+	NumberQuantityMismatch int    `db:"numberQuantityMismatch" json:"numberQuantityMismatch"`
+	ExpectedQuantity       int    `db:"expectedQuantity" json:"expectedQuantity"`
+}
+
+// AisleStatsList is a slice of AisleStats
+type AisleStatsList []AisleStats
+
+// Statistics summarizes the inventory
+type Statistics struct {
+	Except30 []int `json:"exceptionsLast30Days"`
+}
+
+// ImportResult summarises a warehouse management system import
+type ImportResult struct {
+	Imported  int `json:"imported"`
+	Replaced  int `json:"replaced"` // records replacing the item recorded at their position
+	Positions int `json:"newPositions"`
+}
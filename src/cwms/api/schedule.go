@@ -0,0 +1,51 @@
+package api
+
+// Queue is a scheduled mission: an event scanning a region, planned onto a drone of the site's fleet
+// Missions over regions without positions, or needing capabilities no available drone has, are left unassigned.
+type Queue struct {
+	Id            int      `json:"id"`
+	Aisles        []string `json:"region"`
+	StartTime     string   `json:"startTime"`
+	StopTime      string   `json:"stopTimeEstimate"`
+	LastCompleted string   `json:"lastCompleted"`
+	Frequency     int      `json:"frequency"`
+	Region        string   `json:"regionName"`
+	Requires      string   `json:"requires"`
+	DroneId       int      `json:"droneId"`
+	RegionId      int      `xml:"-" json:"-"` // planning inputs of the server
+	Positions     int      `xml:"-" json:"-"`
+}
+
+// QueueList is a slice of Queue
+type QueueList []Queue
+
+// Restriction is a period a region is closed to flights, matching the restrictions table
+// xml and json reflection tags determine how the restrictions appear the response
+type Restriction struct {
+	Id             int      `xml:"id,attr" json:"id"`
+	Aisles         []string `json:"region"`
+	Name           string   `xml:"name,attr" json:"-"`
+	StartDate      string   `xml:"date>start" json:"startDate"`
+	StopDate       string   `xml:"date>stop" json:"stopDate"`
+	StartTime      string   `xml:"time>start" json:"startTime"`
+	StopTime       string   `xml:"time>stop" json:"stopTime"`
+	EnabledDays    []bool   `json:"enabledDays"`
+	PeriodicityNum int      `xml:"periodicityNum" json:"periodicityNum"`
+	Periodicity    string   `xml:"periodicity" json:"periodicity"`
+	Region         int      `xml:"region" json:"-"`
+}
+
+// RestrictionList is a slice of Restriction
+type RestrictionList []Restriction
+
+// NewRestriction is the request body creating a restriction over a named region
+type NewRestriction struct {
+	Name           string `json:"name"`
+	RegionName     string `json:"regionName"`
+	StartDate      string `json:"startDate"` // yyyy-mm-dd
+	StopDate       string `json:"stopDate"`
+	StartTime      string `json:"startTime"` // hh:mm
+	StopTime       string `json:"stopTime"`
+	PeriodicityNum int    `json:"periodicityNum"` // 1 when omitted
+	Periodicity    string `json:"periodicity"`    // daily when omitted
+}
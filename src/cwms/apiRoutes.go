@@ -0,0 +1,160 @@
+package main
+
+import "net/http"
+
+// inventoryParams are the query parameters of the paginated inventory api
+var inventoryParams = []apiParam{
+	{"limit", "page size"},
+	{"offset", "records to skip"},
+	{"sort", "comma separated Wms json field names, descending when prefixed with -"},
+	{"sku", "filter on sku"},
+	{"aisle", "filter on aisle"},
+	{"block", "filter on block"},
+	{"slot", "filter on slot"},
+	{"discrepancy", "filter on discrepancy, all for any discrepancy"},
+	{"minAge", "minimum days since the position was scanned"},
+	{"maxAge", "maximum days since the position was scanned"},
+	{"image", "true for positions with an image"},
+}
+
+// exportParams are the query parameters of inventory exports
+var exportParams = append([]apiParam{
+	{"scope", "page control scope, exports discrepancies when set"},
+	{"template", "saved export template name"},
+	{"sheets", "aisle to write each aisle of a workbook to its own worksheet"},
+}, inventoryParams...)
+
+// apiRoutes is the api routing table, served under /api/v1 and by its deprecated unversioned aliases under /api
+// The OpenAPI document served at /api/v1/openapi.json is generated from it.
+func apiRoutes() []apiRoute {
+	get, post, put, del := http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete
+	routes := []apiRoute{
+		// inventory
+		{Method: get, Pattern: "/inventory", Legacy: []string{"/inventory"}, Handler: handleApiInventory, Scoped: true,
+			Tag: "inventory", Summary: "Page through the inventory", Query: inventoryParams, Response: InventoryPage{}},
+		{Method: get, Pattern: "/inventory/all", Legacy: []string{"/json"}, Handler: handleApiInventoryJson, Scoped: true,
+			Tag: "inventory", Summary: "List the inventory of the page controls", Query: []apiParam{{"aisle", "aisle, or all"}, {"scope", "page control scope"}}, Response: WmsList{}},
+		{Method: get, Pattern: "/aisles", Legacy: []string{"/aisles"}, Handler: handleApiAisles, Scoped: true,
+			Tag: "inventory", Summary: "List the statistics of each aisle", Response: AisleStatsList{}},
+		{Method: get, Pattern: "/aisles/{aisle}", Legacy: []string{"/aisles/{aisle}"}, Handler: handleApiAisles, Scoped: true,
+			Tag: "inventory", Summary: "List the inventory of an aisle", Response: WmsList{}},
+		{Method: get, Pattern: "/discrepancies", Legacy: []string{"/discrepancy"}, Handler: handleApiDiscrepancies, Scoped: true,
+			Tag: "inventory", Summary: "List the inventory with a discrepancy", Response: WmsList{}},
+		{Method: get, Pattern: "/discrepancies/{discrepancy}", Legacy: []string{"/discrepancy/{discrepancy}"}, Handler: handleApiDiscrepancies, Scoped: true,
+			Tag: "inventory", Summary: "List the inventory with a given discrepancy", Response: WmsList{}},
+		{Method: get, Pattern: "/positions/{id}", Legacy: []string{"/positions/{id}"}, Handler: handleApiPositions, Scoped: true,
+			Tag: "inventory", Summary: "List the inventory held at a position", Response: WmsList{}},
+		{Method: get, Pattern: "/map", Legacy: []string{"/map"}, Handler: handleMap, Scoped: true,
+			Tag: "inventory", Summary: "Render the warehouse map", Query: []apiParam{{"mode", "discrepancy, occupancy or age"}, {"aisle", "aisle, or all"}, {"days", "age of a stale scan in days"}}, Response: "image/svg+xml"},
+		{Method: get, Pattern: "/statistics", Legacy: []string{"/statistics"}, Handler: handleApiStatistics, Scoped: true,
+			Tag: "inventory", Summary: "Summarize the inventory", Response: Statistics{}},
+		{Method: post, Pattern: "/import", Legacy: []string{"/import"}, Handler: handleApiImport, Scoped: true,
+			Tag: "inventory", Summary: "Import the warehouse management system inventory, as json or text/csv", Body: WmsList{}, Response: ImportResult{}},
+		{Method: get, Pattern: "/tolerances", Legacy: []string{"/tolerances"}, Handler: handleApiTolerances, Scoped: true,
+			Tag: "inventory", Summary: "List the quantity tolerances", Response: ToleranceList{}},
+		{Method: post, Pattern: "/tolerances", Legacy: []string{"/tolerances"}, Handler: handleApiTolerances, Scoped: true,
+			Tag: "inventory", Summary: "Create a quantity tolerance", Body: Tolerance{}, Response: Tolerance{}},
+		{Method: del, Pattern: "/tolerances/{id}", Legacy: []string{"/tolerances/{id}"}, Handler: handleApiTolerances, Scoped: true,
+			Tag: "inventory", Summary: "Delete a quantity tolerance"},
+
+		// exports and reports
+		{Method: get, Pattern: "/export/{format}", Legacy: []string{"/export/{format}"}, Handler: handleApiExport, Scoped: true,
+			Tag: "exports", Summary: "Download the inventory as csv, json, xml, xlsx or ndjson", Query: exportParams, Response: "application/octet-stream"},
+		{Method: get, Pattern: "/export/templates", Legacy: []string{"/export/templates"}, Handler: handleApiExportTemplates, Scoped: true,
+			Tag: "exports", Summary: "List the export templates", Response: []ExportTemplate{}},
+		{Method: post, Pattern: "/export/templates", Legacy: []string{"/export/templates"}, Handler: handleApiExportTemplates, Scoped: true,
+			Tag: "exports", Summary: "Save an export template", Body: ExportTemplate{}, Response: ExportTemplate{}},
+		{Method: get, Pattern: "/export/templates/{name}", Legacy: []string{"/export/templates/{name}"}, Handler: handleApiExportTemplates, Scoped: true,
+			Tag: "exports", Summary: "Get an export template", Response: ExportTemplate{}},
+		{Method: del, Pattern: "/export/templates/{name}", Legacy: []string{"/export/templates/{name}"}, Handler: handleApiExportTemplates, Scoped: true,
+			Tag: "exports", Summary: "Delete an export template"},
+		{Method: get, Pattern: "/reports/expiry", Legacy: []string{"/reports/expiry"}, Handler: handleApiExpiryReport, Scoped: true,
+			Tag: "reports", Summary: "List stock near expiry or with a lot mismatch", Query: []apiParam{{"days", "expiry window in days, default 30"}, {"aisle", "filter on aisle"}}, Response: ExpiryList{}},
+		{Method: get, Pattern: "/reports/schedules", Legacy: []string{"/reports/schedules"}, Handler: handleApiReportSchedules, Scoped: true,
+			Tag: "reports", Summary: "List the report schedules", Response: []ReportSchedule{}},
+		{Method: post, Pattern: "/reports/schedules", Legacy: []string{"/reports/schedules"}, Handler: handleApiReportSchedules, Scoped: true,
+			Tag: "reports", Summary: "Create a report schedule", Body: ReportSchedule{}, Response: ReportSchedule{}},
+		{Method: get, Pattern: "/reports/schedules/{id}", Legacy: []string{"/reports/schedules/{id}"}, Handler: handleApiReportSchedule, Scoped: true,
+			Tag: "reports", Summary: "Get a report schedule", Response: ReportSchedule{}},
+		{Method: del, Pattern: "/reports/schedules/{id}", Legacy: []string{"/reports/schedules/{id}"}, Handler: handleApiReportSchedule, Scoped: true,
+			Tag: "reports", Summary: "Delete a report schedule"},
+		{Method: post, Pattern: "/reports/schedules/{id}/run", Legacy: []string{"/reports/schedules/{id}/run"}, Handler: handleApiReportScheduleRun, Scoped: true,
+			Tag: "reports", Summary: "Run a scheduled report now", Response: ReportRun{}},
+		{Method: get, Pattern: "/reports/schedules/{id}/runs", Legacy: []string{"/reports/schedules/{id}/runs"}, Handler: handleApiReportScheduleRuns, Scoped: true,
+			Tag: "reports", Summary: "List the runs of a report schedule, newest first", Response: []ReportRun{}},
+		{Method: get, Pattern: "/reports/alerts", Legacy: []string{"/reports/alerts"}, Handler: handleApiReportAlerts, Scoped: true,
+			Tag: "reports", Summary: "List failed report runs not yet acknowledged", Response: []ReportRun{}},
+		{Method: del, Pattern: "/reports/alerts/{runId}", Legacy: []string{"/reports/alerts/{runId}"}, Handler: handleApiReportAlerts, Scoped: true,
+			Tag: "reports", Summary: "Acknowledge a failed report run"},
+
+		// flights and the fleet
+		{Method: get, Pattern: "/flights", Legacy: []string{"/flights"}, Handler: handleApiFlights, Scoped: true,
+			Tag: "flights", Summary: "List the flights", Response: BasicFlightList{}},
+		{Method: post, Pattern: "/flights", Legacy: []string{"/flights"}, Handler: handleApiFlights, Scoped: true,
+			Tag: "flights", Summary: "Upload a flight and reconcile it against the inventory", Body: flightUpload{}, Response: FlightUploadResult{}},
+		{Method: get, Pattern: "/flights/{id}", Legacy: []string{"/flights/{id}"}, Handler: handleApiFlight, Scoped: true,
+			Tag: "flights", Summary: "List the scans of a flight", Response: FlightList{}},
+		{Method: post, Pattern: "/flights/{id}/reconcile", Legacy: []string{"/reconcile/{id}"}, Handler: handleApiReconcile, Scoped: true,
+			Tag: "flights", Summary: "Reconcile a flight against the inventory", Response: ReconcileResult{}, Status: http.StatusOK},
+		{Method: get, Pattern: "/images/{id}", Legacy: []string{"/images/{id}"}, Handler: handleApiImages, Scoped: true,
+			Tag: "flights", Summary: "Get an image record", Response: ImageRecord{}},
+		{Method: post, Pattern: "/images", Legacy: []string{"/images"}, Handler: handleApiImages, Scoped: true,
+			Tag: "flights", Summary: "Upload an image of a scanned position as multipart form fields flightId, positionId and image", Body: "multipart/form-data", Response: ImageRecord{}},
+		{Method: get, Pattern: "/drones", Legacy: []string{"/drones"}, Handler: handleApiDrones, Scoped: true,
+			Tag: "fleet", Summary: "List the drones", Response: DroneList{}},
+		{Method: post, Pattern: "/drones", Legacy: []string{"/drones"}, Handler: handleApiDrones, Scoped: true,
+			Tag: "fleet", Summary: "Register a drone", Body: Drone{}, Response: Drone{}},
+		{Method: get, Pattern: "/drones/{id}", Legacy: []string{"/drones/{id}"}, Handler: handleApiDrone, Scoped: true,
+			Tag: "fleet", Summary: "Get a drone", Response: Drone{}},
+		{Method: get, Pattern: "/drones/{id}/telemetry", Legacy: []string{"/drones/{id}/telemetry"}, Handler: handleApiDroneTelemetry, Scoped: true,
+			Tag: "fleet", Summary: "List the recent telemetry of a drone", Response: TelemetryList{}},
+		{Method: post, Pattern: "/drones/{id}/telemetry", Legacy: []string{"/drones/{id}/telemetry"}, Handler: handleApiDroneTelemetry, Scoped: true,
+			Tag: "fleet", Summary: "Record telemetry reported by a drone", Body: Telemetry{}, Response: Telemetry{}},
+		{Method: get, Pattern: "/queue", Legacy: []string{"/queue", "/schedule"}, Handler: handleApiQueue, Scoped: true,
+			Tag: "fleet", Summary: "Plan the mission queue", Response: QueueList{}},
+		{Method: post, Pattern: "/queue", Handler: handleApiQueue, Scoped: true,
+			Tag: "fleet", Summary: "Add a mission over a region, given by regionName, to the end of the queue", Body: Queue{}, Response: Queue{}},
+		{Method: put, Pattern: "/queue", Handler: handleApiQueue, Scoped: true,
+			Tag: "fleet", Summary: "Reorder the queue, listing every queue entry id in the new order", Body: []int{}, Response: QueueList{}},
+		{Method: get, Pattern: "/queue/{id}", Legacy: []string{"/queue/{id}", "/schedule/{id}"}, Handler: handleApiQueue, Scoped: true,
+			Tag: "fleet", Summary: "Get a planned mission", Response: Queue{}},
+		{Method: get, Pattern: "/restrictions", Legacy: []string{"/restrictions"}, Handler: handleApiRestrictions, Scoped: true,
+			Tag: "fleet", Summary: "List the flight restrictions", Response: RestrictionList{}},
+		{Method: post, Pattern: "/restrictions", Handler: handleApiRestrictions, Scoped: true,
+			Tag: "fleet", Summary: "Close a region to flights", Body: NewRestriction{}, Response: Restriction{}},
+		{Method: get, Pattern: "/restrictions/{id}", Legacy: []string{"/restrictions/{id}"}, Handler: handleApiRestrictions, Scoped: true,
+			Tag: "fleet", Summary: "Get a flight restriction", Response: RestrictionList{}},
+
+		// background jobs, they run across every site and require an admin of the default site
+		{Method: get, Pattern: "/jobs", Legacy: []string{"/jobs"}, Handler: handleApiJobs,
+			Tag: "jobs", Summary: "List the background jobs", Response: []Job{}},
+		{Method: get, Pattern: "/jobs/{name}", Legacy: []string{"/jobs/{name}"}, Handler: handleApiJob,
+			Tag: "jobs", Summary: "Get a background job", Response: Job{}},
+		{Method: post, Pattern: "/jobs/{name}", Legacy: []string{"/jobs/{name}"}, Handler: handleApiJob,
+			Tag: "jobs", Summary: "Update the schedule of a background job", Body: Job{}, Response: Job{}, Status: http.StatusOK},
+		{Method: post, Pattern: "/jobs/{name}/run", Legacy: []string{"/jobs/{name}/run"}, Handler: handleApiJobRun,
+			Tag: "jobs", Summary: "Start a run of a background job now", Response: JobRun{}, Status: http.StatusAccepted},
+		{Method: post, Pattern: "/jobs/{name}/cancel", Legacy: []string{"/jobs/{name}/cancel"}, Handler: handleApiJobCancel,
+			Tag: "jobs", Summary: "Cancel the active run of a background job", Response: Job{}, Status: http.StatusAccepted},
+		{Method: get, Pattern: "/jobs/{name}/runs", Legacy: []string{"/jobs/{name}/runs"}, Handler: handleApiJobRuns,
+			Tag: "jobs", Summary: "List the runs of a background job, newest first", Query: []apiParam{{"limit", "runs to list, default 50"}}, Response: []JobRun{}},
+
+		// sites and users
+		{Method: get, Pattern: "/sites", Legacy: []string{"/sites"}, Handler: handleApiSites,
+			Tag: "sites", Summary: "List the sites the caller can see", Response: SiteList{}},
+		{Method: post, Pattern: "/sites", Legacy: []string{"/sites"}, Handler: handleApiSites,
+			Tag: "sites", Summary: "Create a site", Body: Site{}, Response: Site{}},
+		{Method: get, Pattern: "/sites/summary", Legacy: []string{"/sites/summary"}, Handler: handleApiSiteSummaries,
+			Tag: "sites", Summary: "Summarize the aisle statistics of each site the caller can see", Response: []SiteSummary{}},
+		{Method: get, Pattern: "/sites/{site}/permissions", Legacy: []string{"/sites/{site}/permissions"}, Handler: handleSitePermissions,
+			Tag: "sites", Summary: "List the users granted access to a site", Response: []SitePermission{}},
+		{Method: post, Pattern: "/sites/{site}/permissions", Legacy: []string{"/sites/{site}/permissions"}, Handler: handleSitePermissions,
+			Tag: "sites", Summary: "Grant a user a role at a site", Body: SitePermission{}, Response: SitePermission{}},
+		{Method: del, Pattern: "/sites/{site}/permissions", Legacy: []string{"/sites/{site}/permissions"}, Handler: handleSitePermissions,
+			Tag: "sites", Summary: "Revoke a user's access to a site", Body: SitePermission{}},
+		{Method: post, Pattern: "/users", Legacy: []string{"/users"}, Handler: handleApiUsers,
+			Tag: "sites", Summary: "Create an api user and token", Body: User{}, Response: User{}},
+	}
+	return append(routes, apiRoute{Method: get, Pattern: "/openapi.json", Handler: handleOpenApi(routes),
+		Tag: "meta", Summary: "This OpenAPI document", Response: "application/json"})
+}
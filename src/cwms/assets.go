@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// assetFiles holds the page templates and static files served by the dashboard
+// Pages are templates/*.html, each defining "content" over the layout and partials in templates/layout.
+//
+//go:embed templates static
+var assetFiles embed.FS
+
+// devAssets reads templates and static files from the working directory on every request, for editing pages without rebuilding
+var devAssets bool
+
+// pages holds the parsed page templates by file name, parsed once at startup
+var pages map[string]*template.Template
+
+// staticMaxAge is how long browsers may use a static file before revalidating its ETag
+const staticMaxAge = 3600
+
+// assetFS returns the embedded assets, or the working directory in dev mode
+func assetFS() fs.FS {
+	if devAssets {
+		return os.DirFS(".")
+	}
+	return assetFiles
+}
+
+// parsePages parses each page over its own copy of the shared layout and partials
+func parsePages(fsys fs.FS) (pt map[string]*template.Template, err error) {
+	var base *template.Template
+	if base, err = template.ParseFS(fsys, "templates/layout/*.html"); err != nil {
+		return
+	}
+	var files []string
+	if files, err = fs.Glob(fsys, "templates/*.html"); err != nil {
+		return
+	}
+	pt = make(map[string]*template.Template)
+	for _, f := range files {
+		var t *template.Template
+		if t, err = base.Clone(); err != nil {
+			return
+		}
+		if t, err = t.ParseFS(fsys, f); err != nil {
+			return
+		}
+		pt[path.Base(f)] = t
+	}
+	return
+}
+
+// executeTemplate executes the specified page in the layout, reparsing the templates from disk in dev mode
+// The page name, without .html, is passed to the layout in the template map as Page.
+func executeTemplate(f string, tm map[string]interface{}, w http.ResponseWriter) (err error) {
+	pt := pages
+	if devAssets {
+		if pt, err = parsePages(assetFS()); err != nil {
+			return
+		}
+	}
+	t, ok := pt[f]
+	if !ok {
+		return fmt.Errorf("template %s not found", f)
+	}
+
+	tm["Page"] = strings.TrimSuffix(f, ".html")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return t.ExecuteTemplate(w, "layout", tm)
+}
+
+// loadAssets parses the page templates and returns the static file handler
+// Static files are served with an ETag of their content and revalidated after staticMaxAge, in dev mode they are not cached.
+func loadAssets() (h http.Handler, err error) {
+	fsys := assetFS()
+	if pages, err = parsePages(fsys); err != nil {
+		return
+	}
+	var static fs.FS
+	if static, err = fs.Sub(fsys, "static"); err != nil {
+		return
+	}
+	files := http.FileServer(LocalFileSystem{http.FS(static)})
+	if devAssets {
+		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-cache")
+			files.ServeHTTP(w, r)
+		})
+		return
+	}
+
+	// embedded files do not change while serving, so each ETag is computed once
+	etags := make(map[string]string)
+	err = fs.WalkDir(static, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(static, p)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(b)
+		etags[p] = `"` + hex.EncodeToString(sum[:16]) + `"`
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	// http.FileServer answers If-None-Match with 304 Not Modified when the ETag header is set
+	h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if etag, ok := etags[strings.TrimPrefix(r.URL.Path, "/")]; ok {
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staticMaxAge))
+		}
+		files.ServeHTTP(w, r)
+	})
+	return
+}
@@ -0,0 +1,155 @@
+// Package client is a Go client of the Corvus WMS api
+//
+// Requests carry the caller's context, idempotent requests are retried on network failures and
+// on 429, 502, 503 and 504 responses, and api failures are returned as *Error.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"cwms/api"
+)
+
+// Client calls the /api/v1 routes of a Corvus server
+type Client struct {
+	BaseURL    string        // server root, e.g. http://localhost:8080
+	Token      string        // bearer token, blank when the server does not require authentication
+	Site       string        // site code requests are scoped to, blank for the default site
+	HTTPClient *http.Client  // http.DefaultClient when nil
+	Retries    int           // retries of a failed idempotent request
+	Backoff    time.Duration // wait before the first retry, doubled for each further retry
+}
+
+// New returns a client of the server at baseURL, retrying idempotent requests 3 times
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: baseURL, Token: token, Retries: 3, Backoff: 250 * time.Millisecond}
+}
+
+// WithSite returns a copy of the client scoped to a site
+func (c *Client) WithSite(site string) *Client {
+	sc := *c
+	sc.Site = site
+	return &sc
+}
+
+// url returns the url of an api path, under the client's site when it has one
+func (c *Client) url(path string, query url.Values) string {
+	u := c.BaseURL + "/api/v1"
+	if c.Site != "" {
+		u += "/sites/" + url.PathEscape(c.Site)
+	}
+	u += path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// retryable reports whether a response status is worth retrying
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// do sends a request with a json body, when in is not nil, and decodes the json response into out, when out is not nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) (err error) {
+	var body []byte
+	contentType := ""
+	if in != nil {
+		if body, err = json.Marshal(in); err != nil {
+			return
+		}
+		contentType = "application/json"
+	}
+	var resp *http.Response
+	if resp, err = c.send(ctx, method, path, query, contentType, body, "application/json"); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send sends a request, retrying idempotent requests, and returns the successful response for the caller to close
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, contentType string, body []byte, accept string) (resp *http.Response, err error) {
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	idempotent := method == http.MethodGet || method == http.MethodHead || method == http.MethodPut || method == http.MethodDelete
+
+	wait := c.Backoff
+	for attempt := 0; ; attempt++ {
+		var req *http.Request
+		if req, err = http.NewRequest(method, c.url(path, query), bytes.NewReader(body)); err != nil {
+			return
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Accept", accept)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if c.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.Token)
+		}
+
+		resp, err = hc.Do(req)
+		if err == nil {
+			if resp.StatusCode < 300 {
+				return resp, nil
+			}
+			e := responseError(resp)
+			resp, err = nil, e
+			if !retryable(e.Status) {
+				return
+			}
+		} else if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !idempotent || attempt >= c.Retries {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryWait(err, wait)):
+		}
+		wait *= 2
+	}
+}
+
+// responseError reads the problem document of a failed response
+func responseError(resp *http.Response) *Error {
+	defer resp.Body.Close()
+	e := &Error{}
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(s) * time.Second
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil || json.Unmarshal(b, &e.Problem) != nil || e.Status == 0 {
+		e.Problem = api.Problem{Status: resp.StatusCode, Title: http.StatusText(resp.StatusCode), Detail: string(bytes.TrimSpace(b))}
+	}
+	return e
+}
+
+// retryWait is the wait before retrying a failed request, at least the Retry-After the server asked for
+func retryWait(err error, backoff time.Duration) time.Duration {
+	if e, ok := err.(*Error); ok && e.RetryAfter > backoff {
+		return e.RetryAfter
+	}
+	return backoff
+}
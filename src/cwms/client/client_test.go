@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cwms/api"
+)
+
+// testServer serves each request with the handler, counting the requests
+func testServer(t *testing.T, h http.HandlerFunc) (*Client, *int32) {
+	t.Helper()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		h(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	c := New(srv.URL, "secret")
+	c.Backoff = 10 * time.Millisecond
+	return c, &requests
+}
+
+func TestRetry(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		failures int32
+		status   int
+		post     bool
+		requests int32
+		err      bool
+	}{
+		{name: "recovers", failures: 2, status: http.StatusServiceUnavailable, requests: 3},
+		{name: "gateway timeout", failures: 1, status: http.StatusGatewayTimeout, requests: 2},
+		{name: "gives up", failures: 10, status: http.StatusBadGateway, requests: 4, err: true},
+		{name: "server error is not retried", failures: 1, status: http.StatusInternalServerError, requests: 1, err: true},
+		{name: "post is not retried", failures: 1, status: http.StatusServiceUnavailable, post: true, requests: 1, err: true},
+	} {
+		var times []time.Time
+		c, requests := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+			times = append(times, time.Now())
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				t.Errorf("%s: authorization %q", test.name, r.Header.Get("Authorization"))
+			}
+			if int32(len(times)) <= test.failures {
+				w.WriteHeader(test.status)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if test.post {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id": 1}`))
+				return
+			}
+			w.Write([]byte(`[{"id": "2a", "numberOccupied": 2}]`))
+		})
+
+		var err error
+		if test.post {
+			_, err = c.AddMission(context.Background(), "r1")
+		} else {
+			var asl api.AisleStatsList
+			asl, err = c.Aisles(context.Background())
+			if err == nil && (len(asl) != 1 || asl[0].NumberOccupied != 2) {
+				t.Errorf("%s: aisles %+v", test.name, asl)
+			}
+		}
+		if test.err != (err != nil) {
+			t.Errorf("%s: error %v", test.name, err)
+		}
+		var e *Error
+		if test.err && (!errors.As(err, &e) || e.Status != test.status) {
+			t.Errorf("%s: error %v, expected a %d *Error", test.name, err, test.status)
+		}
+		if n := atomic.LoadInt32(requests); n != test.requests {
+			t.Errorf("%s: %d requests, expected %d", test.name, n, test.requests)
+		}
+		// the wait doubles from the backoff on each retry
+		for i := 1; i < len(times); i++ {
+			if wait, min := times[i].Sub(times[i-1]), c.Backoff<<(i-1); wait < min {
+				t.Errorf("%s: retry %d after %v, expected at least %v", test.name, i, wait, min)
+			}
+		}
+	}
+}
+
+func TestProblem(t *testing.T) {
+	for _, test := range []struct {
+		name, contentType, body string
+		status                  int
+		target                  error
+		title, detail           string
+	}{
+		{"problem document", "application/problem+json", `{"type": "about:blank", "title": "Not Found", "status": 404, "detail": "aisle \"9z\" not found"}`,
+			http.StatusNotFound, ErrNotFound, "Not Found", `aisle "9z" not found`},
+		{"forbidden", "application/problem+json", `{"title": "Forbidden", "status": 403, "detail": "GET requires a role"}`,
+			http.StatusForbidden, ErrForbidden, "Forbidden", "GET requires a role"},
+		{"plain text", "text/plain", "no such aisle\n", http.StatusNotFound, ErrNotFound, "Not Found", "no such aisle"},
+	} {
+		c, requests := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		})
+		_, err := c.Aisle(context.Background(), "9z")
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("%s: error %v is not an *Error", test.name, err)
+			continue
+		}
+		if !errors.Is(err, test.target) || e.Status != test.status || e.Title != test.title || e.Detail != test.detail {
+			t.Errorf("%s: error %+v, expected %d %q %q", test.name, e.Problem, test.status, test.title, test.detail)
+		}
+		if n := atomic.LoadInt32(requests); n != 1 {
+			t.Errorf("%s: %d requests, client errors are not retried", test.name, n)
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	// a request waiting on the server returns when its context is cancelled
+	release := make(chan struct{})
+	defer close(release)
+	c, _ := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := c.Aisles(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled request: error %v, expected %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled request returned after %v", elapsed)
+	}
+
+	// as does a request waiting to be retried
+	c, requests := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	c.Backoff = time.Hour
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Aisles(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("cancelled retry: error %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("cancelled retry: %d requests, expected 1", n)
+	}
+}
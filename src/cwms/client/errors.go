@@ -0,0 +1,44 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"cwms/api"
+)
+
+// Error is an api failure, the problem document the server responded with
+type Error struct {
+	api.Problem
+	RetryAfter time.Duration // the wait the server asked for before a retry, 0 when it gave none
+}
+
+// Error formats the status and detail of the failure
+func (e *Error) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%d %s", e.Status, e.Title)
+	}
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Title, e.Detail)
+}
+
+// Is matches errors by status, so errors.Is(err, client.ErrNotFound) tests for a 404
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Status == e.Status
+}
+
+// statusError returns the error matching a status
+func statusError(status int) *Error {
+	return &Error{Problem: api.Problem{Status: status, Title: http.StatusText(status)}}
+}
+
+// Errors to test api failures against with errors.Is
+var (
+	ErrBadRequest    = statusError(http.StatusBadRequest)
+	ErrUnauthorized  = statusError(http.StatusUnauthorized)
+	ErrForbidden     = statusError(http.StatusForbidden)
+	ErrNotFound      = statusError(http.StatusNotFound)
+	ErrConflict      = statusError(http.StatusConflict)
+	ErrNotAcceptable = statusError(http.StatusNotAcceptable)
+)
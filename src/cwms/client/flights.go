@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"cwms/api"
+)
+
+// Flights returns the flights
+func (c *Client) Flights(ctx context.Context) (bfl api.BasicFlightList, err error) {
+	err = c.do(ctx, http.MethodGet, "/flights", nil, nil, &bfl)
+	return
+}
+
+// Flight returns the scans of a flight
+func (c *Client) Flight(ctx context.Context, id int) (fl api.FlightList, err error) {
+	err = c.do(ctx, http.MethodGet, "/flights/"+strconv.Itoa(id), nil, nil, &fl)
+	return
+}
+
+// Reconcile reconciles a flight against the inventory
+func (c *Client) Reconcile(ctx context.Context, id int) (rr api.ReconcileResult, err error) {
+	err = c.do(ctx, http.MethodPost, "/flights/"+strconv.Itoa(id)+"/reconcile", nil, nil, &rr)
+	return
+}
+
+// Queue returns the planned mission queue
+func (c *Client) Queue(ctx context.Context) (ql api.QueueList, err error) {
+	err = c.do(ctx, http.MethodGet, "/queue", nil, nil, &ql)
+	return
+}
+
+// Mission returns a planned mission of the queue
+func (c *Client) Mission(ctx context.Context, id int) (q api.Queue, err error) {
+	err = c.do(ctx, http.MethodGet, "/queue/"+strconv.Itoa(id), nil, nil, &q)
+	return
+}
+
+// AddMission adds a mission over a named region to the end of the queue
+func (c *Client) AddMission(ctx context.Context, region string) (q api.Queue, err error) {
+	err = c.do(ctx, http.MethodPost, "/queue", nil, api.Queue{Region: region}, &q)
+	return
+}
+
+// ReorderQueue puts the queue in the order of the listed entry ids, every entry listed once, and returns the replanned queue
+func (c *Client) ReorderQueue(ctx context.Context, ids []int) (ql api.QueueList, err error) {
+	err = c.do(ctx, http.MethodPut, "/queue", nil, ids, &ql)
+	return
+}
+
+// Restrictions returns the flight restrictions
+func (c *Client) Restrictions(ctx context.Context) (rl api.RestrictionList, err error) {
+	err = c.do(ctx, http.MethodGet, "/restrictions", nil, nil, &rl)
+	return
+}
+
+// Restriction returns a flight restriction
+func (c *Client) Restriction(ctx context.Context, id int) (r api.Restriction, err error) {
+	var rl api.RestrictionList
+	if err = c.do(ctx, http.MethodGet, "/restrictions/"+strconv.Itoa(id), nil, nil, &rl); err != nil {
+		return
+	}
+	if len(rl) == 0 {
+		return r, ErrNotFound
+	}
+	return rl[0], nil
+}
+
+// AddRestriction closes a region to flights
+func (c *Client) AddRestriction(ctx context.Context, nr api.NewRestriction) (r api.Restriction, err error) {
+	err = c.do(ctx, http.MethodPost, "/restrictions", nil, nr, &r)
+	return
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"cwms/api"
+)
+
+// InventoryQuery filters, sorts and pages the inventory, blank fields are not filtered on
+type InventoryQuery struct {
+	Sku         string // sku prefix
+	Aisle       string
+	Block       string
+	Slot        string
+	Discrepancy string // discrepancy type, "all" for any discrepancy or "none" for none
+	MinAge      int    // days since the position was last scanned, at least
+	MaxAge      int    // days since the position was last scanned, at most
+	HasImage    *bool
+	Sort        []string // Wms json field names, prefixed with "-" for descending order
+	Limit       int      // page size, the server default when 0
+	Offset      int
+}
+
+// values returns the url parameters of the query
+func (iq InventoryQuery) values() url.Values {
+	v := url.Values{}
+	for name, s := range map[string]string{"sku": iq.Sku, "aisle": iq.Aisle, "block": iq.Block, "slot": iq.Slot, "discrepancy": iq.Discrepancy} {
+		if s != "" {
+			v.Set(name, s)
+		}
+	}
+	for name, n := range map[string]int{"minAge": iq.MinAge, "maxAge": iq.MaxAge, "limit": iq.Limit, "offset": iq.Offset} {
+		if n != 0 {
+			v.Set(name, strconv.Itoa(n))
+		}
+	}
+	if iq.HasImage != nil {
+		v.Set("image", strconv.FormatBool(*iq.HasImage))
+	}
+	if len(iq.Sort) > 0 {
+		v.Set("sort", strings.Join(iq.Sort, ","))
+	}
+	return v
+}
+
+// Inventory returns a page of the inventory
+func (c *Client) Inventory(ctx context.Context, iq InventoryQuery) (page api.InventoryPage, err error) {
+	err = c.do(ctx, http.MethodGet, "/inventory", iq.values(), nil, &page)
+	return
+}
+
+// AllInventory returns every page of the inventory matching the query
+func (c *Client) AllInventory(ctx context.Context, iq InventoryQuery) (wl api.WmsList, err error) {
+	for {
+		var page api.InventoryPage
+		if page, err = c.Inventory(ctx, iq); err != nil {
+			return
+		}
+		wl = append(wl, page.Items...)
+		if page.Next == "" || len(page.Items) == 0 {
+			return
+		}
+		iq.Offset = page.Offset + len(page.Items)
+	}
+}
+
+// Aisles returns the statistics of each aisle
+func (c *Client) Aisles(ctx context.Context) (asl api.AisleStatsList, err error) {
+	err = c.do(ctx, http.MethodGet, "/aisles", nil, nil, &asl)
+	return
+}
+
+// Aisle returns the inventory of an aisle
+func (c *Client) Aisle(ctx context.Context, aisle string) (wl api.WmsList, err error) {
+	err = c.do(ctx, http.MethodGet, "/aisles/"+url.PathEscape(aisle), nil, nil, &wl)
+	return
+}
+
+// Discrepancies returns the inventory with a discrepancy, of the given type unless it is blank
+func (c *Client) Discrepancies(ctx context.Context, discrepancy string) (wl api.WmsList, err error) {
+	path := "/discrepancies"
+	if discrepancy != "" {
+		path += "/" + url.PathEscape(discrepancy)
+	}
+	err = c.do(ctx, http.MethodGet, path, nil, nil, &wl)
+	return
+}
+
+// Statistics returns the inventory summary
+func (c *Client) Statistics(ctx context.Context) (s api.Statistics, err error) {
+	err = c.do(ctx, http.MethodGet, "/statistics", nil, nil, &s)
+	return
+}
+
+// Export writes the inventory matching the query to w in an export format: csv, json, xml, xlsx or ndjson
+func (c *Client) Export(ctx context.Context, format string, iq InventoryQuery, w io.Writer) (n int64, err error) {
+	var resp *http.Response
+	if resp, err = c.send(ctx, http.MethodGet, "/export/"+url.PathEscape(format), iq.values(), "", nil, "*/*"); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	return io.Copy(w, resp.Body)
+}
+
+// Import records warehouse management system records, each replacing the item at its position, given as json, or as csv when contentType is text/csv
+func (c *Client) Import(ctx context.Context, contentType string, records []byte) (ir api.ImportResult, err error) {
+	var resp *http.Response
+	if resp, err = c.send(ctx, http.MethodPost, "/import", nil, contentType, records, "application/json"); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&ir)
+	return
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"cwms/api"
+	"cwms/client"
+)
+
+// parseFlags parses the flags of a command, which may follow its arguments, and returns the arguments
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return rest, nil
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// needArgs checks a command was given exactly n arguments
+func needArgs(args []string, n int, names string) error {
+	if len(args) != n {
+		return fmt.Errorf("expected %s", names)
+	}
+	return nil
+}
+
+// inventoryFlags adds the inventory filter flags to a command, -issues for any discrepancy
+func inventoryFlags(fs *flag.FlagSet) (iq *client.InventoryQuery, issues *bool) {
+	iq = &client.InventoryQuery{}
+	fs.StringVar(&iq.Aisle, "aisle", "", "filter on aisle")
+	fs.StringVar(&iq.Sku, "sku", "", "filter on sku prefix")
+	fs.StringVar(&iq.Discrepancy, "discrepancy", "", "filter on discrepancy type")
+	issues = fs.Bool("issues", false, "positions with any discrepancy")
+	return
+}
+
+// inventoryList lists the inventory
+func inventoryList(ctx context.Context, c *client.Client, out *output, args []string) error {
+	fs := flag.NewFlagSet("inventory list", flag.ExitOnError)
+	iq, issues := inventoryFlags(fs)
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *issues {
+		iq.Discrepancy = "all"
+	}
+	iq.Limit = 1000
+	wl, err := c.AllInventory(ctx, *iq)
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, w := range wl {
+		rows = append(rows, []string{w.Aisle, w.Block, w.Slot, w.SKU.String, strconv.Itoa(w.Quantity), w.Discrepancy.String, w.StopTime.Format("2006-01-02 15:04")})
+	}
+	return out.write(wl, []string{"AISLE", "BLOCK", "SLOT", "SKU", "QUANTITY", "DISCREPANCY", "SCANNED"}, rows)
+}
+
+// aislesList lists the statistics of each aisle
+func aislesList(ctx context.Context, c *client.Client, out *output, args []string) error {
+	asl, err := c.Aisles(ctx)
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, as := range asl {
+		rows = append(rows, []string{as.Id, strconv.Itoa(as.NumberOccupied), strconv.Itoa(as.NumberEmpty), strconv.Itoa(as.NumberException),
+			strconv.Itoa(as.NumberUnscanned), strconv.Itoa(as.NumberQuantityMismatch), as.LastScanned})
+	}
+	return out.write(asl, []string{"AISLE", "OCCUPIED", "EMPTY", "EXCEPTIONS", "UNSCANNED", "QUANTITY MISMATCHES", "LAST SCANNED"}, rows)
+}
+
+// flightsList lists the flights
+func flightsList(ctx context.Context, c *client.Client, out *output, args []string) error {
+	bfl, err := c.Flights(ctx)
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, bf := range bfl {
+		rows = append(rows, []string{strconv.Itoa(bf.FlightId), bf.Time, strconv.Itoa(bf.DroneId)})
+	}
+	return out.write(bfl, []string{"ID", "TIME", "DRONE"}, rows)
+}
+
+// flightsShow lists the scans of a flight
+func flightsShow(ctx context.Context, c *client.Client, out *output, args []string) error {
+	if err := needArgs(args, 1, "a flight id"); err != nil {
+		return err
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid flight id %q", args[0])
+	}
+	fl, err := c.Flight(ctx, id)
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, f := range fl {
+		quantity := ""
+		if f.Quantity != nil {
+			quantity = strconv.Itoa(*f.Quantity)
+		}
+		rows = append(rows, []string{f.Aisle, f.Shelf, f.Slot, f.Sku, f.Occupancy, quantity, f.Gtin, f.Lot, f.Expiry})
+	}
+	return out.write(fl, []string{"AISLE", "SHELF", "SLOT", "SKU", "OCCUPANCY", "QUANTITY", "GTIN", "LOT", "EXPIRY"}, rows)
+}
+
+// writeQueue writes the planned queue
+func writeQueue(out *output, ql api.QueueList) error {
+	var rows [][]string
+	for _, q := range ql {
+		drone := ""
+		if q.DroneId != 0 {
+			drone = strconv.Itoa(q.DroneId)
+		}
+		rows = append(rows, []string{strconv.Itoa(q.Id), q.Region, strings.Join(q.Aisles, ","), drone, q.StartTime, q.StopTime, q.LastCompleted})
+	}
+	return out.write(ql, []string{"ID", "REGION", "AISLES", "DRONE", "START", "ESTIMATED STOP", "LAST COMPLETED"}, rows)
+}
+
+// queueList plans the mission queue
+func queueList(ctx context.Context, c *client.Client, out *output, args []string) error {
+	ql, err := c.Queue(ctx)
+	if err != nil {
+		return err
+	}
+	return writeQueue(out, ql)
+}
+
+// queueAdd adds a mission over a region to the end of the queue
+func queueAdd(ctx context.Context, c *client.Client, out *output, args []string) error {
+	if err := needArgs(args, 1, "a region name"); err != nil {
+		return err
+	}
+	q, err := c.AddMission(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return writeQueue(out, api.QueueList{q})
+}
+
+// queueReorder reorders the queue
+func queueReorder(ctx context.Context, c *client.Client, out *output, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected the queue entry ids in their new order")
+	}
+	var ids []int
+	for _, a := range args {
+		id, err := strconv.Atoi(a)
+		if err != nil {
+			return fmt.Errorf("invalid queue entry id %q", a)
+		}
+		ids = append(ids, id)
+	}
+	ql, err := c.ReorderQueue(ctx, ids)
+	if err != nil {
+		return err
+	}
+	return writeQueue(out, ql)
+}
+
+// writeRestrictions writes flight restrictions
+func writeRestrictions(out *output, rl api.RestrictionList) error {
+	var rows [][]string
+	for _, r := range rl {
+		rows = append(rows, []string{strconv.Itoa(r.Id), strings.Join(r.Aisles, ","), r.StartDate, r.StopDate, r.StartTime, r.StopTime,
+			fmt.Sprintf("every %d %s", r.PeriodicityNum, r.Periodicity)})
+	}
+	return out.write(rl, []string{"ID", "AISLES", "START DATE", "STOP DATE", "START", "STOP", "REPEATS"}, rows)
+}
+
+// restrictionsList lists the flight restrictions
+func restrictionsList(ctx context.Context, c *client.Client, out *output, args []string) error {
+	rl, err := c.Restrictions(ctx)
+	if err != nil {
+		return err
+	}
+	return writeRestrictions(out, rl)
+}
+
+// restrictionsAdd closes a region to flights
+func restrictionsAdd(ctx context.Context, c *client.Client, out *output, args []string) error {
+	var nr api.NewRestriction
+	fs := flag.NewFlagSet("restrictions add", flag.ExitOnError)
+	fs.StringVar(&nr.Name, "name", "", "restriction name")
+	fs.StringVar(&nr.RegionName, "region", "", "region closed to flights")
+	fs.StringVar(&nr.StartDate, "start-date", "", "first day, yyyy-mm-dd")
+	fs.StringVar(&nr.StopDate, "stop-date", "", "last day, yyyy-mm-dd")
+	fs.StringVar(&nr.StartTime, "start", "", "daily start, hh:mm")
+	fs.StringVar(&nr.StopTime, "stop", "", "daily stop, hh:mm")
+	fs.IntVar(&nr.PeriodicityNum, "every", 1, "repeat every n periods")
+	fs.StringVar(&nr.Periodicity, "periodicity", "daily", "repeat period")
+	if rest, err := parseFlags(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return fmt.Errorf("unexpected argument %q", rest[0])
+	}
+	r, err := c.AddRestriction(ctx, nr)
+	if err != nil {
+		return err
+	}
+	return writeRestrictions(out, api.RestrictionList{r})
+}
+
+// export downloads the inventory in an export format to a file, or stdout
+func export(ctx context.Context, c *client.Client, out *output, args []string) (err error) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	iq, issues := inventoryFlags(fs)
+	file := fs.String("f", "", "file to write, stdout when blank")
+	if args, err = parseFlags(fs, args); err != nil {
+		return
+	}
+	if *issues {
+		iq.Discrepancy = "all"
+	}
+	if err = needArgs(args, 1, "an export format: csv, json, xml, xlsx or ndjson"); err != nil {
+		return
+	}
+
+	var w io.Writer = os.Stdout
+	if *file != "" {
+		var f *os.File
+		if f, err = os.Create(*file); err != nil {
+			return
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = f
+	}
+	_, err = c.Export(ctx, args[0], *iq, w)
+	return
+}
+
+// importWms records the warehouse management system records of a csv or json file, each replacing the item at its position
+func importWms(ctx context.Context, c *client.Client, out *output, args []string) error {
+	if err := needArgs(args, 1, "a .csv or .json file"); err != nil {
+		return err
+	}
+	records, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	contentType := "application/json"
+	if strings.EqualFold(filepath.Ext(args[0]), ".csv") {
+		contentType = "text/csv"
+	}
+	ir, err := c.Import(ctx, contentType, records)
+	if err != nil {
+		return err
+	}
+	return out.write(ir, []string{"IMPORTED", "REPLACED", "NEW POSITIONS"},
+		[][]string{{strconv.Itoa(ir.Imported), strconv.Itoa(ir.Replaced), strconv.Itoa(ir.Positions)}})
+}
+
+// reconcileRun reconciles a flight against the inventory
+func reconcileRun(ctx context.Context, c *client.Client, out *output, args []string) error {
+	if err := needArgs(args, 1, "a flight id"); err != nil {
+		return err
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid flight id %q", args[0])
+	}
+	rr, err := c.Reconcile(ctx, id)
+	if err != nil {
+		return err
+	}
+	return out.write(rr, []string{"FLIGHT", "RECONCILED", "DISCREPANCIES"},
+		[][]string{{strconv.Itoa(rr.FlightId), strconv.Itoa(rr.Reconciled), strconv.Itoa(rr.Discrepancies)}})
+}
@@ -0,0 +1,141 @@
+// cwmsctl is the operator's command line to a Corvus WMS server, built on its api
+//
+// Usage:
+//
+//	cwmsctl [-server url] [-token token] [-site code] [-o table|json] command [arguments]
+//
+// The server and token default to the CWMS_SERVER and CWMS_TOKEN environment variables.
+// Run cwmsctl help for the commands.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cwms/client"
+)
+
+// usage lists the commands
+const usage = `usage: cwmsctl [-server url] [-token token] [-site code] [-o table|json] command [arguments]
+
+commands:
+  inventory list [-aisle a] [-sku prefix] [-issues]   list the inventory, -issues for positions with a discrepancy
+  aisles list                                         list the statistics of each aisle
+  flights list                                        list the flights
+  flights show id                                     list the scans of a flight
+  queue list                                          plan the mission queue
+  queue add region                                    add a mission over a region to the end of the queue
+  queue reorder id...                                 reorder the queue, listing every entry id
+  restrictions list                                   list the flight restrictions
+  restrictions add -name n -region r -start-date yyyy-mm-dd -stop-date yyyy-mm-dd -start hh:mm -stop hh:mm [-every n -periodicity p]
+  export format [-aisle a] [-issues] [-f file]        download the inventory as csv, json, xml, xlsx or ndjson
+  import wms file                                     record a .csv or .json file in the warehouse inventory, replacing the item at each position
+  reconcile run flight-id                             reconcile a flight against the inventory
+`
+
+// command is a cwmsctl subcommand, run with the arguments following its name
+type command func(ctx context.Context, c *client.Client, out *output, args []string) error
+
+// commands maps "noun verb", or "noun" for commands without a verb, to its command
+var commands = map[string]command{
+	"inventory list":    inventoryList,
+	"aisles list":       aislesList,
+	"flights list":      flightsList,
+	"flights show":      flightsShow,
+	"queue list":        queueList,
+	"queue add":         queueAdd,
+	"queue reorder":     queueReorder,
+	"restrictions list": restrictionsList,
+	"restrictions add":  restrictionsAdd,
+	"export":            export,
+	"import wms":        importWms,
+	"reconcile run":     reconcileRun,
+}
+
+// output writes command results as an aligned table or as json
+type output struct {
+	json bool
+}
+
+// write writes data as json, or the table of its header and rows
+func (o *output) write(data interface{}, header []string, rows [][]string) error {
+	if o.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(data)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func main() {
+	server := flag.String("server", envOr("CWMS_SERVER", "http://localhost:8080"), "server url")
+	token := flag.String("token", os.Getenv("CWMS_TOKEN"), "api bearer token")
+	site := flag.String("site", "", "site code, the default site when blank")
+	format := flag.String("o", "table", "output format, table or json")
+	timeout := flag.Duration("timeout", time.Minute, "request timeout")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 || args[0] == "help" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *format != "table" && *format != "json" {
+		fatalf("-o must be table or json")
+	}
+
+	// look up the command by noun and verb, then by noun alone
+	cmd, rest := commands[strings.Join(args[:min(2, len(args))], " ")], args[min(2, len(args)):]
+	if cmd == nil {
+		if cmd, rest = commands[args[0]], args[1:]; cmd == nil {
+			fmt.Fprintf(os.Stderr, "cwmsctl: unknown command %q\n", strings.Join(args[:min(2, len(args))], " "))
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	c := client.New(strings.TrimRight(*server, "/"), *token).WithSite(*site)
+	if err := cmd(ctx, c, &output{json: *format == "json"}, rest); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// envOr returns an environment variable, or def when it is not set
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// fatalf reports an error and exits non-zero
+func fatalf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "cwmsctl: "+format+"\n", a...)
+	os.Exit(1)
+}
+
+// min returns the smaller of two ints
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
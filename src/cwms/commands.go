@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// fixtures is the sample warehouse loaded by seed -fixtures
+//
+//go:embed testData.sql
+var fixtures string
+
+// serverUsage lists the subcommands of the server binary
+const serverUsage = `usage: cwms [-db file] [command] [arguments]
+
+commands:
+  serve [-addr :8081] [-dev]                 serve the dashboard and api, the default command
+  migrate up [-to n] | down [-steps n] | status   apply, revert or list schema migrations
+  seed [-fixtures] [file.sql...]             load the sample warehouse, or sql files, into an empty database
+  import [-site code] file                   import warehouse inventory from a .csv or .json file
+  reconcile [-flight id]                     reconcile the latest scans, or one flight, against the inventory
+  doctor                                     check the database for integrity problems
+  rebuild                                    recompute the aisle and region summaries from the inventory
+`
+
+// serverCommands maps the subcommands of the server binary to their functions, run with the arguments following the name
+var serverCommands = map[string]func(args []string) error{
+	"serve":     serveCommand,
+	"migrate":   migrateCommand,
+	"seed":      seedCommand,
+	"import":    importCommand,
+	"reconcile": reconcileCommand,
+	"doctor":    doctorCommand,
+	"rebuild":   rebuildCommand,
+}
+
+// serveCommand serves the dashboard and api until SIGINT or SIGTERM
+func serveCommand(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8081", "listen address")
+	fs.BoolVar(&devAssets, "dev", false, "reload templates and static files from the working directory")
+	fs.Parse(args)
+	serve(*addr)
+	return nil
+}
+
+// migrateCommand applies, reverts or lists the schema migrations
+func migrateCommand(args []string) (err error) {
+	if len(args) == 0 {
+		return fmt.Errorf("migrate needs up, down or status")
+	}
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	switch args[0] {
+	case "up":
+		to := fs.Int("to", 0, "version to migrate up to, the latest when 0")
+		fs.Parse(args[1:])
+		var ml []migration
+		ml, err = MigrateUp(*to)
+		for _, m := range ml {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(ml) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		steps := fs.Int("steps", 1, "migrations to revert")
+		fs.Parse(args[1:])
+		var ml []migration
+		ml, err = MigrateDown(*steps)
+		for _, m := range ml {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+	case "status":
+		var ml []migration
+		if ml, err = loadMigrations(); err != nil {
+			return
+		}
+		var current int
+		if current, err = fetchSchemaVersion(); err != nil {
+			return
+		}
+		for _, m := range ml {
+			state := "pending"
+			if m.Version <= current {
+				state = "applied"
+			}
+			fmt.Printf("%04d_%-30s %s\n", m.Version, m.Name, state)
+		}
+		fmt.Printf("database schema version %d, latest %d\n", current, len(ml))
+	default:
+		return fmt.Errorf("migrate needs up, down or status, not %q", args[0])
+	}
+	return
+}
+
+// seedCommand loads the sample warehouse and any sql files into an empty database and summarizes the loaded inventory
+func seedCommand(args []string) (err error) {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	withFixtures := fs.Bool("fixtures", false, "load the sample warehouse")
+	fs.Parse(args)
+
+	var scripts []string
+	if *withFixtures {
+		scripts = append(scripts, fixtures)
+	}
+	for _, f := range fs.Args() {
+		var b []byte
+		if b, err = ioutil.ReadFile(f); err != nil {
+			return
+		}
+		scripts = append(scripts, string(b))
+	}
+	if len(scripts) == 0 {
+		return fmt.Errorf("nothing to seed, give -fixtures or sql files")
+	}
+
+	var version int
+	if version, err = fetchSchemaVersion(); err != nil {
+		return
+	}
+	if expected := schemaVersion(); version != expected {
+		return fmt.Errorf("database schema version %d, expected %d, run cwms migrate up", version, expected)
+	}
+	var positions int
+	if err = db.QueryRow(`select count(*) from positions`).Scan(&positions); err != nil {
+		return
+	}
+	if positions > 0 {
+		return fmt.Errorf("database already has %d positions, seed an empty database", positions)
+	}
+
+	var tx *sql.Tx
+	if tx, err = db.Begin(); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	for _, script := range scripts {
+		if _, err = tx.Exec(script); err != nil {
+			return
+		}
+	}
+	if err = rebuildSummaries(tx); err != nil {
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		return
+	}
+	fmt.Printf("seeded %d sql scripts\n", len(scripts))
+	return
+}
+
+// importCommand imports warehouse inventory from a csv or json file
+func importCommand(args []string) (err error) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	code := fs.String("site", "default", "site code")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("import needs a .csv or .json file")
+	}
+
+	var s Site
+	if s, err = FetchSite(*code); err == sql.ErrNoRows {
+		return fmt.Errorf("site %q not found", *code)
+	} else if err != nil {
+		return
+	}
+	var body []byte
+	if body, err = ioutil.ReadFile(fs.Arg(0)); err != nil {
+		return
+	}
+	var wl WmsList
+	if wl, err = decodeImport(body, strings.EqualFold(filepath.Ext(fs.Arg(0)), ".csv")); err != nil {
+		return
+	}
+	var ir ImportResult
+	if ir, err = ImportInventory(s.Id, wl); err != nil {
+		return
+	}
+	fmt.Printf("imported %d records, %d replaced, %d new positions\n", ir.Imported, ir.Replaced, ir.Positions)
+	return
+}
+
+// reconcileCommand reconciles the latest scan of every position, or the scans of one flight, against the inventory
+func reconcileCommand(args []string) (err error) {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	flightId := fs.Int("flight", 0, "flight to reconcile, the latest scans of every position when 0")
+	fs.Parse(args)
+
+	if *flightId != 0 {
+		var exists bool
+		if err = db.QueryRow(`select exists (select 1 from flights where flightId = ?)`, *flightId).Scan(&exists); err != nil {
+			return
+		}
+		if !exists {
+			return fmt.Errorf("flight %d not found", *flightId)
+		}
+		var rr ReconcileResult
+		if rr, err = ReconcileFlight(*flightId); err != nil {
+			return
+		}
+		fmt.Printf("reconciled %d positions on flight %d, %d discrepancies\n", rr.Reconciled, rr.FlightId, rr.Discrepancies)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	var message string
+	message, err = reconcileJob(ctx)
+	fmt.Println(message)
+	return
+}
+
+// doctorCommand checks the schema version and runs the integrity checks, failing when any finds a problem
+func doctorCommand(args []string) error {
+	version, err := fetchSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		return fmt.Errorf("database has no schema, run cwms migrate up")
+	}
+	problems := 0
+	if expected := schemaVersion(); version != expected {
+		problems++
+		fmt.Printf("FAIL  schema version: database %d, expected %d\n", version, expected)
+	} else {
+		fmt.Printf("ok    schema version %d\n", version)
+	}
+
+	for _, dc := range doctorChecks {
+		findings, err := dc.run()
+		if err != nil {
+			return fmt.Errorf("%s: %v", dc.Name, err)
+		}
+		if len(findings) == 0 {
+			fmt.Printf("ok    %s\n", dc.Name)
+			continue
+		}
+		problems++
+		fmt.Printf("FAIL  %s: %d found\n", dc.Name, len(findings))
+		for i, f := range findings {
+			if i == 10 {
+				fmt.Printf("      ... and %d more\n", len(findings)-i)
+				break
+			}
+			fmt.Printf("      %s\n", f)
+		}
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d checks failed", problems)
+	}
+	return nil
+}
+
+// rebuildCommand recomputes the aisle and region summaries, for a database changed outside the server
+func rebuildCommand(args []string) (err error) {
+	if err = RebuildSummaries(); err != nil {
+		return
+	}
+	var aisles, regions int
+	if err = db.QueryRow(`select (select count(*) from aisleSummaries), (select count(*) from regionSummaries)`).Scan(&aisles, &regions); err != nil {
+		return
+	}
+	fmt.Printf("rebuilt %d aisle and %d region summaries\n", aisles, regions)
+	return
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed five field cron expression: minute hour day-of-month month day-of-week
+// Each field is a set of allowed values. As in cron, when both day fields are restricted a time matches either.
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	domAny, dowAny                bool
+}
+
+// cronFields are the bounds of each cron field
+var cronFields = []struct {
+	name     string
+	min, max int
+}{{"minute", 0, 59}, {"hour", 0, 23}, {"day of month", 1, 31}, {"month", 1, 12}, {"day of week", 0, 7}}
+
+// parseCron parses a cron expression such as "30 6 * * 1-5" or "*/15 * * * *"
+func parseCron(expr string) (cs cronSchedule, err error) {
+	fl := strings.Fields(expr)
+	if len(fl) != len(cronFields) {
+		return cs, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+	sets := make([]map[int]bool, len(fl))
+	for i, f := range fl {
+		if sets[i], err = parseCronField(f, cronFields[i].min, cronFields[i].max); err != nil {
+			return cs, fmt.Errorf("cron %s: %v", cronFields[i].name, err)
+		}
+	}
+	// Sunday is 0 or 7
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+	cs = cronSchedule{minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fl[2] == "*", dowAny: fl[4] == "*"}
+	return
+}
+
+// parseCronField parses a comma separated list of *, n, n-m, with an optional /step
+func parseCronField(f string, min, max int) (set map[int]bool, err error) {
+	set = make(map[int]bool)
+	for _, part := range strings.Split(f, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			r := strings.SplitN(part, "-", 2)
+			if lo, err = strconv.Atoi(r[0]); err != nil {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+			if hi, err = strconv.Atoi(r[1]); err != nil {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			if lo, err = strconv.Atoi(part); err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return
+}
+
+// matches reports whether the minute starting at t is in the schedule
+func (cs cronSchedule) matches(t time.Time) bool {
+	return cs.minute[t.Minute()] && cs.hour[t.Hour()] && cs.month[int(t.Month())] && cs.matchesDay(t)
+}
+
+// next returns the first scheduled minute after t, or the zero time if there is none within five years
+func (cs cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for end := t.AddDate(5, 0, 0); t.Before(end); {
+		switch {
+		case !cs.month[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !cs.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !cs.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !cs.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay reports whether the day of t is in the schedule
+func (cs cronSchedule) matchesDay(t time.Time) bool {
+	dom, dow := cs.dom[t.Day()], cs.dow[int(t.Weekday())]
+	switch {
+	case cs.domAny && cs.dowAny:
+		return true
+	case cs.domAny:
+		return dow
+	case cs.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import "database/sql"
+
+// doctorCheck is a database integrity check, its query selects a description of each problem found
+type doctorCheck struct {
+	Name  string
+	Query string
+}
+
+// doctorChecks are the integrity checks run by cwms doctor
+var doctorChecks = []doctorCheck{
+	{"inventory rows referencing missing positions",
+		`select 'inventory ' || inventoryId || ' position ' || IFNULL(positionId, 'null') from inventory
+		where positionId is null or positionId not in (select positionId from positions) order by inventoryId`},
+	{"inventory rows referencing missing items",
+		`select 'inventory ' || inventoryId || ' item ' || IFNULL(itemId, 'null') from inventory
+		where itemId is null or itemId not in (select itemId from items) order by inventoryId`},
+	{"duplicate positions",
+		`select 'site ' || siteId || ' aisle ' || IFNULL(aisle, '') || ' block ' || IFNULL(block, '') || ' slot ' || IFNULL(slot, '') || ' positions ' || group_concat(positionId, ',')
+		from (select positionId, siteId, json_extract(json_position, '$.aisle') as aisle, json_extract(json_position, '$.block') as block, json_extract(json_position, '$.slot') as slot from positions)
+		group by siteId, aisle, block, slot having count(*) > 1 order by siteId, aisle, block, slot`},
+	{"flights with no positions",
+		`select 'flight ' || flightId from flights
+		where flightId not in (select flightId from flightPositions where flightId is not null) order by flightId`},
+	{"regions without positions",
+		`select 'region ' || regionId || ' ' || IFNULL(name, '') from regions
+		where regionId not in (select regionId from regionPositions join positions using(positionId) where regionId is not null) order by regionId`},
+	{"stale aisle summaries, run cwms rebuild",
+		staleSummaries("aisleSummaries", "siteId, aisle", aisleSummarySelect, `'site ' || siteId || ' aisle ' || aisle`)},
+	{"stale region summaries, run cwms rebuild",
+		staleSummaries("regionSummaries", "regionId, siteId", regionSummarySelect, `'region ' || regionId`)},
+}
+
+// run returns the problems the check finds
+func (dc doctorCheck) run() (findings []string, err error) {
+	var rows *sql.Rows
+	if rows, err = db.Query(dc.Query); err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f string
+		if err = rows.Scan(&f); err != nil {
+			return
+		}
+		findings = append(findings, f)
+	}
+	err = rows.Err()
+	return
+}
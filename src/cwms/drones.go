@@ -0,0 +1,295 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Drone definition matches the drones table
+type Drone struct {
+	Id           int      `xml:"id,attr" json:"id"`
+	Name         string   `xml:"name" json:"name"`
+	Capabilities []string `xml:"capability" json:"capabilities"`
+	HomeDock     string   `xml:"homeDock" json:"homeDock"`
+	Status       string   `xml:"status" json:"status"`
+	BatteryLevel int      `xml:"batteryLevel" json:"batteryLevel"`
+	LastSeen     string   `xml:"lastSeen" json:"lastSeen"`
+}
+
+type DroneList []Drone
+
+// Telemetry is a status report from a drone, FlightId is 0 when the drone is not flying a mission
+type Telemetry struct {
+	Id           int    `json:"id"`
+	DroneId      int    `json:"droneId"`
+	FlightId     int    `json:"flightId"`
+	Time         string `json:"time"`
+	Status       string `json:"status"`
+	BatteryLevel int    `json:"batteryLevel"`
+	Aisle        string `json:"aisle"`
+}
+
+type TelemetryList []Telemetry
+
+// validDroneStatus reports whether status is a known drone status
+func validDroneStatus(status string) bool {
+	switch status {
+	case "idle", "charging", "flying", "maintenance", "offline":
+		return true
+	}
+	return false
+}
+
+// available reports whether the drone can be planned onto missions
+func (d Drone) available() bool {
+	return d.Status != "maintenance" && d.Status != "offline"
+}
+
+// can reports whether the drone has every capability in a comma separated list
+func (d Drone) can(requires string) bool {
+	for _, c := range strings.Split(requires, ",") {
+		if c = strings.TrimSpace(c); c == "" {
+			continue
+		}
+		found := false
+		for _, dc := range d.Capabilities {
+			if dc == c {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// splitCapabilities converts the stored comma separated capabilities to a list
+func splitCapabilities(s string) (cl []string) {
+	cl = []string{}
+	for _, c := range strings.Split(s, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			cl = append(cl, c)
+		}
+	}
+	return
+}
+
+const droneColumns = `droneId, IFNULL(name, ""), IFNULL(capabilities, ""), IFNULL(homeDock, ""), status, IFNULL(batteryLevel, 0), IFNULL(lastSeen, "")`
+
+// scanDrone loads a drones row selected with droneColumns
+func scanDrone(row interface{ Scan(...interface{}) error }) (d Drone, err error) {
+	var capabilities string
+	err = row.Scan(&d.Id, &d.Name, &capabilities, &d.HomeDock, &d.Status, &d.BatteryLevel, &d.LastSeen)
+	d.Capabilities = splitCapabilities(capabilities)
+	return
+}
+
+// FetchDrones performs a query on drones and returns the fleet of a site
+func FetchDrones(siteId int) (dl DroneList, err error) {
+	defer observeQuery("FetchDrones", time.Now())
+	var rows *sql.Rows
+	if rows, err = db.Query(`select `+droneColumns+` from drones where siteId = ? order by droneId`, siteId); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d Drone
+		if d, err = scanDrone(rows); err != nil {
+			return
+		}
+		dl = append(dl, d)
+	}
+	err = rows.Err()
+	return
+}
+
+// FetchDrone returns a drone of a site, sql.ErrNoRows when the site has no such drone
+func FetchDrone(siteId, id int) (Drone, error) {
+	defer observeQuery("FetchDrone", time.Now())
+	return scanDrone(db.QueryRow(`select `+droneColumns+` from drones where siteId = ? and droneId = ?`, siteId, id))
+}
+
+// CreateDrone adds a drone to the fleet of a site
+func CreateDrone(siteId int, d Drone) (Drone, error) {
+	if d.Status == "" {
+		d.Status = "idle"
+	}
+	res, err := db.Exec(`insert into drones (name, capabilities, homeDock, status, batteryLevel, siteId) values (?, ?, ?, ?, ?, ?)`,
+		d.Name, strings.Join(d.Capabilities, ","), d.HomeDock, d.Status, d.BatteryLevel, siteId)
+	if err != nil {
+		return d, err
+	}
+	id, err := res.LastInsertId()
+	d.Id = int(id)
+	d.Capabilities = splitCapabilities(strings.Join(d.Capabilities, ","))
+	return d, err
+}
+
+// RecordTelemetry stores a drone's report and updates the drone's current status and battery level
+func RecordTelemetry(t Telemetry) (Telemetry, error) {
+	if t.Time == "" {
+		t.Time = time.Now().UTC().Format("2006-01-02 15:04:05.000")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return t, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`insert into telemetry (droneId, flightId, time, status, batteryLevel, aisle) values (?, NULLIF(?, 0), ?, ?, ?, NULLIF(?, ""))`,
+		t.DroneId, t.FlightId, t.Time, t.Status, t.BatteryLevel, t.Aisle)
+	if err != nil {
+		return t, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return t, err
+	}
+	t.Id = int(id)
+	if _, err = tx.Exec(`update drones set status = ?, batteryLevel = ?, lastSeen = ? where droneId = ?`, t.Status, t.BatteryLevel, t.Time, t.DroneId); err != nil {
+		return t, err
+	}
+	return t, tx.Commit()
+}
+
+// FetchTelemetry returns a drone's most recent telemetry, newest first
+func FetchTelemetry(droneId, limit int) (tl TelemetryList, err error) {
+	defer observeQuery("FetchTelemetry", time.Now())
+	var rows *sql.Rows
+	if rows, err = db.Query(`select telemetryId, droneId, IFNULL(flightId, 0), time, status, IFNULL(batteryLevel, 0), IFNULL(aisle, "") from telemetry where droneId = ? order by telemetryId desc limit ?`, droneId, limit); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var t Telemetry
+	for rows.Next() {
+		if err = rows.Scan(StructForScan(&t)...); err != nil {
+			return
+		}
+		tl = append(tl, t)
+	}
+	err = rows.Err()
+	return
+}
+
+// handleApiDrones is the endpoint for the drone fleet restful api
+// accepts:
+//
+//	GET  /api/v1/drones
+//	POST /api/v1/drones   {"name": "corvus-3", "capabilities": ["barcode"], "homeDock": "dock-c"}
+func handleApiDrones(w http.ResponseWriter, r *http.Request) {
+	siteId := requestSite(r).Id
+	if r.Method == http.MethodPost {
+		var d Drone
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			apiFail(w, r, errBadRequest("%v", err))
+			return
+		}
+		if d.Status != "" && !validDroneStatus(d.Status) {
+			apiFail(w, r, errBadRequest("invalid status %q", d.Status))
+			return
+		}
+		d, err := CreateDrone(siteId, d)
+		if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if err = jsonApi(w, r, d, false); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	dl, err := FetchDrones(siteId)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if err = jsonApi(w, r, dl, false); err != nil {
+		log.Println(err)
+	}
+}
+
+// requestDrone fetches the drone addressed by the id path parameter, writing the error response when there is none
+func requestDrone(w http.ResponseWriter, r *http.Request) (d Drone, ok bool) {
+	id, err := pathInt(pathParam(r, "id"), "drone id")
+	if err == nil {
+		if d, err = FetchDrone(requestSite(r).Id, id); err == sql.ErrNoRows {
+			err = errNotFound("drone %d not found", id)
+		}
+	}
+	if err != nil {
+		apiFail(w, r, err)
+		return d, false
+	}
+	return d, true
+}
+
+// handleApiDrone is the endpoint for a drone of the fleet
+// accepts:
+//
+//	GET /api/v1/drones/:id
+func handleApiDrone(w http.ResponseWriter, r *http.Request) {
+	d, ok := requestDrone(w, r)
+	if !ok {
+		return
+	}
+	if err := jsonApi(w, r, d, false); err != nil {
+		log.Println(err)
+	}
+}
+
+// handleApiDroneTelemetry is the endpoint for the telemetry reported by a drone
+// accepts:
+//
+//	GET  /api/v1/drones/:id/telemetry
+//	POST /api/v1/drones/:id/telemetry   {"flightId": 3, "status": "flying", "batteryLevel": 80, "aisle": "1b"}
+func handleApiDroneTelemetry(w http.ResponseWriter, r *http.Request) {
+	d, ok := requestDrone(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPost {
+		tl, err := FetchTelemetry(d.Id, 100)
+		if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if err = jsonApi(w, r, tl, false); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	var t Telemetry
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		apiFail(w, r, errBadRequest("%v", err))
+		return
+	}
+	if !validDroneStatus(t.Status) {
+		apiFail(w, r, errBadRequest("invalid status %q", t.Status))
+		return
+	}
+	t.DroneId = d.Id
+	if t.FlightId != 0 {
+		var droneId int
+		if err := db.QueryRow(`select IFNULL(droneId, 0) from flights where flightId = ?`, t.FlightId).Scan(&droneId); err != nil || droneId != d.Id {
+			apiFail(w, r, errBadRequest("flight %d was not flown by drone %d", t.FlightId, d.Id))
+			return
+		}
+	}
+	t, err := RecordTelemetry(t)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if err = jsonApi(w, r, t, false); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"cwms/api"
+	"github.com/mattn/go-sqlite3"
+)
+
+// apiError is an api failure and the http status it is reported with
+type apiError struct {
+	Status int
+	Detail string
+	cause  error // logged for internal errors, never shown to clients
+}
+
+func (e *apiError) Error() string {
+	if e.cause != nil {
+		return e.cause.Error()
+	}
+	return e.Detail
+}
+
+func (e *apiError) Unwrap() error { return e.cause }
+
+// errBadRequest reports invalid parameters or request bodies
+func errBadRequest(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusBadRequest, Detail: fmt.Sprintf(format, args...)}
+}
+
+// errNotFound reports a resource that does not exist, or is not visible at the request's site
+func errNotFound(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusNotFound, Detail: fmt.Sprintf(format, args...)}
+}
+
+// errConflict reports a request that conflicts with the current state of a resource
+func errConflict(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusConflict, Detail: fmt.Sprintf(format, args...)}
+}
+
+// errUnauthorized reports a missing or unknown api token
+func errUnauthorized(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusUnauthorized, Detail: fmt.Sprintf(format, args...)}
+}
+
+// errForbidden reports a user without the role a request needs
+func errForbidden(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusForbidden, Detail: fmt.Sprintf(format, args...)}
+}
+
+// errNotAcceptable reports an Accept header the api has no representation for
+func errNotAcceptable(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusNotAcceptable, Detail: fmt.Sprintf(format, args...)}
+}
+
+// errMethodNotAllowed reports a method the endpoint does not accept
+func errMethodNotAllowed(r *http.Request) error {
+	return &apiError{Status: http.StatusMethodNotAllowed, Detail: fmt.Sprintf("%s is not allowed on %s", r.Method, r.URL.Path)}
+}
+
+// problem is an RFC 7807 problem document, the body of every api error response
+type problem = api.Problem
+
+// toApiError classifies an error: sql.ErrNoRows is not found, a unique constraint violation is a conflict
+// and anything unclassified is internal
+func toApiError(err error) *apiError {
+	var ae *apiError
+	var se sqlite3.Error
+	switch {
+	case errors.As(err, &ae):
+		return ae
+	case errors.Is(err, sql.ErrNoRows):
+		return &apiError{Status: http.StatusNotFound, Detail: "not found", cause: err}
+	case errors.As(err, &se) && se.ExtendedCode == sqlite3.ErrConstraintUnique:
+		return &apiError{Status: http.StatusConflict, Detail: "already exists", cause: err}
+	default:
+		return &apiError{Status: http.StatusInternalServerError, cause: err}
+	}
+}
+
+// apiFail writes an error as a problem document with its status
+// Internal errors are logged and reported without their cause.
+func apiFail(w http.ResponseWriter, r *http.Request, err error) {
+	ae := toApiError(err)
+	if ae.Status >= http.StatusInternalServerError {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, ae)
+	}
+	p := problem{Type: "about:blank", Title: http.StatusText(ae.Status), Status: ae.Status, Detail: ae.Detail, Instance: r.URL.Path}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Add("Vary", "Origin")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(ae.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Println(err)
+	}
+}
+
+// apiNotFound is the handler for unknown api routes
+func apiNotFound(w http.ResponseWriter, r *http.Request) {
+	apiFail(w, r, errNotFound("no api route %s", r.URL.Path))
+}
+
+// pathInt parses an integer path segment, such as an id, reporting a bad request when it is not a number
+func pathInt(segment, name string) (int, error) {
+	n, err := strconv.Atoi(segment)
+	if err != nil {
+		return 0, errBadRequest("invalid %s %q", name, segment)
+	}
+	return n, nil
+}
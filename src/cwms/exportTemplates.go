@@ -0,0 +1,310 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// wmsDateFields are the Wms fields holding yyyy-mm-dd dates
+var wmsDateFields = map[string]bool{"expiry": true}
+
+// exportDateFormats are the named date formats accepted by export templates, any other format is a Go time layout
+var exportDateFormats = map[string]string{
+	"rfc3339":  time.RFC3339,
+	"date":     "2006-01-02",
+	"datetime": "2006-01-02 15:04:05",
+}
+
+// wmsColumn is an exported Wms field with its header label
+type wmsColumn struct {
+	Name       string // header label
+	index      int    // Wms field index
+	date       bool   // field holds a yyyy-mm-dd date
+	dateFormat string // layout for dates and times, blank for the defaults
+}
+
+// wmsFields returns a column for each Wms field keyed by its json name, labelled by its csv tag where it has one
+func wmsFields() map[string]wmsColumn {
+	fm := make(map[string]wmsColumn)
+	rt := reflect.TypeOf(Wms{})
+	for i := 0; i < rt.NumField(); i++ {
+		field := strings.Split(rt.Field(i).Tag.Get("json"), ",")[0]
+		label := strings.Split(rt.Field(i).Tag.Get("csv"), ",")[0]
+		if label == "" || label == "-" {
+			label = field
+		}
+		fm[field] = wmsColumn{Name: label, index: i, date: wmsDateFields[field]}
+	}
+	return fm
+}
+
+// wmsCsvColumns returns the Wms fields exported by default, in field order
+// Fields tagged csv:"-" are not exported.
+func wmsCsvColumns() (cl []wmsColumn) {
+	rt := reflect.TypeOf(Wms{})
+	for i := 0; i < rt.NumField(); i++ {
+		name := strings.Split(rt.Field(i).Tag.Get("csv"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		field := strings.Split(rt.Field(i).Tag.Get("json"), ",")[0]
+		cl = append(cl, wmsColumn{Name: name, index: i, date: wmsDateFields[field]})
+	}
+	return
+}
+
+// value returns the column of a record as a string, an int or a time.Time
+func (c wmsColumn) value(record *Wms) interface{} {
+	switch v := reflect.ValueOf(record).Elem().Field(c.index).Interface().(type) {
+	case NullString:
+		return v.String
+	case int, string, time.Time:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// text returns the column of a record formatted for a text export
+func (c wmsColumn) text(record *Wms) string {
+	switch v := c.value(record).(type) {
+	case time.Time:
+		if c.dateFormat == "unix" {
+			return strconv.FormatInt(v.Unix(), 10)
+		}
+		if c.dateFormat != "" {
+			return v.Format(c.dateFormat)
+		}
+		return v.Format(time.RFC3339Nano)
+	case string:
+		if c.date && c.dateFormat != "" && v != "" {
+			if t, err := time.Parse("2006-01-02", v); err == nil {
+				if c.dateFormat == "unix" {
+					return strconv.FormatInt(t.Unix(), 10)
+				}
+				return t.Format(c.dateFormat)
+			}
+		}
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// jsonValue returns the column of a record for a json export, numbers stay numbers
+func (c wmsColumn) jsonValue(record *Wms) interface{} {
+	if v, ok := c.value(record).(int); ok {
+		return v
+	}
+	return c.text(record)
+}
+
+// ExportField is a Wms field, by json name, and the header label it is exported under
+type ExportField struct {
+	Field string `json:"field"`
+	Label string `json:"label"`
+}
+
+// ExportTemplate is a saved export column set and format, matches the exportTemplates table
+type ExportTemplate struct {
+	Id         int           `json:"id"`
+	Name       string        `json:"name"`
+	Fields     []ExportField `json:"fields"`
+	DateFormat string        `json:"dateFormat"` // rfc3339, date, datetime, unix or a Go time layout, blank for the defaults
+	Delimiter  string        `json:"delimiter"`  // csv field delimiter, blank for a comma
+}
+
+// exportLayout is the column set and formatting of an export
+type exportLayout struct {
+	Columns   []wmsColumn
+	Delimiter rune
+	Custom    bool // a template chose the columns
+	PerAisle  bool // workbooks have a worksheet per aisle
+}
+
+// defaultLayout exports the Wms csv columns
+func defaultLayout() exportLayout {
+	return exportLayout{Columns: wmsCsvColumns(), Delimiter: ','}
+}
+
+// validate checks the template fields, date format and delimiter and fills in default labels
+func (et *ExportTemplate) validate() error {
+	if et.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(et.Fields) == 0 {
+		return fmt.Errorf("at least one field is required")
+	}
+	fm := wmsFields()
+	for i, f := range et.Fields {
+		c, ok := fm[f.Field]
+		if !ok {
+			return fmt.Errorf("unknown field %q", f.Field)
+		}
+		if f.Label == "" {
+			et.Fields[i].Label = c.Name
+		}
+	}
+	if et.DateFormat != "" && et.DateFormat != "unix" && exportDateFormats[et.DateFormat] == "" {
+		ref := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		if ref.Format(et.DateFormat) == et.DateFormat {
+			return fmt.Errorf("invalid date format %q", et.DateFormat)
+		}
+	}
+	if et.Delimiter != "" {
+		r, n := utf8.DecodeRuneInString(et.Delimiter)
+		if n != len(et.Delimiter) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+			return fmt.Errorf("delimiter must be a single character other than a quote or newline")
+		}
+	}
+	return nil
+}
+
+// layout returns the export layout of the template
+func (et ExportTemplate) layout() (el exportLayout) {
+	el = exportLayout{Delimiter: ',', Custom: true}
+	if et.Delimiter != "" {
+		el.Delimiter, _ = utf8.DecodeRuneInString(et.Delimiter)
+	}
+	dateFormat := et.DateFormat
+	if f, ok := exportDateFormats[dateFormat]; ok {
+		dateFormat = f
+	}
+	fm := wmsFields()
+	for _, f := range et.Fields {
+		c := fm[f.Field]
+		c.Name = f.Label
+		c.dateFormat = dateFormat
+		el.Columns = append(el.Columns, c)
+	}
+	return
+}
+
+// scanExportTemplate loads an exportTemplates row
+func scanExportTemplate(row interface{ Scan(...interface{}) error }) (et ExportTemplate, err error) {
+	var fields string
+	if err = row.Scan(&et.Id, &et.Name, &fields, &et.DateFormat, &et.Delimiter); err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(fields), &et.Fields)
+	return
+}
+
+const exportTemplateColumns = `templateId, name, fields, IFNULL(dateFormat, ""), IFNULL(delimiter, "")`
+
+// FetchExportTemplates performs a query on exportTemplates and returns the templates of a site
+func FetchExportTemplates(siteId int) (etl []ExportTemplate, err error) {
+	defer observeQuery("FetchExportTemplates", time.Now())
+	var rows *sql.Rows
+	if rows, err = db.Query(`select `+exportTemplateColumns+` from exportTemplates where siteId = ? order by name`, siteId); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var et ExportTemplate
+		if et, err = scanExportTemplate(rows); err != nil {
+			return
+		}
+		etl = append(etl, et)
+	}
+	err = rows.Err()
+	return
+}
+
+// FetchExportTemplate returns a template of a site by name, sql.ErrNoRows when there is none
+func FetchExportTemplate(siteId int, name string) (ExportTemplate, error) {
+	defer observeQuery("FetchExportTemplate", time.Now())
+	return scanExportTemplate(db.QueryRow(`select `+exportTemplateColumns+` from exportTemplates where siteId = ? and name = ?`, siteId, name))
+}
+
+// SaveExportTemplate stores a template for a site, replacing any template of the same name
+func SaveExportTemplate(siteId int, et ExportTemplate) (ExportTemplate, error) {
+	fields, err := json.Marshal(et.Fields)
+	if err != nil {
+		return et, err
+	}
+	if _, err = db.Exec(`insert or replace into exportTemplates (siteId, name, fields, dateFormat, delimiter) values (?, ?, ?, ?, ?)`,
+		siteId, et.Name, string(fields), et.DateFormat, et.Delimiter); err != nil {
+		return et, err
+	}
+	return FetchExportTemplate(siteId, et.Name)
+}
+
+// DeleteExportTemplate removes a template of a site
+func DeleteExportTemplate(siteId int, name string) (err error) {
+	_, err = db.Exec(`delete from exportTemplates where siteId = ? and name = ?`, siteId, name)
+	return
+}
+
+// handleApiExportTemplates is the endpoint for export templates restful api
+// accepts:
+//
+//	GET    /api/v1/export/templates
+//	GET    /api/v1/export/templates/:name
+//	POST   /api/v1/export/templates         {"name": "acme", "fields": [{"field": "sku", "label": "Item"}], "dateFormat": "date", "delimiter": ";"}
+//	DELETE /api/v1/export/templates/:name
+//
+// Exports use a template with ?template=:name
+func handleApiExportTemplates(w http.ResponseWriter, r *http.Request) {
+	siteId := requestSite(r).Id
+	name := pathParam(r, "name")
+
+	switch {
+	case r.Method == http.MethodPost:
+		var et ExportTemplate
+		if err := json.NewDecoder(r.Body).Decode(&et); err != nil {
+			apiFail(w, r, errBadRequest("%v", err))
+			return
+		}
+		if err := et.validate(); err != nil {
+			apiFail(w, r, errBadRequest("%v", err))
+			return
+		}
+		et, err := SaveExportTemplate(siteId, et)
+		if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if err = jsonApi(w, r, et, false); err != nil {
+			log.Println(err)
+		}
+	case r.Method == http.MethodDelete:
+		if err := DeleteExportTemplate(siteId, name); err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if err := jsonApi(w, r, nil, false); err != nil {
+			log.Println(err)
+		}
+	case name != "":
+		et, err := FetchExportTemplate(siteId, name)
+		if err == sql.ErrNoRows {
+			apiFail(w, r, errNotFound("export template %q not found", name))
+			return
+		} else if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if err = jsonApi(w, r, et, false); err != nil {
+			log.Println(err)
+		}
+	default:
+		etl, err := FetchExportTemplates(siteId)
+		if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if err = jsonApi(w, r, etl, false); err != nil {
+			log.Println(err)
+		}
+	}
+}
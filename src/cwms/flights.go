@@ -4,31 +4,30 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
+
+	"cwms/api"
 )
 
-type basicFlight struct {
-	FlightId int    `json:"id" db:"flightId"`
-	Time     string `json:"time" db:"time"`
-}
+// Flight is a position scan of a flight, BasicFlight the flight it was scanned on
+type (
+	BasicFlight     = api.BasicFlight
+	Flight          = api.Flight
+	BasicFlightList = api.BasicFlightList
+	FlightList      = api.FlightList
+)
 
-type flight struct {
-	FlightId  int    `json:"id" db:"flightId"`
-	Time      string `json:"time" db:"time"`
-	Sku       string `json:"sku" db:"sku"`
-	Occupancy string `json:"occupancy" db:"occupancy"`
-	Aisle     string `json:"aisle" db:"aisle"`
-	Shelf     string `json:"shelf" db:"shelf"`
-	Slot      string `json:"slot" db:"slot"`
-}
+// FlightUploadResult is a stored flight upload with the barcode elements that could not be decoded
+type FlightUploadResult = api.FlightUploadResult
 
-func (f basicFlight) toFieldList() (fl []string) {
-	rt := reflect.TypeOf(f)
+// toFieldList returns the db column names of a struct's fields
+func toFieldList(v interface{}) (fl []string) {
+	rt := reflect.TypeOf(v)
 	for i := 0; i < rt.NumField(); i++ {
 		field := rt.Field(i)
 		fl = append(fl, field.Tag.Get("db"))
@@ -36,48 +35,7 @@ func (f basicFlight) toFieldList() (fl []string) {
 	return
 }
 
-func (f flight) toFieldList() (fl []string) {
-	rt := reflect.TypeOf(f)
-	for i := 0; i < rt.NumField(); i++ {
-		field := rt.Field(i)
-		fl = append(fl, field.Tag.Get("db"))
-	}
-	return
-}
-
-func (f basicFlight) toPlaceholderList() (phl []string) {
-	rt := reflect.TypeOf(f)
-	for i := 0; i < rt.NumField(); i++ {
-		phl = append(phl, "?")
-	}
-	return
-}
-
-func (f flight) toPlaceholderList() (phl []string) {
-	rt := reflect.TypeOf(f)
-	for i := 0; i < rt.NumField(); i++ {
-		phl = append(phl, "?")
-	}
-	return
-}
-
-func (f basicFlight) toInterfaceList() (il []interface{}) {
-	v := reflect.ValueOf(f)
-	for i := 0; i < v.NumField(); i++ {
-		il = append(il, v.Field(i).Interface())
-	}
-	return
-}
-
-func (f flight) toInterfaceList() (il []interface{}) {
-	v := reflect.ValueOf(f)
-	for i := 0; i < v.NumField(); i++ {
-		il = append(il, v.Field(i).Interface())
-	}
-	return
-}
-
-func convertInterfaceListToBasicFlight(il []interface{}) (f basicFlight) {
+func convertInterfaceListToBasicFlight(il []interface{}) (f BasicFlight) {
 	v := reflect.ValueOf(&f).Elem()
 	for i := 0; i < v.NumField(); i++ {
 		switch il[i].(type) {
@@ -92,10 +50,6 @@ func convertInterfaceListToBasicFlight(il []interface{}) (f basicFlight) {
 	return
 }
 
-type basicFlightList []basicFlight
-
-type flightList []flight
-
 type flightFilter struct {
 	FlightId int
 	Sku      string
@@ -106,48 +60,57 @@ type flightFilter struct {
 	Offset   int
 	Sort     string
 	Order_by string
+	SiteId   int `json:"-"`
 }
 
-func (ff flightFilter) toSqlSelect() (sqlstmt string) {
+// toSqlSelect generates a sql statement and its arguments
+// Sort must name a column of v_flightList and Order_by be asc or desc, otherwise the flights are not sorted.
+func (ff flightFilter) toSqlSelect() (sqlstmt string, args []interface{}) {
 
 	var sel, ord, limit string
 
 	// Format select statement using field list
-	var f flight
-	sel = fmt.Sprintf("select %s from v_flightList", strings.Join(f.toFieldList(), ", "))
+	fields := toFieldList(Flight{})
+	sel = fmt.Sprintf("select %s from v_flightList", strings.Join(fields, ", "))
 
 	// Accumulate where clauses
 	var where []string
+	if ff.SiteId != 0 {
+		where = append(where, `siteId = ?`)
+		args = append(args, ff.SiteId)
+	}
 	if ff.FlightId != 0 {
-		where = append(where, fmt.Sprintf(`flightId='%v'`, ff.FlightId))
+		where = append(where, `flightId = ?`)
+		args = append(args, ff.FlightId)
 	} else {
-		if ff.Sku != "" {
-			where = append(where, fmt.Sprintf(`sku LIKE '%%%s%%'`, ff.Sku))
-		}
-		if ff.Before != "" {
-			where = append(where, fmt.Sprintf(`before LIKE '%%%s%%'`, ff.Sku))
-		}
-		if ff.After != "" {
-			where = append(where, fmt.Sprintf(`after LIKE '%%%s%%'`, ff.Sku))
-		}
-		if ff.Aisle != "" {
-			where = append(where, fmt.Sprintf(`aisle LIKE '%%%s%%'`, ff.Sku))
+		for _, like := range []struct{ column, value string }{
+			{"sku", ff.Sku}, {"before", ff.Before}, {"after", ff.After}, {"aisle", ff.Aisle},
+		} {
+			if like.value != "" {
+				where = append(where, like.column+` LIKE '%' || ? || '%'`)
+				args = append(args, like.value)
+			}
 		}
 	}
 
-	// Format order by
-	if ff.Sort != "" {
-		ord = fmt.Sprintf(" order by %s %s", ff.Sort, ff.Order_by)
+	// Format order by, the column and direction cannot be bound so only known ones are used
+	for _, f := range fields {
+		if ff.Sort != "" && ff.Sort == f {
+			ord = " order by " + f
+			if dir := strings.ToLower(ff.Order_by); dir == "asc" || dir == "desc" {
+				ord += " " + dir
+			}
+		}
 	}
 
 	// Format limit and offset
-	var lim []string
 	if ff.Limit != 0 {
-		lim = append(lim, fmt.Sprintf("%v", ff.Limit))
+		limit = " LIMIT ?"
+		args = append(args, ff.Limit)
 		if ff.Offset != 0 {
-			lim = append(lim, fmt.Sprintf("%v", ff.Offset))
+			limit += " OFFSET ?"
+			args = append(args, ff.Offset)
 		}
-		limit = fmt.Sprintf(" LIMIT %s", strings.Join(lim, ", "))
 	}
 
 	// Format sql statement
@@ -173,15 +136,16 @@ func (ff flightFilter) toSqlSelect() (sqlstmt string) {
 	return
 }
 
-func FetchBasicFlights() (bfl basicFlightList, err error) {
+func FetchBasicFlights(siteId int) (bfl BasicFlightList, err error) {
+	defer observeQuery("FetchBasicFlights", time.Now())
 	// Execute database query
 	var rows *sql.Rows
-	if rows, err = db.Query("select distinct flightId, time from v_flightList"); err != nil {
+	if rows, err = db.Query("select distinct flightId, time, droneId from v_flightList where siteId = ?", siteId); err != nil {
 		return
 	}
 	defer rows.Close()
 
-	var bf basicFlight
+	var bf BasicFlight
 	// Process query results
 	for rows.Next() {
 		// Load query results into interface list via the pointers
@@ -206,16 +170,18 @@ func StructForScan(u interface{}) []interface{} {
 }
 
 // FetchInventory performs a query on v_inventory and returns the results in a WmsList.
-func FetchFlights(ff flightFilter) (fl flightList, err error) {
+func FetchFlights(ff flightFilter) (fl FlightList, err error) {
+	defer observeQuery("FetchFlights", time.Now())
 	// Execute database query
 	var rows *sql.Rows
-	if rows, err = db.Query(ff.toSqlSelect()); err != nil {
+	sqlstmt, args := ff.toSqlSelect()
+	if rows, err = db.Query(sqlstmt, args...); err != nil {
 		return
 	}
 	defer rows.Close()
 
 	// Process query results
-	var f flight
+	var f Flight
 	for rows.Next() {
 		// Load query results into struct
 		if err = rows.Scan(StructForScan(&f)...); err != nil {
@@ -228,64 +194,233 @@ func FetchFlights(ff flightFilter) (fl flightList, err error) {
 	return
 }
 
-// createFilter implements a simple api to read a wms list in a json format
-func createFilter(r *http.Request) (ff flightFilter, err error) {
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Println(err)
+// flightScan is a single position scan within a flight upload
+// The position is identified by id, or by aisle, block and slot.
+type flightScan struct {
+	PositionId int    `json:"positionId"`
+	Aisle      string `json:"aisle"`
+	Block      string `json:"block"`
+	Slot       string `json:"slot"`
+	Sku        string `json:"sku"`
+	Occupancy  string `json:"occupancy"`
+	Barcode    string `json:"barcode"`
+	Lot        string `json:"lot"`
+	Expiry     string `json:"expiry"`
+	Quantity   *int   `json:"quantity"` // observed cases or pallets, omitted when not counted
+	gs1        GS1
+}
+
+// flightUpload is the flight ingest request body
+type flightUpload struct {
+	Time      string       `json:"time"`
+	DroneId   int          `json:"droneId"`
+	Positions []flightScan `json:"positions"`
+}
+
+// decode validates the upload and decodes each barcode payload into its GS1 fields
+// Element strings of unknown AIs do not reject the upload, each is reported as a warning of its scan.
+func (fu *flightUpload) decode() (warnings []string, err error) {
+	if fu.Time == "" {
+		return nil, fmt.Errorf("flight time is required")
 	}
-	err = json.Unmarshal(body, &ff)
-	if err != nil {
-		log.Println(err)
+	if fu.DroneId == 0 {
+		return nil, fmt.Errorf("droneId is required")
+	}
+	for i := range fu.Positions {
+		fs := &fu.Positions[i]
+		if fs.PositionId == 0 && (fs.Aisle == "" || fs.Block == "" || fs.Slot == "") {
+			return nil, fmt.Errorf("position %d: positionId or aisle, block and slot are required", i)
+		}
+		if fs.Barcode != "" {
+			if fs.gs1, err = DecodeGS1(fs.Barcode); err != nil {
+				return nil, fmt.Errorf("position %d: %v", i, err)
+			}
+			for _, u := range fs.gs1.Unknown {
+				warnings = append(warnings, fmt.Sprintf("position %d: unknown GS1 AI in %q, not decoded", i, u))
+			}
+			// a label without a plain sku is identified by its trade item or logistic unit
+			if fs.Sku == "" {
+				if fs.gs1.GTIN != "" {
+					fs.Sku = fs.gs1.GTIN
+				} else {
+					fs.Sku = fs.gs1.SSCC
+				}
+			}
+			// the label takes precedence over separately reported lot and expiry
+			if fs.gs1.Lot != "" {
+				fs.Lot = fs.gs1.Lot
+			}
+			if fs.gs1.Expiry != "" {
+				fs.Expiry = fs.gs1.Expiry
+			}
+			// a logistic unit label may carry the count of trade items it contains
+			if fs.Quantity == nil && fs.gs1.Count != "" {
+				n, cerr := strconv.Atoi(fs.gs1.Count)
+				if cerr != nil {
+					return nil, fmt.Errorf("position %d: invalid count %q", i, fs.gs1.Count)
+				}
+				fs.Quantity = &n
+			}
+		}
+		if fs.Quantity != nil && *fs.Quantity < 0 {
+			return nil, fmt.Errorf("position %d: quantity %d is negative", i, *fs.Quantity)
+		}
+		if fs.Expiry != "" {
+			if _, err = time.Parse("2006-01-02", fs.Expiry); err != nil {
+				return nil, fmt.Errorf("position %d: expiry %q is not yyyy-mm-dd", i, fs.Expiry)
+			}
+		}
 	}
 	return
 }
 
-func handleApiFlights(w http.ResponseWriter, r *http.Request) {
-	// Fetch inventory based on page controls
-	var ff flightFilter
-
-	// Get segment list from request, set flight filter if the last segment is a flight aisle
-	// either process a flight number or a flight filter
-	sl := strings.Split(r.URL.Path, "/")
-	if len(sl) > 0 {
-		ls := sl[len(sl)-1]
-		if ls != "" {
-			ff.FlightId, _ = strconv.Atoi(ls)
-		} else {
-			// myff, _ := createFilter(r)
-			// log.Println(myff)
-			// switch r.Method {
-			// case http.MethodGet:
-			// 	log.Println("Serve the resource.")
-			// case http.MethodPost:
-			// 	log.Println("Create a new record.")
-			// }
+// resolvePosition returns the position id of a scan at a site, looking it up by aisle, block and slot if necessary
+func (fs flightScan) resolvePosition(tx *sql.Tx, siteId int) (id int, err error) {
+	if fs.PositionId != 0 {
+		err = tx.QueryRow(`select positionId from positions where positionId = ? and siteId = ?`, fs.PositionId, siteId).Scan(&id)
+		if err == sql.ErrNoRows {
+			err = errBadRequest("no position %d", fs.PositionId)
 		}
+		return
 	}
+	err = tx.QueryRow(`select positionId from positions where siteId = ? and json_extract(json_position, '$.aisle') = ? and json_extract(json_position, '$.block') = ? and json_extract(json_position, '$.slot') = ?`,
+		siteId, fs.Aisle, fs.Block, fs.Slot).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = errBadRequest("no position at aisle %s block %s slot %s", fs.Aisle, fs.Block, fs.Slot)
+	}
+	return
+}
 
-	if ff.FlightId == 0 {
-		// Fetch inventory filtered by aisle filter
-		wl, err := FetchBasicFlights()
+// CreateFlight stores a decoded flight upload flown by a drone of a site and returns the new flight id
+func CreateFlight(siteId int, fu flightUpload) (flightId int, err error) {
+	var tx *sql.Tx
+	if tx, err = db.Begin(); err != nil {
+		return
+	}
+	defer func() {
 		if err != nil {
-			log.Println(err)
+			tx.Rollback()
 		}
+	}()
 
-		// Send filter inventory in json response
-		if err := jsonApi(w, r, wl, true); err != nil {
-			log.Println(err)
-		}
-	} else {
+	var droneSite int
+	if err = tx.QueryRow(`select siteId from drones where droneId = ?`, fu.DroneId).Scan(&droneSite); err == sql.ErrNoRows || (err == nil && droneSite != siteId) {
+		err = errBadRequest("no drone %d", fu.DroneId)
+		return
+	} else if err != nil {
+		return
+	}
 
-		// Fetch inventory filtered by aisle filter
-		wl, err := FetchFlights(ff)
-		if err != nil {
-			log.Println(err)
-		}
+	var res sql.Result
+	if res, err = tx.Exec(`insert into flights (time, siteId, droneId) values (?, ?, ?)`, fu.Time, siteId, fu.DroneId); err != nil {
+		return
+	}
+	var id int64
+	if id, err = res.LastInsertId(); err != nil {
+		return
+	}
+	flightId = int(id)
 
-		// Send filter inventory in json response
-		if err := jsonApi(w, r, wl, true); err != nil {
-			log.Println(err)
+	for _, fs := range fu.Positions {
+		var positionId int
+		if positionId, err = fs.resolvePosition(tx, siteId); err != nil {
+			return
 		}
+		if _, err = tx.Exec(`insert into flightPositions (flightId, positionId, sku, occupancy, barcode, gtin, lot, expiry, sscc, serial, quantity) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			flightId, positionId, fs.Sku, fs.Occupancy, fs.Barcode, fs.gs1.GTIN, fs.Lot, fs.Expiry, fs.gs1.SSCC, fs.gs1.Serial, fs.Quantity); err != nil {
+			return
+		}
+	}
+	err = tx.Commit()
+	return
+}
+
+// handleApiFlightUpload ingests a flight, reconciles it against the inventory and responds with the stored flight
+// and the barcode elements that could not be decoded
+func handleApiFlightUpload(w http.ResponseWriter, r *http.Request) {
+	var fu flightUpload
+	if err := json.NewDecoder(r.Body).Decode(&fu); err != nil {
+		apiFail(w, r, errBadRequest("%v", err))
+		return
+	}
+	warnings, err := fu.decode()
+	if err != nil {
+		apiFail(w, r, errBadRequest("%v", err))
+		return
+	}
+
+	site := requestSite(r)
+	flightId, err := CreateFlight(site.Id, fu)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	flightsIngested.Inc(site.Code)
+	if _, err = ReconcileFlight(flightId); err != nil {
+		log.Println(err)
+	}
+
+	fur := FlightUploadResult{FlightId: flightId, Warnings: warnings}
+	if fur.Scans, err = FetchFlights(flightFilter{FlightId: flightId, SiteId: site.Id}); err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if err = jsonApi(w, r, fur, true); err != nil {
+		log.Println(err)
+	}
+}
+
+// handleApiFlights is the endpoint for flights restful api
+// accepts:
+//
+//	GET  /api/v1/flights
+//	POST /api/v1/flights   flight upload with raw barcode payloads
+func handleApiFlights(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		handleApiFlightUpload(w, r)
+		return
+	}
+
+	// Fetch the flights of the site
+	wl, err := FetchBasicFlights(requestSite(r).Id)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+
+	// Send flights in json response
+	if err := jsonApi(w, r, wl, true); err != nil {
+		log.Println(err)
+	}
+}
+
+// handleApiFlight is the endpoint for the scans of a flight
+// accepts:
+//
+//	GET /api/v1/flights/:id
+func handleApiFlight(w http.ResponseWriter, r *http.Request) {
+	var ff flightFilter
+	ff.SiteId = requestSite(r).Id
+
+	var err error
+	if ff.FlightId, err = pathInt(pathParam(r, "id"), "flight id"); err != nil {
+		apiFail(w, r, err)
+		return
+	}
+
+	// Fetch flight positions filtered by flight filter
+	wl, err := FetchFlights(ff)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if len(wl) == 0 {
+		apiFail(w, r, errNotFound("flight %d not found", ff.FlightId))
+		return
+	}
+
+	// Send flight in json response
+	if err := jsonApi(w, r, wl, true); err != nil {
+		log.Println(err)
 	}
 }
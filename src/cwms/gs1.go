@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// gs1GroupSeparator is the FNC1 separator that terminates variable length element strings
+const gs1GroupSeparator = "\x1d"
+
+// gs1AI describes the length of a GS1 Application Identifier's data field
+type gs1AI struct {
+	Length   int  // fixed length, or maximum length when Variable is set
+	Variable bool // data is terminated by a group separator or the end of the payload
+}
+
+// gs1AIs lists the Application Identifiers understood by the decoder
+// 4 digit measure identifiers (310n-369n) are handled by gs1Lookup.
+var gs1AIs = map[string]gs1AI{
+	"00":  {18, false}, // SSCC
+	"01":  {14, false}, // GTIN
+	"02":  {14, false}, // GTIN of contained trade items
+	"10":  {20, true},  // batch or lot number
+	"11":  {6, false},  // production date
+	"12":  {6, false},  // due date
+	"13":  {6, false},  // packaging date
+	"15":  {6, false},  // best before date
+	"16":  {6, false},  // sell by date
+	"17":  {6, false},  // expiration date
+	"20":  {2, false},  // internal product variant
+	"21":  {20, true},  // serial number
+	"22":  {20, true},  // consumer product variant
+	"30":  {8, true},   // variable count of items
+	"37":  {8, true},   // count of trade items contained in a logistic unit
+	"240": {30, true},  // additional product identification
+	"241": {30, true},  // customer part number
+	"400": {30, true},  // customer purchase order number
+	"410": {13, false}, // ship to location
+	"414": {13, false}, // physical location
+	"420": {20, true},  // ship to postal code
+}
+
+// GS1 holds the structured content of a decoded GS1 barcode payload
+type GS1 struct {
+	GTIN   string            `json:"gtin"`
+	Lot    string            `json:"lot"`
+	Expiry string            `json:"expiry"` // yyyy-mm-dd
+	SSCC   string            `json:"sscc"`
+	Serial string            `json:"serial"`
+	Count  string            `json:"count"`
+	Fields map[string]string `json:"fields"` // every element string keyed by AI
+	// Unknown lists the element strings from an AI the decoder does not know, each undecoded up to the next group separator
+	Unknown []string `json:"unknown"`
+}
+
+// gs1Lookup returns the definition of the AI at the start of s and its length
+func gs1Lookup(s string) (ai string, def gs1AI, ok bool) {
+	if len(s) >= 4 && s[0] == '3' && s[1] >= '1' && s[1] <= '6' {
+		return s[:4], gs1AI{6, false}, true
+	}
+	for _, n := range []int{2, 3} {
+		if len(s) >= n {
+			if def, ok = gs1AIs[s[:n]]; ok {
+				return s[:n], def, true
+			}
+		}
+	}
+	return
+}
+
+// DecodeGS1 parses a raw barcode payload into GS1 Application Identifier fields
+// Both the scanner form, with an optional symbology identifier and FNC1 group
+// separators, and the human readable form "(01)09501101530003(10)ABC" are accepted.
+// Unknown AIs do not fail the decode, they are listed in Unknown.
+func DecodeGS1(payload string) (g GS1, err error) {
+	g.Fields = make(map[string]string)
+	s := strings.TrimSpace(payload)
+
+	// Strip symbology identifiers such as ]C1 (GS1-128), ]d2 (DataMatrix), ]Q3 (QR) and ]e0 (DataBar)
+	if len(s) >= 3 && s[0] == ']' {
+		s = s[3:]
+	}
+	s = strings.TrimPrefix(s, gs1GroupSeparator)
+	if s == "" {
+		err = fmt.Errorf("gs1: empty payload")
+		return
+	}
+
+	if strings.HasPrefix(s, "(") {
+		err = g.decodeBracketed(s)
+	} else {
+		err = g.decodeElementString(s)
+	}
+	if err != nil {
+		return
+	}
+
+	for ai, v := range g.Fields {
+		switch ai {
+		case "00":
+			g.SSCC = v
+		case "01":
+			g.GTIN = v
+		case "10":
+			g.Lot = v
+		case "17":
+			g.Expiry, err = gs1Date(v, time.Now())
+		case "21":
+			g.Serial = v
+		case "37":
+			g.Count = v
+		}
+		if err != nil {
+			return
+		}
+	}
+	if g.GTIN != "" && !validGTIN(g.GTIN) {
+		err = fmt.Errorf("gs1: invalid GTIN check digit %q", g.GTIN)
+	}
+	return
+}
+
+// decodeBracketed parses the human readable form where each AI is enclosed in brackets
+func (g *GS1) decodeBracketed(s string) error {
+	for s != "" {
+		if s[0] != '(' {
+			return fmt.Errorf("gs1: expected '(' at %q", s)
+		}
+		end := strings.IndexByte(s, ')')
+		if end < 0 {
+			return fmt.Errorf("gs1: unterminated AI at %q", s)
+		}
+		ai := s[1:end]
+		s = s[end+1:]
+		next := strings.IndexByte(s, '(')
+		if next < 0 {
+			next = len(s)
+		}
+		if _, def, ok := gs1Lookup(ai); !ok {
+			g.Unknown = append(g.Unknown, ai+s[:next])
+		} else if !def.Variable && next != def.Length {
+			return fmt.Errorf("gs1: AI %s needs %d characters, got %d", ai, def.Length, next)
+		}
+		g.Fields[ai] = s[:next]
+		s = s[next:]
+	}
+	return nil
+}
+
+// decodeElementString parses concatenated element strings separated by FNC1 where required
+// The length of an unknown AI is not known, so decoding resumes after the next group separator.
+func (g *GS1) decodeElementString(s string) error {
+	for s != "" {
+		ai, def, ok := gs1Lookup(s)
+		if !ok {
+			i := strings.Index(s, gs1GroupSeparator)
+			if i < 0 {
+				i = len(s)
+			}
+			g.Unknown = append(g.Unknown, s[:i])
+			s = strings.TrimPrefix(s[i:], gs1GroupSeparator)
+			continue
+		}
+		s = s[len(ai):]
+		n := def.Length
+		if def.Variable {
+			if i := strings.Index(s, gs1GroupSeparator); i >= 0 && i <= n {
+				n = i
+			} else {
+				n = Min(n, len(s))
+			}
+		} else if len(s) < n {
+			return fmt.Errorf("gs1: AI %s needs %d characters, got %d", ai, n, len(s))
+		}
+		g.Fields[ai] = s[:n]
+		s = strings.TrimPrefix(s[n:], gs1GroupSeparator)
+	}
+	return nil
+}
+
+// gs1Date converts a YYMMDD date to yyyy-mm-dd, where a day of 00 means the last day of the month
+// The century follows the GS1 sliding window, from 49 years before the current year to 50 years after it.
+func gs1Date(v string, now time.Time) (string, error) {
+	if len(v) != 6 || strings.Trim(v, "0123456789") != "" {
+		return "", fmt.Errorf("gs1: invalid date %q", v)
+	}
+	year := now.Year() - now.Year()%100 + int(v[0]-'0')*10 + int(v[1]-'0')
+	if d := year - now.Year(); d > 50 {
+		year -= 100
+	} else if d < -49 {
+		year += 100
+	}
+
+	day := v[4:]
+	if day == "00" {
+		day = "01"
+	}
+	t, err := time.Parse("20060102", fmt.Sprintf("%04d%s%s", year, v[2:4], day))
+	if err != nil {
+		return "", fmt.Errorf("gs1: invalid date %q", v)
+	}
+	if v[4:] == "00" {
+		t = t.AddDate(0, 1, -1)
+	}
+	return t.Format("2006-01-02"), nil
+}
+
+// normalizeGTIN pads GTIN-8, GTIN-12 and GTIN-13 codes to the 14 digit form
+func normalizeGTIN(gtin string) string {
+	if gtin == "" || len(gtin) >= 14 {
+		return gtin
+	}
+	return strings.Repeat("0", 14-len(gtin)) + gtin
+}
+
+// validGTIN verifies the mod 10 check digit of a GTIN
+func validGTIN(gtin string) bool {
+	sum := 0
+	for i := 0; i < len(gtin); i++ {
+		c := gtin[len(gtin)-1-i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return len(gtin) > 0 && sum%10 == 0
+}
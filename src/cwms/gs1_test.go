@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeGS1(t *testing.T) {
+	for _, test := range []struct {
+		payload string
+		gtin    string
+		lot     string
+		expiry  string
+		unknown []string
+		err     bool
+	}{
+		{payload: "]C10109501101530003\x1d10ABC123", gtin: "09501101530003", lot: "ABC123"},
+		{payload: "(01)09501101530003(17)201231(10)ABC", gtin: "09501101530003", lot: "ABC", expiry: "2020-12-31"},
+		{payload: "0109501101530003" + "17201200", gtin: "09501101530003", expiry: "2020-12-31"},
+		// decoding resumes after the group separator following an unknown AI
+		{payload: "0109501101530003" + "99ABC\x1d10LOT7", gtin: "09501101530003", lot: "LOT7", unknown: []string{"99ABC"}},
+		{payload: "0109501101530003" + "99ABC", gtin: "09501101530003", unknown: []string{"99ABC"}},
+		{payload: "(01)09501101530003(91)XY(10)LOT7", gtin: "09501101530003", lot: "LOT7", unknown: []string{"91XY"}},
+		{payload: "0109501101530004", err: true},
+		{payload: "01095011015300", err: true},
+		{payload: "", err: true},
+	} {
+		g, err := DecodeGS1(test.payload)
+		if test.err {
+			if err == nil {
+				t.Errorf("DecodeGS1(%q) decoded, expected an error", test.payload)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("DecodeGS1(%q): %v", test.payload, err)
+			continue
+		}
+		if g.GTIN != test.gtin || g.Lot != test.lot || g.Expiry != test.expiry || !reflect.DeepEqual(g.Unknown, test.unknown) {
+			t.Errorf("DecodeGS1(%q) = gtin %q lot %q expiry %q unknown %q, expected %q %q %q %q",
+				test.payload, g.GTIN, g.Lot, g.Expiry, g.Unknown, test.gtin, test.lot, test.expiry, test.unknown)
+		}
+	}
+}
+
+func TestGS1DateWindow(t *testing.T) {
+	in2026 := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	in2060 := time.Date(2060, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		date   string
+		now    time.Time
+		expiry string
+	}{
+		// 50 years ahead is this century, 51 the last
+		{"760101", in2026, "2076-01-01"},
+		{"770101", in2026, "1977-01-01"},
+		// beyond Go's 69/70 pivot
+		{"700101", in2026, "2070-01-01"},
+		{"991231", in2026, "1999-12-31"},
+		// 49 years back is this century, 50 the next
+		{"110101", in2060, "2011-01-01"},
+		{"100101", in2060, "2110-01-01"},
+		{"100200", in2060, "2110-02-28"},
+		{"280200", in2026, "2028-02-29"},
+	} {
+		if expiry, err := gs1Date(test.date, test.now); err != nil || expiry != test.expiry {
+			t.Errorf("gs1Date(%q) in %d = %q, expected %q: %v", test.date, test.now.Year(), expiry, test.expiry, err)
+		}
+	}
+	for _, date := range []string{"2012", "ab0101", "201301", "200230", "-10101"} {
+		if expiry, err := gs1Date(date, in2026); err == nil {
+			t.Errorf("gs1Date(%q) = %q, expected an error", date, expiry)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// shuttingDown is set once the server starts draining, so readiness checks fail and load balancers stop routing
+var shuttingDown int32
+
+// readiness is the result of the readiness checks
+type readiness struct {
+	Status string            `json:"status"` // ok or unavailable
+	Checks map[string]string `json:"checks"`
+}
+
+// checkReady checks the database connection and schema version
+func checkReady(ctx context.Context) (rd readiness) {
+	rd = readiness{Status: "ok", Checks: map[string]string{"database": "ok", "schema": "ok"}}
+	fail := func(check string, err error) {
+		rd.Status, rd.Checks[check] = "unavailable", err.Error()
+	}
+
+	if atomic.LoadInt32(&shuttingDown) != 0 {
+		fail("server", fmt.Errorf("shutting down"))
+	}
+	if err := db.PingContext(ctx); err != nil {
+		fail("database", err)
+		fail("schema", fmt.Errorf("database unavailable"))
+		return
+	}
+	var version int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		fail("schema", err)
+	} else if expected := schemaVersion(); version != expected {
+		fail("schema", fmt.Errorf("database schema version %d, server expects %d, run cwms migrate up", version, expected))
+	}
+	return
+}
+
+// handleHealthz reports that the server process is alive
+// accepts:
+//
+//	GET /healthz
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz reports whether the server can serve requests, 503 while the database or schema is unavailable or during shutdown
+// accepts:
+//
+//	GET /readyz
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	rd := checkReady(ctx)
+
+	status := http.StatusOK
+	if rd.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(rd); err != nil {
+		log.Println(err)
+	}
+}
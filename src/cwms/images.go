@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png" // Register png decoder for uploaded images
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Image storage settings
+const (
+	imageDir           = "./images/"         // root directory of the content addressed image store
+	imageMaxUpload     = 32 << 20            // maximum accepted upload size in bytes
+	imageMaxPixels     = 8192 * 8192         // maximum accepted width × height, checked before an upload is decoded
+	imageThumbnailSize = 160                 // maximum thumbnail width or height in pixels
+	imageRetention     = 90 * 24 * time.Hour // uploaded images older than this are removed
+	imageCacheControl  = "public, max-age=31536000, immutable"
+)
+
+// ImageRecord definition matches the images table
+type ImageRecord struct {
+	Id          int       `xml:"id,attr" json:"id"`
+	Url         string    `xml:"url" json:"url"`
+	Thumbnail   string    `xml:"thumbnail" json:"thumbnail"`
+	Hash        string    `xml:"hash" json:"hash"`
+	ContentType string    `xml:"contentType" json:"contentType"`
+	Size        int64     `xml:"size" json:"size"`
+	CreatedTime time.Time `xml:"createdTime" json:"createdTime"`
+	FpId        int       `xml:"flightPosition" json:"flightPosition"`
+}
+
+// imagePath returns the location of an image in the store, fanned out by the first two hash characters
+func imagePath(hash string) string {
+	return filepath.Join(imageDir, hash[:2], hash)
+}
+
+// imageUrl returns the url an image is served at to a site, image urls of other than the default site carry the site parameter
+func imageUrl(hash string, site Site) string {
+	if site.Id == defaultSiteId {
+		return "/images/" + hash
+	}
+	return "/images/" + hash + "?site=" + url.QueryEscape(site.Code)
+}
+
+// thumbnailUrl returns the url of the thumbnail of an image served at u
+func thumbnailUrl(u, hash string) string {
+	return strings.Replace(u, hash, hash+"/thumbnail", 1)
+}
+
+// thumbnailPath returns the location of an image thumbnail in the store
+func thumbnailPath(hash string) string {
+	return imagePath(hash) + ".thumb.jpg"
+}
+
+// validHash reports whether s is a lower case hex encoded sha256 hash
+func validHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
+
+// makeThumbnail scales an image down so that neither side exceeds max pixels
+func makeThumbnail(src image.Image, max int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= max && h <= max {
+		return src
+	}
+	tw, th := max, h*max/w
+	if h > w {
+		tw, th = w*max/h, max
+	}
+	tw, th = Max(tw, 1), Max(th, 1)
+
+	// average the source pixels covered by each thumbnail pixel
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+Max((y+1)*h/th, y*h/th+1)
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+Max((x+1)*w/tw, x*w/tw+1)
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+pr, g+pg, bl+pb, a+pa, n+1
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+// storeImage writes an image and its thumbnail to the store and returns the content hash
+// Storing content that is already present is a no-op.
+func storeImage(data []byte, src image.Image) (hash string, err error) {
+	sum := sha256.Sum256(data)
+	hash = hex.EncodeToString(sum[:])
+
+	if err = os.MkdirAll(filepath.Dir(imagePath(hash)), 0755); err != nil {
+		return
+	}
+	if _, serr := os.Stat(imagePath(hash)); serr == nil {
+		return
+	}
+
+	var thumb bytes.Buffer
+	if err = jpeg.Encode(&thumb, makeThumbnail(src, imageThumbnailSize), &jpeg.Options{Quality: 80}); err != nil {
+		return
+	}
+	if err = writeFileAtomic(thumbnailPath(hash), thumb.Bytes(), 0644); err != nil {
+		return
+	}
+	// write the original last so its presence implies a complete entry
+	err = writeFileAtomic(imagePath(hash), data, 0644)
+	return
+}
+
+// writeFileAtomic writes a file under a temporary name in its directory and renames it into place,
+// so a concurrent reader or a crash never leaves a partly written file under the name
+func writeFileAtomic(name string, data []byte, perm os.FileMode) (err error) {
+	var f *os.File
+	if f, err = os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+"-*"); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err == nil {
+		err = f.Chmod(perm)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return
+	}
+	err = os.Rename(f.Name(), name)
+	return
+}
+
+// CreateImage records an uploaded image against a flight position and attaches it to the inventory at that position
+func CreateImage(fpId int, hash, contentType string, size int64) (ir ImageRecord, err error) {
+	var positionId int
+	var site Site
+	if err = db.QueryRow(`select positionId, siteId, code from flightPositions join flights using(flightId) join sites using(siteId) where fpId = ?`, fpId).
+		Scan(&positionId, &site.Id, &site.Code); err != nil {
+		return
+	}
+
+	ir = ImageRecord{
+		Url:         imageUrl(hash, site),
+		Hash:        hash,
+		ContentType: contentType,
+		Size:        size,
+		CreatedTime: time.Now().UTC(),
+		FpId:        fpId,
+	}
+	ir.Thumbnail = thumbnailUrl(ir.Url, hash)
+
+	var tx *sql.Tx
+	if tx, err = db.Begin(); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var res sql.Result
+	if res, err = tx.Exec(`insert into images (imageUrl, hash, contentType, size, createdTime, fpId) values (?, ?, ?, ?, ?, ?)`,
+		ir.Url, ir.Hash, ir.ContentType, ir.Size, ir.CreatedTime, ir.FpId); err != nil {
+		return
+	}
+	var id int64
+	if id, err = res.LastInsertId(); err != nil {
+		return
+	}
+	ir.Id = int(id)
+
+	// The latest photo of a slot becomes the image of the inventory held there
+	if _, err = tx.Exec(`update inventory set imageId = ? where positionId = ?`, ir.Id, positionId); err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+// FetchImage performs a query on images and returns the image record with the given id taken at a site
+func FetchImage(siteId, id int) (ir ImageRecord, err error) {
+	defer observeQuery("FetchImage", time.Now())
+	err = db.QueryRow(`select imageId, imageUrl, hash, contentType, size, createdTime, fpId from images join flightPositions using(fpId) join flights using(flightId) where imageId = ? and hash is not null and flights.siteId = ?`, id, siteId).
+		Scan(&ir.Id, &ir.Url, &ir.Hash, &ir.ContentType, &ir.Size, &ir.CreatedTime, &ir.FpId)
+	ir.Thumbnail = thumbnailUrl(ir.Url, ir.Hash)
+	return
+}
+
+// cleanupImages removes uploaded images created before the cutoff, and their files once no record refers to them
+func cleanupImages(cutoff time.Time) (removed int, err error) {
+	var rows *sql.Rows
+	if rows, err = db.Query(`select imageId, hash from images where hash is not null and createdTime < ?`, cutoff.UTC()); err != nil {
+		return
+	}
+	type expired struct {
+		id   int
+		hash string
+	}
+	var el []expired
+	for rows.Next() {
+		var e expired
+		if err = rows.Scan(&e.id, &e.hash); err != nil {
+			rows.Close()
+			return
+		}
+		el = append(el, e)
+	}
+	rows.Close()
+
+	for _, e := range el {
+		if _, err = db.Exec(`update inventory set imageId = null where imageId = ?`, e.id); err != nil {
+			return
+		}
+		if _, err = db.Exec(`delete from images where imageId = ?`, e.id); err != nil {
+			return
+		}
+		removed++
+
+		var refs int
+		if err = db.QueryRow(`select count(1) from images where hash = ?`, e.hash).Scan(&refs); err != nil {
+			return
+		}
+		if refs == 0 && validHash(e.hash) {
+			for _, p := range []string{imagePath(e.hash), thumbnailPath(e.hash)} {
+				if rerr := os.Remove(p); rerr != nil && !os.IsNotExist(rerr) {
+					log.Println(rerr)
+				}
+			}
+		}
+	}
+	return
+}
+
+// imageRetentionJob removes images older than the retention period
+func imageRetentionJob(ctx context.Context) (string, error) {
+	n, err := cleanupImages(time.Now().Add(-imageRetention))
+	return fmt.Sprintf("removed %d expired images", n), err
+}
+
+// handleImages serves stored images and thumbnails taken at the request's site
+// accepts:
+//
+//	/images/:hash?site=:site
+//	/images/:hash/thumbnail?site=:site
+//
+// Images are immutable so responses carry long lived cache headers and the hash as ETag.
+func handleImages(w http.ResponseWriter, r *http.Request) {
+	sl := strings.Split(strings.TrimPrefix(r.URL.Path, "/images/"), "/")
+	hash := sl[0]
+	if !validHash(hash) || len(sl) > 2 || (len(sl) == 2 && sl[1] != "thumbnail") {
+		http.NotFound(w, r)
+		return
+	}
+
+	var contentType string
+	if err := db.QueryRow(`select contentType from images join flightPositions using(fpId) join flights using(flightId) where hash = ? and flights.siteId = ? limit 1`,
+		hash, requestSite(r).Id).Scan(&contentType); err != nil {
+		if err != sql.ErrNoRows {
+			log.Println(err)
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	path, etag := imagePath(hash), `"`+hash+`"`
+	if len(sl) == 2 {
+		path, etag, contentType = thumbnailPath(hash), `"`+hash+`-thumbnail"`, "image/jpeg"
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		log.Println(err)
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		log.Println(err)
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", imageCacheControl)
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+// handleApiImages is the endpoint for images restful api
+// accepts:
+//
+//	GET  /api/v1/images/:id
+//	POST /api/v1/images       multipart form with fields flightId, positionId and file image
+//
+// Uploads are stored by content hash and attached to the flight position.
+func handleApiImages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		id, err := pathInt(pathParam(r, "id"), "image id")
+		if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		ir, err := FetchImage(requestSite(r).Id, id)
+		if err == sql.ErrNoRows {
+			apiFail(w, r, errNotFound("image %d not found", id))
+			return
+		} else if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if err = jsonApi(w, r, ir, false); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, imageMaxUpload)
+	if err := r.ParseMultipartForm(imageMaxUpload); err != nil {
+		apiFail(w, r, errBadRequest("%v", err))
+		return
+	}
+	flightId, err1 := strconv.Atoi(r.FormValue("flightId"))
+	positionId, err2 := strconv.Atoi(r.FormValue("positionId"))
+	if err1 != nil || err2 != nil {
+		apiFail(w, r, errBadRequest("flightId and positionId are required"))
+		return
+	}
+
+	// The image must belong to a position scanned on the flight
+	var fpId int
+	err := db.QueryRow(`select fpId from flightPositions join flights using(flightId) where flightId = ? and positionId = ? and siteId = ? order by fpId desc limit 1`,
+		flightId, positionId, requestSite(r).Id).Scan(&fpId)
+	if err == sql.ErrNoRows {
+		apiFail(w, r, errNotFound("position %d was not scanned on flight %d", positionId, flightId))
+		return
+	} else if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		apiFail(w, r, errBadRequest("%v", err))
+		return
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(file, imageMaxUpload))
+	if err != nil {
+		apiFail(w, r, errBadRequest("%v", err))
+		return
+	}
+
+	// Check the dimensions before decoding, a small file can hold an image too large to decode
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		apiFail(w, r, errBadRequest("decode image: %v", err))
+		return
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > imageMaxPixels {
+		apiFail(w, r, errBadRequest("image is %dx%d pixels, larger than the %d pixel limit", cfg.Width, cfg.Height, imageMaxPixels))
+		return
+	}
+
+	// Decode the image to validate it and build the thumbnail
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		apiFail(w, r, errBadRequest("decode image: %v", err))
+		return
+	}
+
+	hash, err := storeImage(data, src)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	ir, err := CreateImage(fpId, hash, "image/"+format, int64(len(data)))
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if err = jsonApi(w, r, ir, false); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// testPng encodes a small png and rewrites its header to claim the given dimensions
+// Only DecodeConfig is expected to read it, decoding fails on the missing pixels.
+func testPng(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	data := b.Bytes()
+	// the IHDR chunk follows the 8 byte signature: length, type, width, height, 5 more bytes and the crc
+	binary.BigEndian.PutUint32(data[16:], uint32(width))
+	binary.BigEndian.PutUint32(data[20:], uint32(height))
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+// uploadImage posts an image of a position scanned on a flight to the images api of a site
+func uploadImage(t *testing.T, site Site, flightId, positionId int, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("flightId", strconv.Itoa(flightId))
+	mw.WriteField("positionId", strconv.Itoa(positionId))
+	fw, err := mw.CreateFormFile("image", "scan.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/sites/"+site.Code+"/images", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r = r.WithContext(context.WithValue(r.Context(), siteKey{}, site))
+	w := httptest.NewRecorder()
+	handleApiImages(w, r)
+	return w
+}
+
+func TestImageUpload(t *testing.T) {
+	// images are stored under the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	seedTestDb(t)
+	north, err := FetchSite("north")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := uploadImage(t, north, 4, 31, testPng(t, 100000, 100000)); w.Code != http.StatusBadRequest {
+		t.Errorf("oversized image: status %d, expected %d", w.Code, http.StatusBadRequest)
+	}
+
+	var b bytes.Buffer
+	if err = png.Encode(&b, image.NewGray(image.Rect(0, 0, 4, 3))); err != nil {
+		t.Fatal(err)
+	}
+	w := uploadImage(t, north, 4, 31, b.Bytes())
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload: status %d, expected %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	var ir ImageRecord
+	if err = json.Unmarshal(w.Body.Bytes(), &ir); err != nil {
+		t.Fatal(err)
+	}
+	// the image and thumbnail are renamed into place, leaving no temporary files
+	if files, _ := filepath.Glob(filepath.Join(filepath.Dir(imagePath(ir.Hash)), "*")); len(files) != 2 {
+		t.Errorf("stored files %q, expected the image and its thumbnail", files)
+	}
+
+	// images are only served to the site they were taken at
+	h := pmw(handleImages)
+	for _, test := range []struct {
+		url    string
+		status int
+	}{
+		{ir.Url, http.StatusOK},
+		{ir.Thumbnail, http.StatusOK},
+		{"/images/" + ir.Hash, http.StatusNotFound},
+		{"/images/" + ir.Hash + "/thumbnail", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, test.url, nil))
+		if w.Code != test.status {
+			t.Errorf("GET %s: status %d, expected %d", test.url, w.Code, test.status)
+		}
+	}
+
+	var thumbnailUrl string
+	if err = db.QueryRow(`select thumbnailUrl from v_inventory where positionId = 31`).Scan(&thumbnailUrl); err != nil {
+		t.Fatal(err)
+	}
+	if thumbnailUrl != ir.Thumbnail {
+		t.Errorf("inventory thumbnail %q, expected %q", thumbnailUrl, ir.Thumbnail)
+	}
+}
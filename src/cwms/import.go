@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"cwms/api"
+	"github.com/jszwec/csvutil"
+)
+
+// ImportResult summarises a warehouse management system import
+type ImportResult = api.ImportResult
+
+// validateImport checks that every record can be placed and that its dates are well formed
+func validateImport(wl WmsList) error {
+	for i, w := range wl {
+		if w.Aisle == "" || w.Block == "" || w.Slot == "" {
+			return fmt.Errorf("record %d: aisle, block and slot are required", i)
+		}
+		if w.Expiry.Valid && w.Expiry.String != "" {
+			if _, err := time.Parse("2006-01-02", w.Expiry.String); err != nil {
+				return fmt.Errorf("record %d: expiry %q is not yyyy-mm-dd", i, w.Expiry.String)
+			}
+		}
+		if w.Quantity < 0 {
+			return fmt.Errorf("record %d: quantity %d is negative", i, w.Quantity)
+		}
+	}
+	return nil
+}
+
+// importPosition returns the position id of a record at a site, creating the position if the warehouse has not seen it
+func importPosition(tx *sql.Tx, siteId int, w Wms) (positionId int, created bool, err error) {
+	err = tx.QueryRow(`select positionId from positions where siteId = ? and json_extract(json_position, '$.aisle') = ? and json_extract(json_position, '$.block') = ? and json_extract(json_position, '$.slot') = ?`,
+		siteId, w.Aisle, w.Block, w.Slot).Scan(&positionId)
+	if err != sql.ErrNoRows {
+		return
+	}
+
+	var res sql.Result
+	if res, err = tx.Exec(`insert into positions (json_position, siteId) values (json_object('aisle', ?, 'block', ?, 'slot', ?, 'shelf', ?, 'displayname', ?), ?)`,
+		w.Aisle, w.Block, w.Slot, w.Shelf, w.DisplayName, siteId); err != nil {
+		return
+	}
+	var id int64
+	id, err = res.LastInsertId()
+	positionId, created = int(id), true
+	return
+}
+
+// importRecord replaces the item recorded at a position by the record, or records it when the position holds none yet
+// The current inventory row, the latest at the position, keeps its scan times and image unless the record carries scan times.
+func importRecord(tx *sql.Tx, positionId int, w Wms, now time.Time) (replaced bool, err error) {
+	var res sql.Result
+	if res, err = tx.Exec(`insert into items (sku, discrepancy, gtin, lot, expiry, quantity) values (?, "", ?, ?, ?, ?)`,
+		w.SKU.NullString, w.Gtin.NullString, w.Lot.NullString, w.Expiry.NullString, w.Quantity); err != nil {
+		return
+	}
+	var itemId int64
+	if itemId, err = res.LastInsertId(); err != nil {
+		return
+	}
+
+	// scan times are only set when the record carries them, an import is not a scan
+	var start, stop interface{}
+	if !w.StartTime.IsZero() {
+		start, stop = w.StartTime, w.StartTime
+	}
+	if !w.StopTime.IsZero() {
+		stop = w.StopTime
+	}
+
+	var inventoryId, oldItemId int
+	err = tx.QueryRow(`select inventoryId, itemId from inventory where positionId = ? order by inventoryId desc limit 1`, positionId).Scan(&inventoryId, &oldItemId)
+	if err == sql.ErrNoRows {
+		_, err = tx.Exec(`insert into inventory (startTime, stopTime, importedTime, itemId, positionId) values (?, ?, ?, ?, ?)`,
+			start, stop, now, itemId, positionId)
+		return
+	} else if err != nil {
+		return
+	}
+
+	if _, err = tx.Exec(`update inventory set itemId = ?, importedTime = ?, startTime = IFNULL(?, startTime), stopTime = IFNULL(?, stopTime) where inventoryId = ?`,
+		itemId, now, start, stop, inventoryId); err != nil {
+		return
+	}
+	_, err = tx.Exec(`delete from items where itemId = ? and not exists (select 1 from inventory where itemId = ?)`, oldItemId, oldItemId)
+	return true, err
+}
+
+// ImportInventory loads warehouse management system records into the inventory of a site
+// Each record replaces the item recorded at its position, positions not in the import are left as they are,
+// and the summaries of the changed aisles are refreshed.
+func ImportInventory(siteId int, wl WmsList) (ir ImportResult, err error) {
+	var tx *sql.Tx
+	if tx, err = db.Begin(); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	now := time.Now().UTC()
+	var positionIds []int
+	for _, w := range wl {
+		var positionId int
+		var created, replaced bool
+		if positionId, created, err = importPosition(tx, siteId, w); err != nil {
+			return
+		}
+		if created {
+			ir.Positions++
+		}
+		if replaced, err = importRecord(tx, positionId, w, now); err != nil {
+			return
+		}
+		if replaced {
+			ir.Replaced++
+		}
+		positionIds = append(positionIds, positionId)
+		ir.Imported++
+	}
+	if err = refreshSummaries(tx, positionIds); err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+// decodeImport reads and validates import records, given as json or as csv with the Wms csv columns
+func decodeImport(body []byte, isCsv bool) (wl WmsList, err error) {
+	if isCsv {
+		err = csvutil.Unmarshal(body, &wl)
+	} else {
+		err = json.Unmarshal(body, &wl)
+	}
+	if err == nil {
+		err = validateImport(wl)
+	}
+	return
+}
+
+// handleApiImport is the endpoint for warehouse management system imports
+// accepts:
+//
+//	POST /api/v1/import   body is a json WmsList, or csv with the Wms csv columns when Content-Type is text/csv
+func handleApiImport(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		apiFail(w, r, errBadRequest("%v", err))
+		return
+	}
+
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	wl, err := decodeImport(body, mt == "text/csv")
+	if err != nil {
+		apiFail(w, r, errBadRequest("%v", err))
+		return
+	}
+
+	ir, err := ImportInventory(requestSite(r).Id, wl)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if err = jsonApi(w, r, ir, false); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestImportReplacesPositions(t *testing.T) {
+	seedTestDb(t)
+	aisle, err := FetchInventory(AisleFilter{Aisle: "2a", SiteId: defaultSiteId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aisle) == 0 {
+		t.Fatal("the sample warehouse has no aisle 2a")
+	}
+	var rows, items int
+	count := func() (int, int) {
+		t.Helper()
+		var r, i int
+		if err := db.QueryRow(`select count(*), (select count(*) from items) from inventory`).Scan(&r, &i); err != nil {
+			t.Fatal(err)
+		}
+		return r, i
+	}
+	rows, items = count()
+	stats := func() AisleStats {
+		t.Helper()
+		asl, err := fetchAisleStats(defaultSiteId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, as := range asl {
+			if as.Id == "2a" {
+				return as
+			}
+		}
+		t.Fatal("no summary of aisle 2a")
+		return AisleStats{}
+	}
+	before := stats()
+
+	// the warehouse management system knows the positions, not the scans
+	var wl WmsList
+	for _, w := range aisle {
+		wl = append(wl, Wms{Aisle: w.Aisle, Block: w.Block, Slot: w.Slot, SKU: w.SKU, Quantity: w.Quantity})
+	}
+	for i := 0; i < 2; i++ {
+		ir, err := ImportInventory(defaultSiteId, wl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ir.Imported != len(wl) || ir.Replaced != len(wl) || ir.Positions != 0 {
+			t.Errorf("import %d: %+v, expected %d records replacing as many positions", i, ir, len(wl))
+		}
+		if r, it := count(); r != rows || it != items {
+			t.Errorf("import %d: %d inventory rows and %d items, expected %d and %d", i, r, it, rows, items)
+		}
+		if as := stats(); as.NumberOccupied != before.NumberOccupied || as.LastScanned != before.LastScanned {
+			t.Errorf("import %d: aisle 2a occupied %d last scanned %q, expected %d %q",
+				i, as.NumberOccupied, as.LastScanned, before.NumberOccupied, before.LastScanned)
+		}
+	}
+
+	after, err := FetchInventory(AisleFilter{Aisle: "2a", SiteId: defaultSiteId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range after {
+		if !after[i].StopTime.Equal(aisle[i].StopTime) {
+			t.Errorf("position %d scanned at %v after the import, expected %v", after[i].Id, after[i].StopTime, aisle[i].StopTime)
+		}
+	}
+	var imported sql.NullTime
+	if err = db.QueryRow(`select importedTime from inventory where importedTime is not null order by importedTime desc limit 1`).Scan(&imported); err != nil {
+		t.Fatal(err)
+	}
+	if !imported.Valid || time.Since(imported.Time) > time.Minute {
+		t.Errorf("import time %v, expected now", imported)
+	}
+
+	// a new position is recorded without scan times
+	ir, err := ImportInventory(defaultSiteId, WmsList{{Aisle: "2a", Block: "99", Slot: "1", SKU: NullString{NullString: sql.NullString{String: "sku", Valid: true}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ir.Positions != 1 || ir.Replaced != 0 {
+		t.Errorf("new position: %+v, expected one new position", ir)
+	}
+	if as := stats(); as.NumberOccupied != before.NumberOccupied+1 || as.LastScanned != before.LastScanned {
+		t.Errorf("new position: aisle 2a occupied %d last scanned %q, expected %d %q",
+			as.NumberOccupied, as.LastScanned, before.NumberOccupied+1, before.LastScanned)
+	}
+	if _, err = FetchInventory(AisleFilter{Aisle: "2a", SiteId: defaultSiteId}); err != nil {
+		t.Errorf("fetching an unscanned record: %v", err)
+	}
+}
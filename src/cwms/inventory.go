@@ -1,75 +1,43 @@
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
 	"encoding/csv"
-	"github.com/jszwec/csvutil"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
+	"net/url"
+	"strings"
+	"unicode"
 )
 
-// imwHandler is a middleware handler function signature used by the imw middleware
-type imwHandler func(tm map[string]interface{}, wl WmsList, w http.ResponseWriter, r *http.Request)
-
-// imw inventory middleware fetches inventory, statistics, and page controls and loads them into the template map
-//	imw does "everything":
-//	• loads inventory into the template map (tm)
-//	• loads page controls into the template map
-//	• loads statistics into the template map
-func imw(next imwHandler) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Fetch url parameters
-		urlParams := r.URL.Query()
-
-		// Create page controls
-		pc, err := pageControls(urlParams.Get("aisle"), urlParams.Get("scope"))
-		if err != nil {
-			log.Println(err)
-		}
-
-		// Fetch inventory based on page controls
-		wl, err := FetchInventory(pc.toAisleFilter())
-		if err != nil {
-			log.Println(err)
-		}
-
-		// Fetch inventory statistics
-		stats, err := fetchStats()
-		if err != nil {
-			log.Println(err)
-		}
-
-		// Create template map
-		tm := make(map[string]interface{})
-
-		// Load page controls into template map
-		tm["PageControls"] = pc
-
-		// Load inventory into template map
-		tm["Inventory"] = wl
-
-		// Fetch stats into template map
-		tm["Stats"] = stats
-
-		next(tm, wl, w, r)
-	})
-}
-
 // handleInventory provides inventory comparison functions
-func handleInventory(tm map[string]interface{}, wl WmsList, w http.ResponseWriter, r *http.Request) {
-	err := executeTemplate("inventory.html", tm, w)
+func handleInventory(w http.ResponseWriter, r *http.Request) {
+	wl, err := newPageData(r).Inventory()
+	if err != nil {
+		pageFail(w, err)
+		return
+	}
+	err = executeTemplate("inventory.html", map[string]interface{}{"Inventory": wl}, w)
 	if err != nil {
 		log.Println(err)
 	}
 }
 
 // handleDashboard provides main navigation to all webpages
-func handleDashboard(tm map[string]interface{}, wl WmsList, w http.ResponseWriter, r *http.Request) {
-	err := executeTemplate("dashboard.html", tm, w)
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	stats, err := newPageData(r).Stats()
+	if err != nil {
+		pageFail(w, err)
+		return
+	}
+	err = executeTemplate("dashboard.html", map[string]interface{}{"Stats": stats}, w)
 	if err != nil {
 		log.Println(err)
 	}
@@ -84,128 +52,405 @@ func handleSchedule(tm map[string]interface{}, ml MmsList, w http.ResponseWriter
 }
 
 // handleHybrid provides basic navigation features and downloads files in csv, json, or xml formats
-func handleHybrid(tm map[string]interface{}, wl WmsList, w http.ResponseWriter, r *http.Request) {
-	err := executeTemplate("hybrid.html", tm, w)
+func handleHybrid(w http.ResponseWriter, r *http.Request) {
+	pd := newPageData(r)
+	pc, err := pd.PageControls()
 	if err != nil {
-		log.Println(err)
+		pageFail(w, err)
+		return
+	}
+	wl, err := pd.Inventory()
+	if err != nil {
+		pageFail(w, err)
+		return
+	}
+	stats, err := pd.Stats()
+	if err != nil {
+		pageFail(w, err)
+		return
 	}
-}
 
-// handleExportInventoryCsv downloads the inventory to a CSV file
-func handleExportInventoryCsv(tm map[string]interface{}, wl WmsList, w http.ResponseWriter, r *http.Request) {
-	csvContent, err := csvutil.Marshal(wl)
+	// Load warehouse map colouring mode into template map
+	tm := map[string]interface{}{"PageControls": pc, "Inventory": wl, "Stats": stats, "MapMode": r.URL.Query().Get("map")}
+
+	err = executeTemplate("hybrid.html", tm, w)
 	if err != nil {
 		log.Println(err)
 	}
-	err2 := csvDownload(w, "inventory.csv", string(csvContent))
-	if err2 != nil {
-		log.Println(err2)
+}
+
+// exportQuery reads export filters and the export template from the request url parameters
+func exportQuery(r *http.Request) (InventoryQuery, exportLayout, error) {
+	return parseExport(requestSite(r).Id, r.URL.Query())
+}
+
+// parseExport reads the export filters and export template of a site from url parameters
+// Exports accept the inventory api filters and sort along with the page control aisle and scope,
+// and include every matching record unless a limit is given. template selects a saved export template by name,
+// sheets=aisle writes each aisle of a workbook to its own worksheet.
+func parseExport(siteId int, urlParams url.Values) (iq InventoryQuery, el exportLayout, err error) {
+	if urlParams.Get("aisle") == "all" {
+		urlParams.Del("aisle")
+	}
+	if urlParams.Get("scope") != "" && urlParams.Get("discrepancy") == "" {
+		urlParams.Set("discrepancy", "all")
 	}
+	if iq, err = parseInventoryQuery(urlParams); err != nil {
+		return
+	}
+	if urlParams.Get("limit") == "" {
+		iq.Limit = -1
+	}
+	iq.SiteId = siteId
+
+	el = defaultLayout()
+	if name := urlParams.Get("template"); name != "" {
+		var et ExportTemplate
+		if et, err = FetchExportTemplate(iq.SiteId, name); err == sql.ErrNoRows {
+			return iq, el, fmt.Errorf("unknown export template %q", name)
+		} else if err != nil {
+			return
+		}
+		el = et.layout()
+	}
+	switch sheets := urlParams.Get("sheets"); sheets {
+	case "":
+	case "aisle":
+		el.PerAisle = true
+	default:
+		return iq, el, fmt.Errorf("invalid sheets %q", sheets)
+	}
+	return
 }
 
-// handleExportInventory downloads the inventory to a JSON file
-func handleExportInventoryJson(tm map[string]interface{}, wl WmsList, w http.ResponseWriter, r *http.Request) {
-	err := jsonDownload(w, "inventory_json.txt", wl)
+// exportFormat is an inventory export file format
+type exportFormat struct {
+	Filename    string
+	ContentType string
+	write       func(out io.Writer, iq InventoryQuery, el exportLayout) error
+}
+
+// exportFormats are the inventory export formats by name
+var exportFormats = map[string]exportFormat{
+	"csv":    {"inventory.csv", "text/csv", writeCsv},
+	"json":   {"inventory_json.txt", "application/json", writeJson},
+	"xml":    {"inventory_xml.txt", "application/xml", writeXml},
+	"xlsx":   {"inventory.xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", writeXlsx},
+	"ndjson": {"inventory.ndjson", "application/x-ndjson", writeNdjson},
+}
+
+// exportDownload streams the inventory in an export format via the web browser
+func exportDownload(w http.ResponseWriter, r *http.Request, format string) {
+	iq, el, err := exportQuery(r)
 	if err != nil {
+		apiFail(w, r, errBadRequest("%v", err))
+		return
+	}
+	ef := exportFormats[format]
+	out, closeOut := downloadWriter(w, r, ef.Filename, ef.ContentType)
+	if err = ef.write(out, iq, el); err != nil {
 		log.Println(err)
 	}
+	if err = closeOut(); err != nil {
+		log.Println(err)
+	}
+}
+
+// handleExportInventoryCsv streams the inventory to a CSV file
+func handleExportInventoryCsv(w http.ResponseWriter, r *http.Request) {
+	exportDownload(w, r, "csv")
+}
+
+// handleExportInventoryJson streams the inventory to a JSON file
+func handleExportInventoryJson(w http.ResponseWriter, r *http.Request) {
+	exportDownload(w, r, "json")
 }
 
 // handleApiInventoryJson transfers the inventory via a restful api in a json format
-func handleApiInventoryJson(tm map[string]interface{}, wl WmsList, w http.ResponseWriter, r *http.Request) {
-	err := jsonApi(w, r, wl, true)
+// accepts:
+//
+//	GET /api/v1/inventory/all?aisle=&scope=
+func handleApiInventoryJson(w http.ResponseWriter, r *http.Request) {
+	wl, err := newPageData(r).Inventory()
 	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	// the response is written once jsonApi returns, an encoding error can only be logged
+	if err = jsonApi(w, r, wl, true); err != nil {
 		log.Println(err)
 	}
 }
 
-// handleExportInventoryXml downloads the inventory to an XML file
-func handleExportInventoryXml(tm map[string]interface{}, wl WmsList, w http.ResponseWriter, r *http.Request) {
-	err := xmlDownload(w, "inventory_xml.txt", wl)
-	if err != nil {
-		log.Println(err)
+// handleExportInventoryXml streams the inventory to an XML file
+func handleExportInventoryXml(w http.ResponseWriter, r *http.Request) {
+	exportDownload(w, r, "xml")
+}
+
+// handleExportInventoryXlsx streams the inventory to an XLSX workbook
+// With sheets=aisle each aisle is written to its own worksheet.
+func handleExportInventoryXlsx(w http.ResponseWriter, r *http.Request) {
+	exportDownload(w, r, "xlsx")
+}
+
+// handleExportInventoryNdjson streams the inventory as newline delimited json
+func handleExportInventoryNdjson(w http.ResponseWriter, r *http.Request) {
+	exportDownload(w, r, "ndjson")
+}
+
+// handleApiExport streams the inventory in the export format named by the path
+// accepts:
+//
+//	GET /api/v1/export/:format?template=&sheets=aisle   format is csv, json, xml, xlsx or ndjson
+func handleApiExport(w http.ResponseWriter, r *http.Request) {
+	format := pathParam(r, "format")
+	if _, ok := exportFormats[format]; !ok {
+		apiFail(w, r, errNotFound("unknown export format %q", format))
+		return
 	}
+	exportDownload(w, r, format)
 }
 
-// csvDownload downloads the inventory to a csv file via the web browser
-func csvDownload(w http.ResponseWriter, filename string, data string) (err error) {
-	w.Header().Set("Content-Type", "text/csv")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s", filename))
-	w.Write([]byte(data))
-	return
+// acceptsGzip reports whether the client accepts a gzip encoded response
+func acceptsGzip(r *http.Request) bool {
+	for _, e := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		sl := strings.Split(e, ";")
+		if strings.TrimSpace(sl[0]) != "gzip" {
+			continue
+		}
+		return len(sl) == 1 || strings.ReplaceAll(strings.TrimSpace(sl[1]), " ", "") != "q=0"
+	}
+	return false
 }
 
-// jsonDownload downloads the inventory to a json file via the web browser
-func jsonDownload(w http.ResponseWriter, filename string, data WmsList) (err error) {
-	w.Header().Set("Content-Type", "text")
+// downloadWriter sets the download headers and returns the response body writer, gzip compressed when the client accepts it
+// The returned close function must be called to flush the body.
+func downloadWriter(w http.ResponseWriter, r *http.Request, filename, contentType string) (io.Writer, func() error) {
+	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s", filename))
-	if err = json.NewEncoder(w).Encode(data); err != nil {
-		log.Println(err)
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		return w, func() error { return nil }
 	}
-	return
+	w.Header().Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(w)
+	return gz, gz.Close
 }
 
-// handleJsonApiRequest implements a simple api to read a wms list in a json format
-func handleJsonApiRequest(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Println(err)
+// wmsSource passes each inventory record of an export or api response to fn, stopping at the first error
+type wmsSource func(fn func(record Wms) error) error
+
+// inventorySource streams the inventory matching a query from the database cursor
+func inventorySource(iq InventoryQuery) wmsSource {
+	return func(fn func(record Wms) error) error {
+		return StreamInventory(iq, fn)
 	}
-	var wmsl WmsList
-	err = json.Unmarshal(body, &wmsl)
-	if err != nil {
-		log.Println(err)
+}
+
+// listSource ranges over inventory already fetched
+func listSource(wl WmsList) wmsSource {
+	return func(fn func(record Wms) error) error {
+		for _, record := range wl {
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
-	return
 }
 
-// csvExport exports the inventory to a local csv file
-func csvExport(filename string, data [][]string) (err error) {
-	var file *os.File
-	file, err = os.Create(filename)
-	if err != nil {
+// writeCsv streams the inventory from the database cursor as csv
+func writeCsv(out io.Writer, iq InventoryQuery, el exportLayout) error {
+	return encodeCsv(out, el, inventorySource(iq))
+}
+
+// encodeCsv writes inventory records as csv with a header row of the export columns
+func encodeCsv(out io.Writer, el exportLayout, src wmsSource) (err error) {
+	writer := csv.NewWriter(out)
+	writer.Comma = el.Delimiter
+	row := make([]string, len(el.Columns))
+	for i, c := range el.Columns {
+		row[i] = c.Name
+	}
+	if err = writer.Write(row); err != nil {
 		return
 	}
-	defer file.Close()
+	err = src(func(record Wms) error {
+		for i, c := range el.Columns {
+			row[i] = c.text(&record)
+		}
+		return writer.Write(row)
+	})
+	writer.Flush()
+	if err == nil {
+		err = writer.Error()
+	}
+	return
+}
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+// writeJsonColumns writes the columns of a record as a json object with the columns in order
+func writeJsonColumns(bw *bufio.Writer, cl []wmsColumn, record *Wms) error {
+	bw.WriteByte('{')
+	for i, c := range cl {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		k, err := json.Marshal(c.Name)
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(c.jsonValue(record))
+		if err != nil {
+			return err
+		}
+		bw.Write(k)
+		bw.WriteByte(':')
+		bw.Write(v)
+	}
+	return bw.WriteByte('}')
+}
 
-	for _, value := range data {
-		if err = writer.Write(value); err != nil {
-			return
+// writeJson streams the inventory from the database cursor as a json array
+// Without a template each record is the full Wms json object.
+func writeJson(out io.Writer, iq InventoryQuery, el exportLayout) (err error) {
+	bw := bufio.NewWriter(out)
+	enc := json.NewEncoder(bw)
+	sep := "["
+	err = StreamInventory(iq, func(record Wms) error {
+		if _, err := bw.WriteString(sep); err != nil {
+			return err
+		}
+		sep = ","
+		if el.Custom {
+			if err := writeJsonColumns(bw, el.Columns, &record); err != nil {
+				return err
+			}
+			_, err := bw.WriteString("\n")
+			return err
+		}
+		return enc.Encode(&record)
+	})
+	if err == nil {
+		if sep == "[" {
+			_, err = bw.WriteString("[]\n")
+		} else {
+			_, err = bw.WriteString("]\n")
 		}
 	}
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
 	return
 }
 
-// jsonExport exports the inventory to a local JSON file
-func jsonExport(filename string, data [][]string) (err error) {
-	var file *os.File
+// writeXlsx streams the inventory from the database cursor as a xlsx workbook
+// Per aisle workbooks are sorted by aisle first.
+func writeXlsx(out io.Writer, iq InventoryQuery, el exportLayout) (err error) {
+	if el.PerAisle && (len(iq.Sort) == 0 || strings.TrimPrefix(iq.Sort[0], "-") != "aisle") {
+		iq.Sort = append([]string{"aisle"}, iq.Sort...)
+	}
 
-	if file, err = os.Create(filename); err != nil {
+	var xw *xlsxWriter
+	if xw, err = newXlsxWriter(out, el.Columns); err != nil {
 		return
 	}
-	defer file.Close()
+	aisle := ""
+	if err = StreamInventory(iq, func(record Wms) error {
+		if el.PerAisle && (xw.sheet == nil || record.Aisle != aisle) {
+			aisle = record.Aisle
+			if err := xw.StartSheet(aisle); err != nil {
+				return err
+			}
+		}
+		return xw.WriteRow(&record)
+	}); err != nil {
+		return
+	}
+	err = xw.Close()
+	return
+}
 
-	writer := json.NewEncoder(file)
-	for _, value := range data {
-		if err = writer.Encode(value); err != nil {
-			return
+// writeNdjson streams the inventory from the database cursor as newline delimited json objects keyed by the export columns
+func writeNdjson(out io.Writer, iq InventoryQuery, el exportLayout) (err error) {
+	bw := bufio.NewWriter(out)
+	err = StreamInventory(iq, func(record Wms) error {
+		if err := writeJsonColumns(bw, el.Columns, &record); err != nil {
+			return err
 		}
+		_, err := bw.WriteString("\n")
+		return err
+	})
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
 	}
 	return
 }
 
-// xmlDownload downloads the inventory to a xml file via the web browser
-func xmlDownload(w http.ResponseWriter, filename string, data WmsList) (err error) {
-	w.Header().Set("Content-Type", "text")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s", filename))
-	output, err := xml.MarshalIndent(data, " ", "   ")
+// xmlName makes a valid xml element name from an export column label
+func xmlName(label string) string {
+	name := []rune(label)
+	for i, c := range name {
+		if !(unicode.IsLetter(c) || c == '_' || (i > 0 && (unicode.IsDigit(c) || c == '-' || c == '.'))) {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 {
+		return "_"
+	}
+	return string(name)
+}
+
+// writeXml streams the inventory from the database cursor as xml
+func writeXml(out io.Writer, iq InventoryQuery, el exportLayout) error {
+	return encodeXml(out, el, inventorySource(iq))
+}
+
+// encodeXml writes inventory records as a WmsList xml document
+// With a template each record is an Inventory Record element holding an element per column.
+func encodeXml(out io.Writer, el exportLayout, src wmsSource) (err error) {
+	enc := xml.NewEncoder(out)
+	enc.Indent(" ", "   ")
+	start := xml.StartElement{Name: xml.Name{Local: "WmsList"}}
+	if el.Custom {
+		start.Name.Local = "Inventory"
+	}
+	if err = enc.EncodeToken(start); err != nil {
+		return
+	}
+	if err = src(func(record Wms) error {
+		if !el.Custom {
+			return enc.Encode(&record)
+		}
+		rs := xml.StartElement{Name: xml.Name{Local: "Record"}}
+		if err := enc.EncodeToken(rs); err != nil {
+			return err
+		}
+		for _, c := range el.Columns {
+			if err := enc.EncodeElement(c.text(&record), xml.StartElement{Name: xml.Name{Local: xmlName(c.Name)}}); err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(rs.End())
+	}); err != nil {
+		return
+	}
+	if err = enc.EncodeToken(start.End()); err != nil {
+		return
+	}
+	err = enc.Flush()
+	return
+}
+
+// handleJsonApiRequest implements a simple api to read a wms list in a json format
+func handleJsonApiRequest(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Println(err)
+	}
+	var wmsl WmsList
+	err = json.Unmarshal(body, &wmsl)
 	if err != nil {
 		log.Println(err)
 	}
-	w.Write(output)
-
 	return
 }
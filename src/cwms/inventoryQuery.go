@@ -0,0 +1,232 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cwms/api"
+)
+
+// Inventory page size limits
+const (
+	defaultInventoryLimit = 100
+	maxInventoryLimit     = 1000
+)
+
+// wmsSortColumns maps the json name of each Wms field to its v_inventory column
+var wmsSortColumns = map[string]string{
+	"id":          "inventoryId",
+	"startTime":   "startTime",
+	"stopTime":    "stopTime",
+	"sku":         "sku",
+	"discrepancy": "discrepancy",
+	"aisle":       "aisle",
+	"block":       "block",
+	"slot":        "slot",
+	"shelf":       "shelf",
+	"displayname": "displayName",
+	"image":       "imageUrl",
+	"thumbnail":   "thumbnailUrl",
+	"gtin":        "gtin",
+	"lot":         "lot",
+	"expiry":      "expiry",
+	"quantity":    "quantity",
+}
+
+// InventoryQuery holds inventory api filter, sort and page information
+// Filters are cumulative.
+type InventoryQuery struct {
+	SiteId      int      // Filter on Site
+	SkuPrefix   string   // Filter on SKUs starting with the prefix
+	Aisle       string   // Filter on Aisle
+	Block       string   // Filter on Block
+	Slot        string   // Filter on Slot
+	Discrepancy string   // Filter on a discrepancy type, "all" for any discrepancy or "none" for none
+	MinAge      int      // Filter on positions last scanned at least this many days ago, 0 for no filter
+	MaxAge      int      // Filter on positions scanned within this many days, 0 for no filter
+	HasImage    *bool    // Filter on image presence
+	Sort        []string // Wms json field names, prefixed with "-" for descending order
+	Limit       int
+	Offset      int
+}
+
+// InventoryPage is a page of inventory with the total number of records matching the query
+type InventoryPage = api.InventoryPage
+
+// parseInventoryQuery reads an inventory query from url parameters
+func parseInventoryQuery(v url.Values) (iq InventoryQuery, err error) {
+	iq = InventoryQuery{
+		SkuPrefix:   v.Get("sku"),
+		Aisle:       v.Get("aisle"),
+		Block:       v.Get("block"),
+		Slot:        v.Get("slot"),
+		Discrepancy: v.Get("discrepancy"),
+		Limit:       defaultInventoryLimit,
+	}
+	for _, p := range []struct {
+		name string
+		n    *int
+	}{{"minAge", &iq.MinAge}, {"maxAge", &iq.MaxAge}, {"limit", &iq.Limit}, {"offset", &iq.Offset}} {
+		if s := v.Get(p.name); s != "" {
+			if *p.n, err = strconv.Atoi(s); err != nil || *p.n < 0 {
+				return iq, fmt.Errorf("invalid %s %q", p.name, s)
+			}
+		}
+	}
+	if iq.Limit == 0 || iq.Limit > maxInventoryLimit {
+		return iq, fmt.Errorf("limit must be between 1 and %d", maxInventoryLimit)
+	}
+	if s := v.Get("image"); s != "" {
+		var b bool
+		if b, err = strconv.ParseBool(s); err != nil {
+			return iq, fmt.Errorf("invalid image %q", s)
+		}
+		iq.HasImage = &b
+	}
+	if s := v.Get("sort"); s != "" {
+		for _, f := range strings.Split(s, ",") {
+			if _, ok := wmsSortColumns[strings.TrimPrefix(f, "-")]; !ok {
+				return iq, fmt.Errorf("invalid sort field %q", f)
+			}
+			iq.Sort = append(iq.Sort, f)
+		}
+	}
+	return
+}
+
+// toWhere generates the where clause and its arguments
+func (iq InventoryQuery) toWhere() (where string, args []interface{}) {
+	cl := []string{`siteId = ?`}
+	args = append(args, iq.SiteId)
+	if iq.SkuPrefix != "" {
+		// escape like wildcards so the prefix is matched literally
+		r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+		cl = append(cl, `sku like ? escape '\'`)
+		args = append(args, r.Replace(iq.SkuPrefix)+"%")
+	}
+	for _, f := range []struct{ column, value string }{{"aisle", iq.Aisle}, {"block", iq.Block}, {"slot", iq.Slot}} {
+		if f.value != "" {
+			cl = append(cl, f.column+` = ?`)
+			args = append(args, f.value)
+		}
+	}
+	switch iq.Discrepancy {
+	case "":
+	case "all":
+		cl = append(cl, `IFNULL(discrepancy, "") != ""`)
+	case "none":
+		cl = append(cl, `IFNULL(discrepancy, "") = ""`)
+	default:
+		cl = append(cl, `discrepancy = ?`)
+		args = append(args, iq.Discrepancy)
+	}
+	if iq.MinAge != 0 {
+		cl = append(cl, `julianday('now') - julianday(stopTime) >= ?`)
+		args = append(args, iq.MinAge)
+	}
+	if iq.MaxAge != 0 {
+		cl = append(cl, `julianday('now') - julianday(stopTime) <= ?`)
+		args = append(args, iq.MaxAge)
+	}
+	if iq.HasImage != nil {
+		image := `(IFNULL(imageUrl, "") != "" or thumbnailUrl is not null)`
+		if !*iq.HasImage {
+			image = `not ` + image
+		}
+		cl = append(cl, image)
+	}
+	where = ` where ` + strings.Join(cl, " and ")
+	return
+}
+
+// toOrder generates the order by clause, ending with inventoryId so pages are stable
+func (iq InventoryQuery) toOrder() string {
+	var ol []string
+	for _, f := range iq.Sort {
+		dir := "asc"
+		if strings.HasPrefix(f, "-") {
+			dir = "desc"
+		}
+		ol = append(ol, wmsSortColumns[strings.TrimPrefix(f, "-")]+" "+dir)
+	}
+	if len(ol) == 0 {
+		ol = append(ol, "aisle asc", "block asc", "slot asc")
+	}
+	return ` order by ` + strings.Join(ol, ", ") + `, inventoryId asc`
+}
+
+// FetchInventoryPage performs a query on v_inventory and returns a page of results with the total count
+func FetchInventoryPage(iq InventoryQuery) (ip InventoryPage, err error) {
+	defer observeQuery("FetchInventoryPage", time.Now())
+	ip = InventoryPage{Limit: iq.Limit, Offset: iq.Offset, Items: WmsList{}}
+	where, args := iq.toWhere()
+	if err = db.QueryRow(`select count(*) from v_inventory`+where, args...).Scan(&ip.Total); err != nil {
+		return
+	}
+
+	err = StreamInventory(iq, func(record Wms) error {
+		ip.Items = append(ip.Items, record)
+		return nil
+	})
+	return
+}
+
+// StreamInventory performs a query on v_inventory and passes each record to fn as it is read from the database cursor
+// A negative Limit streams every matching record. Streaming stops at the first error returned by fn.
+func StreamInventory(iq InventoryQuery, fn func(Wms) error) (err error) {
+	// Execute database query
+	where, args := iq.toWhere()
+	var rows *sql.Rows
+	if rows, err = db.Query(`select `+wmsColumns+` from v_inventory`+where+iq.toOrder()+` limit ? offset ?`, append(args, iq.Limit, iq.Offset)...); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	// Process database query results
+	var record Wms
+	for rows.Next() {
+		if err = rows.Scan(scanFields(&record)...); err != nil {
+			return
+		}
+		if err = fn(record); err != nil {
+			return
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+// handleApiInventory is the endpoint for the paginated inventory restful api
+// accepts:
+//
+//	GET /api/v1/inventory?limit=&offset=&sort=aisle,-stopTime&sku=&aisle=&block=&slot=&discrepancy=&minAge=&maxAge=&image=true
+//
+// sort takes Wms json field names, ages are in days since the position was last scanned.
+func handleApiInventory(w http.ResponseWriter, r *http.Request) {
+	urlParams := r.URL.Query()
+	iq, err := parseInventoryQuery(urlParams)
+	if err != nil {
+		apiFail(w, r, errBadRequest("%v", err))
+		return
+	}
+	iq.SiteId = requestSite(r).Id
+
+	ip, err := FetchInventoryPage(iq)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if next := iq.Offset + iq.Limit; next < ip.Total {
+		urlParams.Set("offset", strconv.Itoa(next))
+		ip.Next = "?" + urlParams.Encode()
+	}
+	if err = jsonApi(w, r, ip, false); err != nil {
+		log.Println(err)
+	}
+}
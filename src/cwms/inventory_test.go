@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApiInventoryJson(t *testing.T) {
+	seedTestDb(t)
+	w := httptest.NewRecorder()
+	handleApiInventoryJson(w, httptest.NewRequest(http.MethodGet, "/api/v1/inventory/all?aisle=2a", nil))
+	var wl WmsList
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, expected %d", w.Code, http.StatusOK)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &wl); err != nil || len(wl) == 0 {
+		t.Errorf("body %q is not the inventory of aisle 2a: %v", w.Body, err)
+	}
+
+	// a failed fetch is reported before anything is written, as the only document of the response
+	db.Close()
+	w = httptest.NewRecorder()
+	handleApiInventoryJson(w, httptest.NewRequest(http.MethodGet, "/api/v1/inventory/all", nil))
+	var problem map[string]interface{}
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("failed fetch: status %d %s, expected %d application/problem+json",
+			w.Code, w.Header().Get("Content-Type"), http.StatusInternalServerError)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Errorf("failed fetch: body %q is not one problem document: %v", w.Body, err)
+	}
+}
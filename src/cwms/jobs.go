@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxConcurrentJobs is the number of background jobs that may run at once, further runs queue
+const maxConcurrentJobs = 2
+
+// Job runner errors
+var (
+	errJobNotFound   = errors.New("job not found")
+	errJobRunning    = errors.New("job is already running")
+	errRunnerStopped = errors.New("job runner is stopped")
+)
+
+// jobFunc is the work of a background job, it returns a summary of the run
+// Long running jobs should return early once ctx is cancelled.
+type jobFunc func(ctx context.Context) (message string, err error)
+
+// Job is a background job definition, matches the jobs table
+// Jobs are registered in code, the schedule, enabled state and timeout are stored so they can be changed at run time.
+type Job struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Cron        string `json:"cron"`
+	Enabled     bool   `json:"enabled"`
+	Timeout     int    `json:"timeout"` // seconds a run may take before it is cancelled, 0 for no limit
+	Running     int    `json:"running"` // id of the active run, 0 when idle
+	NextRun     string `json:"nextRun"`
+}
+
+// JobRun is a run of a background job, matches the jobRuns table
+type JobRun struct {
+	Id        int    `json:"id"`
+	Name      string `json:"name"`
+	Trigger   string `json:"trigger"` // schedule or manual
+	StartTime string `json:"startTime"`
+	StopTime  string `json:"stopTime"`
+	Status    string `json:"status"` // queued, running, ok, failed, cancelled or interrupted
+	Message   string `json:"message"`
+}
+
+// registeredJob is a job known to the runner
+type registeredJob struct {
+	Job
+	run    jobFunc
+	next   time.Time          // next scheduled run
+	cancel context.CancelFunc // cancels the active run
+}
+
+// JobRunner runs registered jobs at their cron times, or on demand, with a limit on concurrent runs
+// A job never overlaps itself: a run triggered while the job is active is skipped.
+type JobRunner struct {
+	mu      sync.Mutex
+	jobs    map[string]*registeredJob
+	slots   chan struct{}
+	wg      sync.WaitGroup
+	stop    chan struct{}
+	stopped bool
+}
+
+// jobs is the server's background job runner
+var jobs = NewJobRunner(maxConcurrentJobs)
+
+// NewJobRunner returns a runner allowing n concurrent runs
+func NewJobRunner(n int) *JobRunner {
+	return &JobRunner{jobs: make(map[string]*registeredJob), slots: make(chan struct{}, n), stop: make(chan struct{})}
+}
+
+// Register adds a job with its default cron schedule, before the runner is started
+func (jr *JobRunner) Register(name, description, cron string, fn jobFunc) {
+	if _, err := parseCron(cron); err != nil {
+		panic(fmt.Sprintf("job %s: %v", name, err))
+	}
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.jobs[name] = &registeredJob{Job: Job{Name: name, Description: description, Cron: cron, Enabled: true}, run: fn}
+}
+
+// Start loads the stored job definitions and triggers jobs as they fall due, checking every interval
+// Runs left active by a previous process are recorded as interrupted.
+func (jr *JobRunner) Start(interval time.Duration) (err error) {
+	if _, err = db.Exec(`update jobRuns set status = 'interrupted', stopTime = ? where status in ('queued', 'running')`, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return
+	}
+
+	jr.mu.Lock()
+	for _, j := range jr.jobs {
+		if _, err = db.Exec(`insert or ignore into jobs (name, cron) values (?, ?)`, j.Name, j.Cron); err != nil {
+			break
+		}
+		if err = db.QueryRow(`select cron, enabled, timeout from jobs where name = ?`, j.Name).Scan(&j.Cron, &j.Enabled, &j.Timeout); err != nil {
+			break
+		}
+		j.schedule(time.Now())
+	}
+	jr.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-jr.stop:
+				return
+			case now := <-ticker.C:
+				jr.runDue(now)
+			}
+		}
+	}()
+	return
+}
+
+// schedule sets the next run of the job after t
+func (j *registeredJob) schedule(t time.Time) {
+	j.next = time.Time{}
+	if cs, err := parseCron(j.Cron); err == nil && j.Enabled {
+		j.next = cs.next(t)
+	}
+	j.NextRun = ""
+	if !j.next.IsZero() {
+		j.NextRun = j.next.UTC().Format(time.RFC3339)
+	}
+}
+
+// runDue triggers the enabled jobs whose next run is due
+func (jr *JobRunner) runDue(now time.Time) {
+	jr.mu.Lock()
+	var due []string
+	for _, j := range jr.jobs {
+		if !j.next.IsZero() && !now.Before(j.next) {
+			due = append(due, j.Name)
+			j.schedule(now)
+		}
+	}
+	jr.mu.Unlock()
+
+	for _, name := range due {
+		if _, err := jr.Trigger(name, "schedule"); err == errJobRunning {
+			log.Printf("job %s skipped, the previous run is still active", name)
+		} else if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// Trigger starts a run of a job, which waits for a free slot when the concurrency limit is reached
+func (jr *JobRunner) Trigger(name, trigger string) (run JobRun, err error) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	j, ok := jr.jobs[name]
+	switch {
+	case !ok:
+		return run, errJobNotFound
+	case jr.stopped:
+		return run, errRunnerStopped
+	case j.Running != 0:
+		return run, errJobRunning
+	}
+
+	run = JobRun{Name: name, Trigger: trigger, StartTime: time.Now().UTC().Format(time.RFC3339), Status: "queued"}
+	var res sql.Result
+	if res, err = db.Exec(`insert into jobRuns (name, trigger, startTime, status) values (?, ?, ?, ?)`, run.Name, run.Trigger, run.StartTime, run.Status); err != nil {
+		return
+	}
+	var id int64
+	if id, err = res.LastInsertId(); err != nil {
+		return
+	}
+	run.Id = int(id)
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if j.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(j.Timeout)*time.Second)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	j.Running, j.cancel = run.Id, cancel
+	jr.wg.Add(1)
+	go jr.execute(ctx, j, run)
+	return
+}
+
+// execute runs a job once a slot is free and records the outcome
+func (jr *JobRunner) execute(ctx context.Context, j *registeredJob, run JobRun) {
+	defer jr.wg.Done()
+	defer func() {
+		jr.mu.Lock()
+		j.cancel()
+		j.Running, j.cancel = 0, nil
+		jr.mu.Unlock()
+	}()
+
+	var message string
+	var err error
+	select {
+	case jr.slots <- struct{}{}:
+		run.Status = "running"
+		if _, err = db.Exec(`update jobRuns set status = ?, startTime = ? where runId = ?`, run.Status, time.Now().UTC().Format(time.RFC3339), run.Id); err != nil {
+			log.Println(err)
+		}
+		message, err = jr.call(ctx, j)
+		<-jr.slots
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	run.Status = "ok"
+	switch {
+	case err == nil:
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		run.Status, message = "cancelled", err.Error()
+	default:
+		run.Status, message = "failed", err.Error()
+		log.Printf("job %s failed: %v", j.Name, err)
+	}
+	if _, err = db.Exec(`update jobRuns set status = ?, stopTime = ?, message = ? where runId = ?`,
+		run.Status, time.Now().UTC().Format(time.RFC3339), message, run.Id); err != nil {
+		log.Println(err)
+	}
+}
+
+// call runs the job function, turning a panic into a failed run
+func (jr *JobRunner) call(ctx context.Context, j *registeredJob) (message string, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	message, err = j.run(ctx)
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return
+}
+
+// Cancel cancels the active run of a job, reporting whether one was running
+func (jr *JobRunner) Cancel(name string) bool {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	if j, ok := jr.jobs[name]; ok && j.cancel != nil {
+		j.cancel()
+		return true
+	}
+	return false
+}
+
+// Stop stops scheduling and waits for active runs to finish
+// Runs still active when ctx is done are cancelled, and Stop waits for them to return.
+func (jr *JobRunner) Stop(ctx context.Context) error {
+	jr.mu.Lock()
+	if !jr.stopped {
+		jr.stopped = true
+		close(jr.stop)
+	}
+	jr.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		jr.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	jr.mu.Lock()
+	for _, j := range jr.jobs {
+		if j.cancel != nil {
+			j.cancel()
+		}
+	}
+	jr.mu.Unlock()
+	<-done
+	return ctx.Err()
+}
+
+// Jobs returns the registered jobs by name
+func (jr *JobRunner) Jobs() (jl []Job) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	for _, j := range jr.jobs {
+		jl = append(jl, j.Job)
+	}
+	sort.Slice(jl, func(a, b int) bool { return jl[a].Name < jl[b].Name })
+	return
+}
+
+// Job returns a registered job
+func (jr *JobRunner) Job(name string) (Job, error) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	if j, ok := jr.jobs[name]; ok {
+		return j.Job, nil
+	}
+	return Job{}, errJobNotFound
+}
+
+// Update stores a new schedule, enabled state and timeout for a job and reschedules it
+func (jr *JobRunner) Update(job Job) (Job, error) {
+	if _, err := parseCron(job.Cron); err != nil {
+		return job, err
+	}
+	if job.Timeout < 0 {
+		return job, fmt.Errorf("timeout must not be negative")
+	}
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	j, ok := jr.jobs[job.Name]
+	if !ok {
+		return job, errJobNotFound
+	}
+	if _, err := db.Exec(`update jobs set cron = ?, enabled = ?, timeout = ? where name = ?`, job.Cron, job.Enabled, job.Timeout, job.Name); err != nil {
+		return j.Job, err
+	}
+	j.Cron, j.Enabled, j.Timeout = job.Cron, job.Enabled, job.Timeout
+	j.schedule(time.Now())
+	return j.Job, nil
+}
+
+// FetchJobRuns returns the latest runs of a job, newest first
+func FetchJobRuns(name string, limit int) (rl []JobRun, err error) {
+	defer observeQuery("FetchJobRuns", time.Now())
+	var rows *sql.Rows
+	if rows, err = db.Query(`select runId, name, trigger, startTime, IFNULL(stopTime, ""), status, IFNULL(message, "") from jobRuns where name = ? order by runId desc limit ?`, name, limit); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var run JobRun
+	for rows.Next() {
+		if err = rows.Scan(StructForScan(&run)...); err != nil {
+			return
+		}
+		rl = append(rl, run)
+	}
+	err = rows.Err()
+	return
+}
+
+// lastJobSuccess returns the start time of the last successful run of a job, the zero time if there is none
+func lastJobSuccess(name string) (t time.Time) {
+	var s string
+	if err := db.QueryRow(`select startTime from jobRuns where name = ? and status = 'ok' order by runId desc limit 1`, name).Scan(&s); err == nil {
+		t, _ = time.Parse(time.RFC3339, s)
+	}
+	return
+}
+
+// handleApiJobs is the endpoint for background jobs restful api
+// accepts:
+//
+//	GET /api/v1/jobs
+//
+// Jobs are not site scoped, they require the admin role at the default site.
+func handleApiJobs(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, defaultSiteId, true) {
+		return
+	}
+	if err := jsonApi(w, r, jobs.Jobs(), false); err != nil {
+		log.Println(err)
+	}
+}
+
+// requestJob returns the job named by the name path parameter, writing the error response when there is none
+// or the caller is not an admin of the default site, jobs run across every site.
+func requestJob(w http.ResponseWriter, r *http.Request) (job Job, ok bool) {
+	if !authorize(w, r, defaultSiteId, true) {
+		return job, false
+	}
+	name := pathParam(r, "name")
+	job, err := jobs.Job(name)
+	if err != nil {
+		apiFail(w, r, errNotFound("job %q not found", name))
+		return job, false
+	}
+	return job, true
+}
+
+// handleApiJob is the endpoint for a background job and its schedule
+// accepts:
+//
+//	GET  /api/v1/jobs/:name
+//	POST /api/v1/jobs/:name   {"cron": "0 3 * * *", "enabled": true, "timeout": 600}
+func handleApiJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := requestJob(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+			apiFail(w, r, errBadRequest("%v", err))
+			return
+		}
+		job.Name = pathParam(r, "name")
+		var err error
+		if job, err = jobs.Update(job); err != nil {
+			apiFail(w, r, errBadRequest("%v", err))
+			return
+		}
+	}
+	if err := jsonApiStatus(w, r, http.StatusOK, job); err != nil {
+		log.Println(err)
+	}
+}
+
+// handleApiJobRun starts a run of a background job now
+// accepts:
+//
+//	POST /api/v1/jobs/:name/run
+func handleApiJobRun(w http.ResponseWriter, r *http.Request) {
+	job, ok := requestJob(w, r)
+	if !ok {
+		return
+	}
+	run, err := jobs.Trigger(job.Name, "manual")
+	if err == errJobRunning || err == errRunnerStopped {
+		apiFail(w, r, errConflict("%v", err))
+		return
+	} else if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if err = jsonApiStatus(w, r, http.StatusAccepted, run); err != nil {
+		log.Println(err)
+	}
+}
+
+// handleApiJobCancel cancels the active run of a background job
+// accepts:
+//
+//	POST /api/v1/jobs/:name/cancel
+func handleApiJobCancel(w http.ResponseWriter, r *http.Request) {
+	job, ok := requestJob(w, r)
+	if !ok {
+		return
+	}
+	if !jobs.Cancel(job.Name) {
+		apiFail(w, r, errConflict("job is not running"))
+		return
+	}
+	if err := jsonApiStatus(w, r, http.StatusAccepted, job); err != nil {
+		log.Println(err)
+	}
+}
+
+// handleApiJobRuns is the run history of a background job, newest first
+// accepts:
+//
+//	GET /api/v1/jobs/:name/runs?limit=
+func handleApiJobRuns(w http.ResponseWriter, r *http.Request) {
+	job, ok := requestJob(w, r)
+	if !ok {
+		return
+	}
+	limit := 50
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 {
+			apiFail(w, r, errBadRequest("invalid limit %q", s))
+			return
+		}
+	}
+	jrl, err := FetchJobRuns(job.Name, limit)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if err = jsonApi(w, r, jrl, false); err != nil {
+		log.Println(err)
+	}
+}
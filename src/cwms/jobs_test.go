@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJobAuthorization(t *testing.T) {
+	seedTestDb(t)
+	north, err := FetchSite("north")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := CreateUser("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	northAdmin, err := CreateUser("northAdmin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = SetPermission(SitePermission{SiteId: north.Id, UserId: northAdmin.Id, Role: "admin"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// jobs run across every site, an admin of another site must not manage them
+	h := newApiRouter("/api/v1", false, apiRoutes())
+	for _, test := range []struct {
+		name, url, token string
+		status           int
+	}{
+		{"no token", "/api/v1/jobs", "", http.StatusUnauthorized},
+		{"admin", "/api/v1/jobs", admin.Token, http.StatusOK},
+		{"admin of another site", "/api/v1/jobs", northAdmin.Token, http.StatusForbidden},
+		{"job of another site admin", "/api/v1/jobs/reports/runs", northAdmin.Token, http.StatusForbidden},
+		{"site scoped", "/api/v1/sites/north/jobs", northAdmin.Token, http.StatusNotFound},
+	} {
+		r := httptest.NewRequest(http.MethodGet, test.url, nil)
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: GET %s status %d, expected %d", test.name, test.url, w.Code, test.status)
+		}
+	}
+}
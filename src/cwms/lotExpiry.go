@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ExpiryRecord is a position listed on the near expiry report, matches the v_lotExpiry view
+type ExpiryRecord struct {
+	InventoryId  int    `xml:"id,attr" json:"id" csv:"id"`
+	PositionId   int    `xml:"positionId" json:"positionId" csv:"position_id"`
+	Aisle        string `xml:"position>Aisle" json:"aisle" csv:"aisle"`
+	Block        string `xml:"position>Block" json:"block" csv:"block"`
+	Slot         string `xml:"position>Slot" json:"slot" csv:"slot"`
+	SKU          string `xml:"item>SKU" json:"sku" csv:"sku"`
+	Lot          string `xml:"item>Lot" json:"lot" csv:"lot"`
+	Expiry       string `xml:"item>Expiry" json:"expiry" csv:"expiry"`
+	Quantity     int    `xml:"item>Quantity" json:"quantity" csv:"quantity"`
+	ScannedLot   string `xml:"scan>Lot" json:"scannedLot" csv:"scanned_lot"`
+	DaysToExpiry *int   `xml:"daysToExpiry" json:"daysToExpiry" csv:"days_to_expiry"`
+	Expiring     bool   `xml:"expiring" json:"expiring" csv:"expiring"`
+	LotMismatch  bool   `xml:"lotMismatch" json:"lotMismatch" csv:"lot_mismatch"`
+}
+
+type ExpiryList []ExpiryRecord
+
+// ExpiryFilter holds near expiry report filter information
+type ExpiryFilter struct {
+	Days   int    // Report stock expiring within this many days, including stock already expired
+	Aisle  string // Filter on Aisle
+	SiteId int    // Filter on Site
+}
+
+// toSqlStmt generates a sql statement
+func (ef ExpiryFilter) toSqlStmt() (sqlstmt string, args []interface{}) {
+	days := `cast(julianday(expiry) - julianday(date('now')) as integer)`
+	sqlstmt = fmt.Sprintf(`select inventoryId, positionId, aisle, block, slot, sku, lot, expiry, quantity, scannedLot,
+		case when expiry != "" then %s end as daysToExpiry,
+		expiry != "" and %s <= ? as expiring,
+		lot != "" and scannedLot != "" and lot != scannedLot as lotMismatch
+		from v_lotExpiry where siteId = ? and (expiring or lotMismatch)`, days, days)
+	args = append(args, ef.Days, ef.SiteId)
+	if ef.Aisle != "" {
+		sqlstmt += ` and aisle = ?`
+		args = append(args, ef.Aisle)
+	}
+	sqlstmt += ` order by expiry = "", expiry, aisle, block, slot`
+	return
+}
+
+// FetchExpiry performs a query on v_lotExpiry and returns positions expiring soon or holding a different lot than the warehouse expects
+func FetchExpiry(ef ExpiryFilter) (el ExpiryList, err error) {
+	defer observeQuery("FetchExpiry", time.Now())
+	// Execute database query
+	var rows *sql.Rows
+	sqlstmt, args := ef.toSqlStmt()
+	if rows, err = db.Query(sqlstmt, args...); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	// Process query results
+	for rows.Next() {
+		var er ExpiryRecord
+		if err = rows.Scan(StructForScan(&er)...); err != nil {
+			return
+		}
+		el = append(el, er)
+	}
+	err = rows.Err()
+	return
+}
+
+// handleApiExpiryReport is the endpoint for the near expiry report
+// accepts:
+//
+//	GET /api/v1/reports/expiry?days=:days&aisle=:aisle
+//
+// Lists positions whose stock expires within days (default 30) or where the scanned lot differs from the warehouse lot.
+func handleApiExpiryReport(w http.ResponseWriter, r *http.Request) {
+	urlParams := r.URL.Query()
+	ef := ExpiryFilter{Days: 30, Aisle: urlParams.Get("aisle"), SiteId: requestSite(r).Id}
+	if d := urlParams.Get("days"); d != "" {
+		var err error
+		if ef.Days, err = strconv.Atoi(d); err != nil || ef.Days < 0 {
+			apiFail(w, r, errBadRequest("invalid days %q", d))
+			return
+		}
+	}
+
+	el, err := FetchExpiry(ef)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if err = jsonApi(w, r, el, false); err != nil {
+		log.Println(err)
+	}
+}
@@ -1,20 +1,27 @@
-// 	Corvus Warehouse Management System (cwms)
-// 	Provides a suite of displays that allows a user to:
-// 	• View the entire inventory
-// 	• Navigate by aisle
-// 	• Filter by discrepancies
-//	• Compare Drone Inventory to Warehouse Inventory
+// Corvus Warehouse Management System (cwms)
+// Provides a suite of displays that allows a user to:
+// • View the entire inventory
+// • Navigate by aisle
+// • Filter by discrepancies
+// • Compare Drone Inventory to Warehouse Inventory
 package main
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
-	"html/template"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3" // Reference for installed sqlite3 driver
+	"github.com/mattn/go-sqlite3"
 )
 
 // Min implements an integer min function
@@ -36,6 +43,17 @@ func Max(x, y int) int {
 // db is a global declaration for the database
 var db *sql.DB
 
+// dbDriver is the sqlite3 driver with the sql functions used by the migrations
+const dbDriver = "sqlite3_cwms"
+
+func init() {
+	sql.Register(dbDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("sha256", tokenHash, true)
+		},
+	})
+}
+
 // type LocalFileSystem implements an http fileserver handler to perform local file system functions.
 type LocalFileSystem struct {
 	fs http.FileSystem
@@ -57,22 +75,6 @@ func (l LocalFileSystem) Open(name string) (f http.File, err error) {
 	return
 }
 
-// executeTemplate parses and executes the specified html template file
-func executeTemplate(f string, tm map[string]interface{}, w http.ResponseWriter) (err error) {
-	// parse html template file
-	t, err := template.ParseFiles(f)
-	if err != nil {
-		return
-	}
-
-	// execute html template
-	err = t.Execute(w, tm)
-	if err != nil {
-		return
-	}
-	return
-}
-
 // amw is the api middleware handler that handles OPTIONS
 func amw(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -94,76 +96,180 @@ func amw(next http.HandlerFunc) http.HandlerFunc {
 }
 
 // main
-// 	• opens the database
-// 	• sets up the mutex
-// 	• sets up the http handlers
-// 	• listens and serves
+//   - opens the database
+//   - runs the subcommand, serve when none is given
+//
+// Startup and command failures exit with a non-zero status.
 func main() {
 	// Setup logger
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	var err error
+	dbFile := flag.String("db", "./wms3.db", "sqlite database file")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, serverUsage) }
+	flag.Parse()
+
+	name, args := "serve", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := serverCommands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "cwms: unknown command %q\n", name)
+		flag.Usage()
+		os.Exit(2)
+	}
 
 	// Open global database
-	db, err = sql.Open("sqlite3", `./wms3.db`)
+	var err error
+	db, err = sql.Open(dbDriver, *dbFile)
 	if err != nil {
-		log.Println(err)
+		log.Fatal(err)
 	}
 	defer db.Close()
+	if err = db.Ping(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err = cmd(args); err != nil {
+		db.Close()
+		log.Fatal(err)
+	}
+}
+
+// serve
+//   - sets up the background jobs
+//   - sets up the http handlers
+//   - listens and serves on addr until SIGINT or SIGTERM, then drains requests and background jobs
+func serve(addr string) {
+	var err error
+
+	// Start background jobs
+	jobs.Register("imageRetention", "removes uploaded images older than the retention period", "0 3 * * *", imageRetentionJob)
+	jobs.Register("reports", "delivers scheduled reports that are due", "* * * * *", reportJob)
+	jobs.Register("reconcile", "reconciles the latest scan of every position against the inventory", "*/30 * * * *", reconcileJob)
+	if err = jobs.Start(time.Minute); err != nil {
+		log.Fatal(err)
+	}
 
 	// Setup servemux to serve http handler routines
 	mux := http.NewServeMux()
 
-	// Setup file server handler
-	files := http.FileServer(LocalFileSystem{http.Dir(`./static/`)})
-	mux.Handle("/static/", http.StripPrefix("/static/", files))
+	// Setup file server handler, pages and static files are embedded unless in dev mode
+	static, err := loadAssets()
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux.Handle("/static/", http.StripPrefix("/static/", static))
+
+	// pages, the map, exports and images are scoped to the site url parameter and authorized by pmw
+	mux.HandleFunc("/images/", pmw(handleImages))
 
 	// Setup http handlers
-	mux.HandleFunc("/", imw(handleDashboard))
-	mux.HandleFunc("/dashboard/", imw(handleDashboard))
-	mux.HandleFunc("/inventory/", imw(handleInventory))
-	mux.HandleFunc("/hybrid/", imw(handleHybrid))
-	mux.HandleFunc("/schedule/", mmw(handleSchedule))
-    mux.HandleFunc("/export/csv/", imw(handleExportInventoryCsv))
-	mux.HandleFunc("/export/json/", imw(handleExportInventoryJson))
-	mux.HandleFunc("/export/xml/", imw(handleExportInventoryXml))
-	// restful api handlers
-	mux.Handle("/api/", http.NotFoundHandler())
-	mux.HandleFunc("/api/json/", imw(handleApiInventoryJson))
-	mux.HandleFunc("/api/aisles/", amw(handleApiAisles))
-	mux.HandleFunc("/api/discrepancy/", amw(handleApiDiscrepancies))
-	mux.HandleFunc("/api/restrictions/", amw(handleApiRestrictions))
-	mux.HandleFunc("/api/flights/", amw(handleApiFlights))
-	mux.HandleFunc("/api/statistics/", amw(handleApiStatistics))
-	mux.HandleFunc("/api/queue/", amw(handleApiQueue))
-	mux.HandleFunc("/api/schedule/", amw(handleApiQueue))
-	mux.HandleFunc("/api/custom_flights/", amw(handleApiCustomQueue))
-
-	// Listen and serve mux to port 8081
-	http.ListenAndServe(":8081", mux)
+	mux.HandleFunc("/", pmw(handleDashboard))
+	mux.HandleFunc("/dashboard/", pmw(handleDashboard))
+	mux.HandleFunc("/inventory/", pmw(handleInventory))
+	mux.HandleFunc("/hybrid/", pmw(handleHybrid))
+	mux.HandleFunc("/schedule/", pmw(mmw(handleSchedule)))
+	mux.HandleFunc("/map/", pmw(handleMap))
+	mux.HandleFunc("/positions/", pmw(handlePosition))
+	mux.HandleFunc("/export/csv/", pmw(handleExportInventoryCsv))
+	mux.HandleFunc("/export/json/", pmw(handleExportInventoryJson))
+	mux.HandleFunc("/export/xml/", pmw(handleExportInventoryXml))
+	mux.HandleFunc("/export/xlsx/", pmw(handleExportInventoryXlsx))
+	mux.HandleFunc("/export/ndjson/", pmw(handleExportInventoryNdjson))
+	// restful api handlers, the unversioned routes are deprecated aliases of /api/v1
+	routes := apiRoutes()
+	mux.Handle("/api/v1/", newApiRouter("/api/v1", false, routes))
+	mux.Handle("/api/", newApiRouter("/api", true, routes))
+
+	// health checks and metrics are not authenticated
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz)
+	mux.HandleFunc("/metrics", handleMetrics)
+
+	// Listen and serve mux on addr
+	// Write timeout allows for streaming large exports.
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           metricsMiddleware(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		WriteTimeout:      5 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err = <-serveErr:
+		log.Fatal(err)
+	case sig := <-stop:
+		log.Printf("received %v, shutting down", sig)
+	}
+
+	// Drain in flight requests and background jobs, cancelling whatever is still running after the grace period
+	atomic.StoreInt32(&shuttingDown, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err = srv.Shutdown(ctx); err != nil {
+		log.Println(err)
+	}
+	if err = jobs.Stop(ctx); err != nil {
+		log.Println(err)
+	}
 }
 
 // jsonApi implements a simple restful api to export data in a json format
+// The status follows the request method: 201 for POST, 204 with no body for DELETE, otherwise 200.
+// Errors are written as problem documents with apiFail.
 func jsonApi(w http.ResponseWriter, r *http.Request, data interface{}, implemented bool) (err error) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch:
+		return jsonApiStatus(w, r, http.StatusOK, data)
+	case http.MethodPost:
+		return jsonApiStatus(w, r, http.StatusCreated, data)
+	case http.MethodDelete:
+		return jsonApiStatus(w, r, http.StatusNoContent, nil)
+	default:
+		apiFail(w, r, errMethodNotAllowed(r))
+		return
+	}
+}
+
+// jsonApiStatus writes data with the given status, in json unless the request negotiates xml or csv
+func jsonApiStatus(w http.ResponseWriter, r *http.Request, status int, data interface{}) (err error) {
+	format, err := negotiateFormat(r)
+	if err != nil {
+		apiFail(w, r, err)
+		return nil
+	}
+
+	// xml and csv are encoded before the status is written so a type without a representation is not acceptable
+	var body bytes.Buffer
+	if format != "json" && status != http.StatusNoContent {
+		if err = encodeApiData(&body, format, data); err != nil {
+			apiFail(w, r, errNotAcceptable("%s is not available as %s: %v", r.URL.Path, format, err))
+			return nil
+		}
+	}
+
 	// set content type in header
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", apiFormats[format])
+	w.Header().Add("Vary", "Accept")
 	w.Header().Add("Vary", "Origin")
 	w.Header().Add("Access-Control-Allow-Origin", "*")
 	w.Header().Add("Access-Control-Allow-Methods", "POST, OPTIONS, GET, DELETE, PUT, PATCH")
 	w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
 
-	// set appropriate response code based on client request method
-	switch r.Method {
-	case http.MethodGet:
-		w.WriteHeader(http.StatusOK)
-	case http.MethodDelete:
-		w.WriteHeader(http.StatusAccepted)
-	case http.MethodPost:
-		w.WriteHeader(http.StatusCreated)
-	case http.MethodPatch:
-		w.WriteHeader(http.StatusCreated)
-	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	w.WriteHeader(status)
+	if status == http.StatusNoContent {
+		return
+	}
+	if format != "json" {
+		_, err = w.Write(body.Bytes())
+		return
 	}
 	if err = json.NewEncoder(w).Encode(data); err != nil {
 		log.Println(err)
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histogram buckets
+var latencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Server metrics exposed at /metrics in the Prometheus text format
+// Alert on no flights ingested in 24h with: sum(increase(cwms_flights_ingested_total[24h])) == 0
+var (
+	httpRequests = &counterVec{name: "cwms_http_requests_total", help: "HTTP requests by mux route, method and status code.",
+		labels: []string{"route", "method", "code"}}
+	httpDuration = &histogramVec{name: "cwms_http_request_duration_seconds", help: "HTTP request latency by mux route and method.",
+		labels: []string{"route", "method"}}
+	dbQueryDuration = &histogramVec{name: "cwms_db_query_duration_seconds", help: "Database query duration by Fetch function.",
+		labels: []string{"function"}}
+	flightsIngested = &counterVec{name: "cwms_flights_ingested_total", help: "Flights uploaded by site.",
+		labels: []string{"site"}}
+)
+
+// labelPairs formats label names and values as name="value" pairs
+func labelPairs(names, values []string) string {
+	pl := make([]string, len(names))
+	for i, name := range names {
+		pl[i] = name + "=" + strconv.Quote(values[i])
+	}
+	return strings.Join(pl, ",")
+}
+
+// counterVec is a counter with a series per set of label values
+type counterVec struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	series     map[string]float64
+}
+
+// Inc adds one to the series with the given label values
+func (c *counterVec) Inc(values ...string) {
+	key := labelPairs(c.labels, values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.series == nil {
+		c.series = make(map[string]float64)
+	}
+	c.series[key]++
+}
+
+// write writes the counter in the Prometheus text format
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.series) {
+		fmt.Fprintf(w, "%s{%s} %g\n", c.name, key, c.series[key])
+	}
+}
+
+// histogram is the bucket counts, sum and count of a histogram series
+type histogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// histogramVec is a latency histogram with a series per set of label values
+type histogramVec struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	series     map[string]*histogram
+}
+
+// Observe records a value in the series with the given label values
+func (h *histogramVec) Observe(v float64, values ...string) {
+	key := labelPairs(h.labels, values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.series == nil {
+		h.series = make(map[string]*histogram)
+	}
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{buckets: make([]uint64, len(latencyBuckets))}
+		h.series[key] = s
+	}
+	for i, le := range latencyBuckets {
+		if v <= le {
+			s.buckets[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// write writes the histogram in the Prometheus text format
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", h.name, key, le, s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.name, key, s.count)
+		fmt.Fprintf(w, "%s_sum{%s} %g\n%s_count{%s} %d\n", h.name, key, s.sum, h.name, key, s.count)
+	}
+}
+
+// sortedKeys returns the keys of a series map in order
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// observeQuery records the duration of a Fetch function, deferred at its start:
+//
+//	defer observeQuery("FetchFlights", time.Now())
+func observeQuery(function string, start time.Time) {
+	dbQueryDuration.Observe(time.Since(start).Seconds(), function)
+}
+
+// statusWriter records the status code written to a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	route  string // set by the api routers to the matched route pattern
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes flushes through to streaming responses
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// metricsMiddleware counts and times requests by the mux pattern, or api route pattern, that serves them
+func metricsMiddleware(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(sw, r)
+		if sw.route != "" {
+			route = sw.route
+		}
+		httpRequests.Inc(route, r.Method, strconv.Itoa(sw.status))
+		httpDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
+
+// writeInventoryGauges writes the per aisle inventory gauges from aisleSummaries
+func writeInventoryGauges(w io.Writer) (err error) {
+	rows, err := db.Query(`select code, aisle, numberEmpty + numberOccupied + numberUnscanned, numberException, numberUnscanned
+		from aisleSummaries join sites using(siteId) order by code, aisle`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	gauges := []struct{ name, help string }{
+		{"cwms_aisle_slots", "Inventory slots by site and aisle."},
+		{"cwms_aisle_exceptions", "Inventory slots with a discrepancy by site and aisle."},
+		{"cwms_aisle_unscanned", "Inventory slots not yet scanned by site and aisle."},
+	}
+	lines := make([][]string, len(gauges))
+	for rows.Next() {
+		var site, aisle string
+		var v [3]int
+		if err = rows.Scan(&site, &aisle, &v[0], &v[1], &v[2]); err != nil {
+			return
+		}
+		for i, g := range gauges {
+			lines[i] = append(lines[i], fmt.Sprintf("%s{site=%q,aisle=%q} %d\n", g.name, site, aisle, v[i]))
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	for i, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s", g.name, g.help, g.name, strings.Join(lines[i], ""))
+	}
+	return
+}
+
+// writeQueueDepth writes the number of missions queued at each site
+func writeQueueDepth(w io.Writer) (err error) {
+	rows, err := db.Query(`select code, (select count(*) from events join regions using(regionId) where regions.siteId = sites.siteId) from sites order by code`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	fmt.Fprintf(w, "# HELP cwms_queue_depth Missions in the flight queue by site.\n# TYPE cwms_queue_depth gauge\n")
+	for rows.Next() {
+		var site string
+		var depth int
+		if err = rows.Scan(&site, &depth); err != nil {
+			return
+		}
+		fmt.Fprintf(w, "cwms_queue_depth{site=%q} %d\n", site, depth)
+	}
+	return rows.Err()
+}
+
+// handleMetrics exposes server metrics in the Prometheus text format
+// accepts:
+//
+//	GET /metrics
+//
+// Like the health checks it is not authenticated, restrict it to the scraper's network.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	httpRequests.write(w)
+	httpDuration.write(w)
+	dbQueryDuration.write(w)
+	flightsIngested.write(w)
+	if err := writeInventoryGauges(w); err != nil {
+		log.Println(err)
+	}
+	if err := writeQueueDepth(w); err != nil {
+		log.Println(err)
+	}
+}
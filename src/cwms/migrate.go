@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// migrationFiles holds the schema migrations, NNNN_name.up.sql applies version NNNN and NNNN_name.down.sql reverts it
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migration is a schema version and the sql applying and reverting it
+type migration struct {
+	Version  int
+	Name     string
+	Up, Down string
+}
+
+// loadMigrations returns the embedded migrations in version order
+// Versions must run from 1 without gaps and each needs both an up and a down file.
+func loadMigrations() (ml []migration, err error) {
+	var files []string
+	if files, err = fs.Glob(migrationFiles, "migrations/*.sql"); err != nil {
+		return
+	}
+	byVersion := make(map[int]*migration)
+	for _, f := range files {
+		base := path.Base(f)
+		sl := strings.SplitN(strings.TrimSuffix(base, ".sql"), "_", 2)
+		version, verr := strconv.Atoi(sl[0])
+		if len(sl) != 2 || verr != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s is not named NNNN_name.up.sql or NNNN_name.down.sql", base)
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &migration{Version: version}
+			byVersion[version] = m
+		}
+		var b []byte
+		if b, err = migrationFiles.ReadFile(f); err != nil {
+			return
+		}
+		switch ext := path.Ext(sl[1]); ext {
+		case ".up":
+			m.Name, m.Up = strings.TrimSuffix(sl[1], ext), string(b)
+		case ".down":
+			m.Down = string(b)
+		default:
+			return nil, fmt.Errorf("migration %s is neither up nor down", base)
+		}
+	}
+
+	for v := 1; v <= len(byVersion); v++ {
+		m := byVersion[v]
+		if m == nil {
+			return nil, fmt.Errorf("migration %04d is missing", v)
+		}
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d needs an up and a down file", v)
+		}
+		ml = append(ml, *m)
+	}
+	return
+}
+
+// schemaVersion is the schema version the server expects, that of the latest migration
+func schemaVersion() int {
+	ml, err := loadMigrations()
+	if err != nil || len(ml) == 0 {
+		return 0
+	}
+	return ml[len(ml)-1].Version
+}
+
+// fetchSchemaVersion returns the migration version of the database, kept in PRAGMA user_version
+func fetchSchemaVersion() (version int, err error) {
+	err = db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	return
+}
+
+// applyMigration runs the up or down sql of a migration and records the resulting version in one transaction
+// Foreign keys are off while it runs, sqlite cannot add a column referencing another table with a default otherwise.
+func applyMigration(m migration, up bool) (err error) {
+	script, version := m.Up, m.Version
+	if !up {
+		script, version = m.Down, m.Version-1
+	}
+
+	// PRAGMA foreign_keys is per connection and has no effect inside a transaction
+	ctx := context.Background()
+	var conn *sql.Conn
+	if conn, err = db.Conn(ctx); err != nil {
+		return
+	}
+	defer conn.Close()
+	var foreignKeys int
+	if err = conn.QueryRowContext(ctx, `PRAGMA foreign_keys`).Scan(&foreignKeys); err != nil {
+		return
+	}
+	if _, err = conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return
+	}
+	defer conn.ExecContext(ctx, fmt.Sprintf(`PRAGMA foreign_keys = %d`, foreignKeys))
+
+	var tx *sql.Tx
+	if tx, err = conn.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	if _, err = tx.Exec(script); err != nil {
+		return fmt.Errorf("migration %04d_%s: %v", m.Version, m.Name, err)
+	}
+	// PRAGMA does not take parameters
+	if _, err = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+// MigrateUp applies the migrations after the database's version, up to and including target, 0 for the latest
+func MigrateUp(target int) (applied []migration, err error) {
+	var ml []migration
+	if ml, err = loadMigrations(); err != nil {
+		return
+	}
+	var current int
+	if current, err = fetchSchemaVersion(); err != nil {
+		return
+	}
+	if current > len(ml) {
+		return nil, fmt.Errorf("database schema version %d is newer than the latest migration %d", current, len(ml))
+	}
+	for _, m := range ml {
+		if m.Version <= current || (target != 0 && m.Version > target) {
+			continue
+		}
+		if err = applyMigration(m, true); err != nil {
+			return
+		}
+		applied = append(applied, m)
+	}
+	return
+}
+
+// MigrateDown reverts the latest steps applied migrations
+func MigrateDown(steps int) (reverted []migration, err error) {
+	var ml []migration
+	if ml, err = loadMigrations(); err != nil {
+		return
+	}
+	var current int
+	if current, err = fetchSchemaVersion(); err != nil {
+		return
+	}
+	if current > len(ml) {
+		return nil, fmt.Errorf("database schema version %d is newer than the latest migration %d", current, len(ml))
+	}
+	for v := current; v > 0 && v > current-steps; v-- {
+		if err = applyMigration(ml[v-1], false); err != nil {
+			return
+		}
+		reverted = append(reverted, ml[v-1])
+	}
+	return
+}
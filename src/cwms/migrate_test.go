@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// openTestDb opens an empty database in a temporary directory as the global db
+func openTestDb(t testing.TB) {
+	t.Helper()
+	var err error
+	if db, err = sql.Open(dbDriver, filepath.Join(t.TempDir(), "wms.db")); err != nil {
+		t.Fatal(err)
+	}
+	// one connection, so connection pragmas hold for every statement
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+}
+
+// seedTestDb opens a database migrated to the latest schema and loaded with the sample warehouse
+func seedTestDb(t testing.TB) {
+	t.Helper()
+	openTestDb(t)
+	if _, err := MigrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := seedCommand([]string{"-fixtures"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	openTestDb(t)
+	baseline, err := ioutil.ReadFile("testdata/baseline.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(string(baseline)); err != nil {
+		t.Fatal(err)
+	}
+	var records int
+	if err = db.QueryRow(`select count(*) from inventory`).Scan(&records); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = MigrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+	version, err := fetchSchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != schemaVersion() {
+		t.Fatalf("migrated to version %d, expected %d", version, schemaVersion())
+	}
+
+	// the baseline warehouse belongs to the default site and keeps its inventory
+	var siteRecords int
+	if err = db.QueryRow(`select count(*) from v_inventory where siteId = 1`).Scan(&siteRecords); err != nil {
+		t.Fatal(err)
+	}
+	if siteRecords != records {
+		t.Errorf("%d inventory records in the default site, expected %d", siteRecords, records)
+	}
+	var summarised int
+	if err = db.QueryRow(`select IFNULL(sum(numberRecords), 0) from aisleSummaries`).Scan(&summarised); err != nil {
+		t.Fatal(err)
+	}
+	if summarised != records {
+		t.Errorf("aisle summaries count %d records, expected %d", summarised, records)
+	}
+}
+
+func TestMigrateDownUp(t *testing.T) {
+	openTestDb(t)
+	if _, err := MigrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+	reverted, err := MigrateDown(schemaVersion())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != schemaVersion() {
+		t.Errorf("reverted %d migrations, expected %d", len(reverted), schemaVersion())
+	}
+	var objects int
+	if err = db.QueryRow(`select count(*) from sqlite_master where name not like 'sqlite_%'`).Scan(&objects); err != nil {
+		t.Fatal(err)
+	}
+	if objects != 0 {
+		t.Errorf("%d schema objects left after reverting every migration", objects)
+	}
+	if _, err = MigrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSeedFixtures(t *testing.T) {
+	seedTestDb(t)
+	var positions int
+	if err := db.QueryRow(`select count(*) from positions`).Scan(&positions); err != nil {
+		t.Fatal(err)
+	}
+	if positions == 0 {
+		t.Error("the sample warehouse has no positions")
+	}
+}
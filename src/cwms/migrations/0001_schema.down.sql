@@ -0,0 +1,17 @@
+-- Drops the initial schema, views before the tables they select from
+DROP VIEW IF EXISTS v_schedule;
+DROP VIEW IF EXISTS v_regionPosition;
+DROP VIEW IF EXISTS v_aisleStats;
+DROP VIEW IF EXISTS v_inventory;
+DROP VIEW IF EXISTS v_restrictions;
+DROP VIEW IF EXISTS v_flightList;
+DROP TABLE IF EXISTS inventory;
+DROP TABLE IF EXISTS images;
+DROP TABLE IF EXISTS events;
+DROP TABLE IF EXISTS restrictions;
+DROP TABLE IF EXISTS positions;
+DROP TABLE IF EXISTS regionPositions;
+DROP TABLE IF EXISTS flightPositions;
+DROP TABLE IF EXISTS regions;
+DROP TABLE IF EXISTS flights;
+DROP TABLE IF EXISTS items;
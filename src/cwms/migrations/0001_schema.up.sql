@@ -1,22 +1,4 @@
-PRAGMA foreign_keys = ON;
-
-
-DROP VIEW IF EXISTS v_schedule;
-DROP VIEW IF EXISTS v_regionPosition;
-DROP VIEW IF EXISTS v_aisleStats;
-DROP VIEW IF EXISTS v_inventory;
-DROP VIEW IF EXISTS v_restrictions;
-DROP VIEW IF EXISTS v_flightList;
-DROP TABLE IF EXISTS inventory;
-DROP TABLE IF EXISTS events;
-DROP TABLE IF EXISTS restrictions;
-DROP TABLE IF EXISTS positions;
-DROP TABLE IF EXISTS regionPositions;
-DROP TABLE IF EXISTS flightPositions;
-DROP TABLE IF EXISTS regions;
-DROP TABLE IF EXISTS flights;
-DROP TABLE IF EXISTS items;
-
+-- Initial schema: positions, inventory, regions, the mission queue, restrictions and flights
 
 CREATE TABLE IF NOT EXISTS positions (
   positionId INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -56,7 +38,7 @@ CREATE TABLE IF NOT EXISTS inventory (
 -- https://www.sqlite.org/lang_datefunc.html
 -- https://www.sqlite.org/draft/datatype3.html
 
-CREATE VIEW v_inventory
+CREATE VIEW IF NOT EXISTS v_inventory
   AS SELECT
     inventoryId,
     startTime,
@@ -178,7 +160,7 @@ CREATE TABLE IF NOT EXISTS flightPositions (
   positionId INTEGER REFERENCES positions(positionId)
 );
 
-CREATE VIEW v_flightList
+CREATE VIEW IF NOT EXISTS v_flightList
   AS SELECT
     flightId AS flightId,
     time(time) AS time,
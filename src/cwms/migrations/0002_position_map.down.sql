@@ -0,0 +1,23 @@
+-- Reverts the warehouse map views
+
+DROP VIEW IF EXISTS v_inventory;
+DROP VIEW IF EXISTS v_positionMap;
+
+CREATE VIEW v_inventory
+  AS SELECT
+    inventoryId,
+    startTime,
+    stopTime,
+    items.sku AS sku,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    json_extract(positions.json_position, "$.shelf") AS shelf,
+    json_extract(positions.json_position, "$.displayname") AS displayName,
+    items.discrepancy AS discrepancy,
+    images.imageUrl AS imageUrl
+  FROM
+    inventory
+    LEFT JOIN positions USING(positionId)
+    LEFT JOIN items USING(itemId)
+    LEFT JOIN images USING(imageId);
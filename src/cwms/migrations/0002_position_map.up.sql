@@ -0,0 +1,43 @@
+-- Warehouse map: v_inventory gains positionId and blank shelf and display names, v_positionMap summarises every position
+
+-- changed views are dropped and recreated
+DROP VIEW IF EXISTS v_inventory;
+DROP VIEW IF EXISTS v_positionMap;
+
+CREATE VIEW v_inventory
+  AS SELECT
+    inventoryId,
+    startTime,
+    stopTime,
+    items.sku AS sku,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    IFNULL(json_extract(positions.json_position, "$.shelf"), "") AS shelf,
+    IFNULL(json_extract(positions.json_position, "$.displayname"), "") AS displayName,
+    items.discrepancy AS discrepancy,
+    images.imageUrl AS imageUrl,
+    positionId
+  FROM
+    inventory
+    LEFT JOIN positions USING(positionId)
+    LEFT JOIN items USING(itemId)
+    LEFT JOIN images USING(imageId);
+
+-- v_positionMap summarises every position, scanned or not, for the warehouse map
+CREATE VIEW v_positionMap
+  AS SELECT
+    positions.positionId AS positionId,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    sum(case when items.discrepancy != "" then 1 else 0 end) as numberException,
+    sum(case when items.sku = "empty" then 1 else 0 end) as numberEmpty,
+    sum(case when items.sku != "empty" and items.sku is not null then 1 else 0 end) as numberOccupied,
+    IFNULL(max(inventory.stopTime), "") as lastScanned
+  FROM
+    positions
+    LEFT JOIN inventory USING(positionId)
+    LEFT JOIN items USING(itemId)
+  GROUP BY
+    positions.positionId;
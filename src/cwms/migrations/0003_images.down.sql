@@ -0,0 +1,30 @@
+-- Drops the scan photo columns
+
+DROP VIEW IF EXISTS v_inventory;
+
+CREATE VIEW v_inventory
+  AS SELECT
+    inventoryId,
+    startTime,
+    stopTime,
+    items.sku AS sku,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    IFNULL(json_extract(positions.json_position, "$.shelf"), "") AS shelf,
+    IFNULL(json_extract(positions.json_position, "$.displayname"), "") AS displayName,
+    items.discrepancy AS discrepancy,
+    images.imageUrl AS imageUrl,
+    positionId
+  FROM
+    inventory
+    LEFT JOIN positions USING(positionId)
+    LEFT JOIN items USING(itemId)
+    LEFT JOIN images USING(imageId);
+
+DROP INDEX IF EXISTS idx_imageHash;
+ALTER TABLE images DROP COLUMN fpId;
+ALTER TABLE images DROP COLUMN createdTime;
+ALTER TABLE images DROP COLUMN size;
+ALTER TABLE images DROP COLUMN contentType;
+ALTER TABLE images DROP COLUMN hash;
@@ -0,0 +1,33 @@
+-- Scan photos: uploaded images are stored on disk by the sha256 hash of their content
+
+ALTER TABLE images ADD COLUMN hash TEXT;
+ALTER TABLE images ADD COLUMN contentType TEXT;
+ALTER TABLE images ADD COLUMN size INTEGER;
+ALTER TABLE images ADD COLUMN createdTime DATETIME;
+ALTER TABLE images ADD COLUMN fpId INTEGER REFERENCES flightPositions(fpId);
+DROP INDEX IF EXISTS idx_imageHash;
+CREATE INDEX idx_imageHash ON images (hash);
+
+-- changed views are dropped and recreated
+DROP VIEW IF EXISTS v_inventory;
+
+CREATE VIEW v_inventory
+  AS SELECT
+    inventoryId,
+    startTime,
+    stopTime,
+    items.sku AS sku,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    IFNULL(json_extract(positions.json_position, "$.shelf"), "") AS shelf,
+    IFNULL(json_extract(positions.json_position, "$.displayname"), "") AS displayName,
+    items.discrepancy AS discrepancy,
+    images.imageUrl AS imageUrl,
+    positionId,
+    '/images/' || images.hash || '/thumbnail' AS thumbnailUrl
+  FROM
+    inventory
+    LEFT JOIN positions USING(positionId)
+    LEFT JOIN items USING(itemId)
+    LEFT JOIN images USING(imageId);
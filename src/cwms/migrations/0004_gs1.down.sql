@@ -0,0 +1,28 @@
+-- Drops the GS1 columns
+
+DROP VIEW IF EXISTS v_flightList;
+
+CREATE VIEW v_flightList
+  AS SELECT
+    flightId AS flightId,
+    time(time) AS time,
+    flightPositions.sku AS sku,
+    flightPositions.occupancy AS occupancy,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot
+  FROM
+    flights
+    LEFT JOIN flightPositions USING(flightId)
+    LEFT JOIN positions USING(positionId);
+
+DROP INDEX IF EXISTS idx_gtin;
+ALTER TABLE items DROP COLUMN lot;
+ALTER TABLE items DROP COLUMN gtin;
+
+ALTER TABLE flightPositions DROP COLUMN serial;
+ALTER TABLE flightPositions DROP COLUMN sscc;
+ALTER TABLE flightPositions DROP COLUMN expiry;
+ALTER TABLE flightPositions DROP COLUMN lot;
+ALTER TABLE flightPositions DROP COLUMN gtin;
+ALTER TABLE flightPositions DROP COLUMN barcode;
@@ -0,0 +1,36 @@
+-- GS1 barcodes: items and flight scans carry the decoded GTIN and lot, scans also the raw label and the other Application Identifiers
+
+ALTER TABLE items ADD COLUMN gtin TEXT;
+ALTER TABLE items ADD COLUMN lot TEXT;
+DROP INDEX IF EXISTS idx_gtin;
+CREATE INDEX idx_gtin ON items (gtin);
+
+ALTER TABLE flightPositions ADD COLUMN barcode text;
+ALTER TABLE flightPositions ADD COLUMN gtin text;
+ALTER TABLE flightPositions ADD COLUMN lot text;
+ALTER TABLE flightPositions ADD COLUMN expiry text;
+ALTER TABLE flightPositions ADD COLUMN sscc text;
+ALTER TABLE flightPositions ADD COLUMN serial text;
+
+-- changed views are dropped and recreated
+DROP VIEW IF EXISTS v_flightList;
+
+CREATE VIEW v_flightList
+  AS SELECT
+    flightId AS flightId,
+    time(time) AS time,
+    flightPositions.sku AS sku,
+    flightPositions.occupancy AS occupancy,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    IFNULL(json_extract(positions.json_position, "$.shelf"), "") AS shelf,
+    IFNULL(flightPositions.barcode, "") AS barcode,
+    IFNULL(flightPositions.gtin, "") AS gtin,
+    IFNULL(flightPositions.lot, "") AS lot,
+    IFNULL(flightPositions.expiry, "") AS expiry,
+    IFNULL(flightPositions.sscc, "") AS sscc
+  FROM
+    flights
+    LEFT JOIN flightPositions USING(flightId)
+    LEFT JOIN positions USING(positionId);
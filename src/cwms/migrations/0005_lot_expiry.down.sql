@@ -0,0 +1,28 @@
+-- Drops the expiry and quantity columns
+
+DROP VIEW IF EXISTS v_inventory;
+DROP VIEW IF EXISTS v_lotExpiry;
+
+CREATE VIEW v_inventory
+  AS SELECT
+    inventoryId,
+    startTime,
+    stopTime,
+    items.sku AS sku,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    IFNULL(json_extract(positions.json_position, "$.shelf"), "") AS shelf,
+    IFNULL(json_extract(positions.json_position, "$.displayname"), "") AS displayName,
+    items.discrepancy AS discrepancy,
+    images.imageUrl AS imageUrl,
+    positionId,
+    '/images/' || images.hash || '/thumbnail' AS thumbnailUrl
+  FROM
+    inventory
+    LEFT JOIN positions USING(positionId)
+    LEFT JOIN items USING(itemId)
+    LEFT JOIN images USING(imageId);
+
+ALTER TABLE items DROP COLUMN quantity;
+ALTER TABLE items DROP COLUMN expiry;
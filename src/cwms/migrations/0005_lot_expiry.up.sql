@@ -0,0 +1,51 @@
+-- Lot and expiry: items carry an expiry date, stored as yyyy-mm-dd, and an expected quantity
+
+ALTER TABLE items ADD COLUMN expiry TEXT;
+ALTER TABLE items ADD COLUMN quantity INTEGER;
+
+-- changed views are dropped and recreated
+DROP VIEW IF EXISTS v_inventory;
+DROP VIEW IF EXISTS v_lotExpiry;
+
+CREATE VIEW v_inventory
+  AS SELECT
+    inventoryId,
+    startTime,
+    stopTime,
+    items.sku AS sku,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    IFNULL(json_extract(positions.json_position, "$.shelf"), "") AS shelf,
+    IFNULL(json_extract(positions.json_position, "$.displayname"), "") AS displayName,
+    items.discrepancy AS discrepancy,
+    images.imageUrl AS imageUrl,
+    positionId,
+    '/images/' || images.hash || '/thumbnail' AS thumbnailUrl,
+    items.gtin AS gtin,
+    items.lot AS lot,
+    items.expiry AS expiry,
+    IFNULL(items.quantity, 0) AS quantity
+  FROM
+    inventory
+    LEFT JOIN positions USING(positionId)
+    LEFT JOIN items USING(itemId)
+    LEFT JOIN images USING(imageId);
+
+-- v_lotExpiry pairs each inventory record with the lot most recently scanned at its position
+CREATE VIEW v_lotExpiry
+  AS SELECT
+    inventoryId,
+    positionId,
+    aisle,
+    block,
+    slot,
+    IFNULL(sku, "") AS sku,
+    IFNULL(lot, "") AS lot,
+    IFNULL(expiry, "") AS expiry,
+    quantity,
+    IFNULL((SELECT flightPositions.lot FROM flightPositions
+      WHERE flightPositions.positionId = v_inventory.positionId AND flightPositions.lot != ""
+      ORDER BY flightPositions.fpId DESC LIMIT 1), "") AS scannedLot
+  FROM
+    v_inventory;
@@ -0,0 +1,40 @@
+-- Drops the observed quantities and the quantity tolerances
+
+DROP VIEW IF EXISTS v_aisleStats;
+DROP VIEW IF EXISTS v_flightList;
+
+CREATE VIEW v_aisleStats
+  AS SELECT
+    aisle,
+    sum(case when discrepancy != "" then 1 else 0 end) as numberException,
+    sum(case when sku = "empty" then 1 else 0 end) as numberEmpty,
+    sum(case when sku != "empty" and sku is not null then 1 else 0 end) as numberOccupied,
+    sum(case when sku is null then 1 else 0 end) as numberUnscanned,
+    max(stopTime) as lastScanned -- TODO: might not be right
+  FROM
+    v_inventory
+  GROUP BY
+    aisle;
+
+CREATE VIEW v_flightList
+  AS SELECT
+    flightId AS flightId,
+    time(time) AS time,
+    flightPositions.sku AS sku,
+    flightPositions.occupancy AS occupancy,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    IFNULL(json_extract(positions.json_position, "$.shelf"), "") AS shelf,
+    IFNULL(flightPositions.barcode, "") AS barcode,
+    IFNULL(flightPositions.gtin, "") AS gtin,
+    IFNULL(flightPositions.lot, "") AS lot,
+    IFNULL(flightPositions.expiry, "") AS expiry,
+    IFNULL(flightPositions.sscc, "") AS sscc
+  FROM
+    flights
+    LEFT JOIN flightPositions USING(flightId)
+    LEFT JOIN positions USING(positionId);
+
+DROP TABLE IF EXISTS quantityTolerances;
+ALTER TABLE flightPositions DROP COLUMN quantity;
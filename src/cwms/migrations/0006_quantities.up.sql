@@ -0,0 +1,55 @@
+-- Quantities: flight scans carry the observed count, count mismatches are raised outside the quantity tolerances
+
+ALTER TABLE flightPositions ADD COLUMN quantity INTEGER;
+-- quantity is the observed number of cases or pallets, null when the drone did not count
+
+CREATE TABLE IF NOT EXISTS quantityTolerances (
+  toleranceId INTEGER PRIMARY KEY AUTOINCREMENT,
+  sku TEXT,
+  aisle TEXT,
+  absolute INTEGER DEFAULT 0,
+  percent REAL DEFAULT 0
+);
+-- A tolerance applies to a sku, to an aisle, or to everything when both are null.
+-- The most specific tolerance wins: sku, then aisle, then the default.
+-- A count mismatch is raised when |observed - expected| exceeds max(absolute, percent% of expected).
+
+-- changed views are dropped and recreated
+DROP VIEW IF EXISTS v_aisleStats;
+DROP VIEW IF EXISTS v_flightList;
+
+CREATE VIEW v_aisleStats
+  AS SELECT
+    aisle,
+    sum(case when discrepancy != "" then 1 else 0 end) as numberException,
+    sum(case when sku = "empty" then 1 else 0 end) as numberEmpty,
+    sum(case when sku != "empty" and sku is not null then 1 else 0 end) as numberOccupied,
+    sum(case when sku is null then 1 else 0 end) as numberUnscanned,
+    sum(case when discrepancy = 'quantity' then 1 else 0 end) as numberQuantityMismatch,
+    sum(quantity) as expectedQuantity,
+    max(stopTime) as lastScanned -- TODO: might not be right
+  FROM
+    v_inventory
+  GROUP BY
+    aisle;
+
+CREATE VIEW v_flightList
+  AS SELECT
+    flightId AS flightId,
+    time(time) AS time,
+    flightPositions.sku AS sku,
+    flightPositions.occupancy AS occupancy,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    IFNULL(json_extract(positions.json_position, "$.shelf"), "") AS shelf,
+    IFNULL(flightPositions.barcode, "") AS barcode,
+    IFNULL(flightPositions.gtin, "") AS gtin,
+    IFNULL(flightPositions.lot, "") AS lot,
+    IFNULL(flightPositions.expiry, "") AS expiry,
+    IFNULL(flightPositions.sscc, "") AS sscc,
+    flightPositions.quantity AS quantity
+  FROM
+    flights
+    LEFT JOIN flightPositions USING(flightId)
+    LEFT JOIN positions USING(positionId);
@@ -0,0 +1,162 @@
+-- Sites: positions, regions, restrictions and flights belong to a site, existing rows to the default site 1
+
+-- Each warehouse served by the backend is a site; site 1 is the default for unprefixed api routes
+CREATE TABLE IF NOT EXISTS sites (
+  siteId INTEGER PRIMARY KEY AUTOINCREMENT,
+  code TEXT UNIQUE NOT NULL,
+  name TEXT
+);
+INSERT OR IGNORE INTO sites (siteId, code, name) VALUES (1, 'default', 'Default');
+
+-- Api users authenticate with a bearer token; access is checked once any user exists
+CREATE TABLE IF NOT EXISTS users (
+  userId INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT UNIQUE NOT NULL,
+  token TEXT UNIQUE NOT NULL
+);
+
+-- role is one of viewer (read), editor (read and write) or admin (also manages permissions)
+CREATE TABLE IF NOT EXISTS siteUsers (
+  siteId INTEGER REFERENCES sites(siteId),
+  userId INTEGER REFERENCES users(userId),
+  role TEXT NOT NULL,
+  PRIMARY KEY (siteId, userId)
+);
+
+ALTER TABLE positions ADD COLUMN siteId INTEGER NOT NULL DEFAULT 1 REFERENCES sites(siteId);
+DROP INDEX IF EXISTS idx_positionSite;
+CREATE INDEX idx_positionSite ON positions (siteId);
+ALTER TABLE regions ADD COLUMN siteId INTEGER NOT NULL DEFAULT 1 REFERENCES sites(siteId);
+ALTER TABLE restrictions ADD COLUMN siteId INTEGER NOT NULL DEFAULT 1 REFERENCES sites(siteId);
+ALTER TABLE flights ADD COLUMN siteId INTEGER NOT NULL DEFAULT 1 REFERENCES sites(siteId);
+
+-- changed views are dropped and recreated
+DROP VIEW IF EXISTS v_inventory;
+DROP VIEW IF EXISTS v_aisleStats;
+DROP VIEW IF EXISTS v_positionMap;
+DROP VIEW IF EXISTS v_lotExpiry;
+DROP VIEW IF EXISTS v_schedule;
+DROP VIEW IF EXISTS v_flightList;
+
+CREATE VIEW v_inventory
+  AS SELECT
+    inventoryId,
+    startTime,
+    stopTime,
+    items.sku AS sku,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    IFNULL(json_extract(positions.json_position, "$.shelf"), "") AS shelf,
+    IFNULL(json_extract(positions.json_position, "$.displayname"), "") AS displayName,
+    items.discrepancy AS discrepancy,
+    images.imageUrl AS imageUrl,
+    positionId,
+    '/images/' || images.hash || '/thumbnail' AS thumbnailUrl,
+    items.gtin AS gtin,
+    items.lot AS lot,
+    items.expiry AS expiry,
+    IFNULL(items.quantity, 0) AS quantity,
+    positions.siteId AS siteId
+  FROM
+    inventory
+    LEFT JOIN positions USING(positionId)
+    LEFT JOIN items USING(itemId)
+    LEFT JOIN images USING(imageId);
+
+CREATE VIEW v_aisleStats
+  AS SELECT
+    siteId,
+    aisle,
+    sum(case when discrepancy != "" then 1 else 0 end) as numberException,
+    sum(case when sku = "empty" then 1 else 0 end) as numberEmpty,
+    sum(case when sku != "empty" and sku is not null then 1 else 0 end) as numberOccupied,
+    sum(case when sku is null then 1 else 0 end) as numberUnscanned,
+    sum(case when discrepancy = 'quantity' then 1 else 0 end) as numberQuantityMismatch,
+    sum(quantity) as expectedQuantity,
+    max(stopTime) as lastScanned -- TODO: might not be right
+  FROM
+    v_inventory
+  GROUP BY
+    siteId, aisle;
+
+-- v_positionMap summarises every position, scanned or not, for the warehouse map
+CREATE VIEW v_positionMap
+  AS SELECT
+    positions.positionId AS positionId,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    sum(case when items.discrepancy != "" then 1 else 0 end) as numberException,
+    sum(case when items.sku = "empty" then 1 else 0 end) as numberEmpty,
+    sum(case when items.sku != "empty" and items.sku is not null then 1 else 0 end) as numberOccupied,
+    IFNULL(max(inventory.stopTime), "") as lastScanned,
+    positions.siteId AS siteId
+  FROM
+    positions
+    LEFT JOIN inventory USING(positionId)
+    LEFT JOIN items USING(itemId)
+  GROUP BY
+    positions.positionId;
+
+-- v_lotExpiry pairs each inventory record with the lot most recently scanned at its position
+CREATE VIEW v_lotExpiry
+  AS SELECT
+    inventoryId,
+    positionId,
+    aisle,
+    block,
+    slot,
+    IFNULL(sku, "") AS sku,
+    IFNULL(lot, "") AS lot,
+    IFNULL(expiry, "") AS expiry,
+    quantity,
+    IFNULL((SELECT flightPositions.lot FROM flightPositions
+      WHERE flightPositions.positionId = v_inventory.positionId AND flightPositions.lot != ""
+      ORDER BY flightPositions.fpId DESC LIMIT 1), "") AS scannedLot,
+    siteId
+  FROM
+    v_inventory;
+
+CREATE VIEW v_schedule
+  AS SELECT
+    entry AS entry,
+    queue AS queue,
+    regions.name AS region,
+    regions.frequency AS frequency,
+    restrictions.name AS restriction,
+    restrictions.startTime AS startTime,
+    restrictions.stopTime AS stopTime,
+    restrictions.periodicity AS periodicity,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    regions.siteId AS siteId
+  FROM
+    events
+    LEFT JOIN regions USING(regionId)
+    LEFT JOIN regionPositions USING(regionId)
+    LEFT JOIN restrictions USING(regionId)
+    LEFT JOIN positions USING(positionId);
+
+CREATE VIEW v_flightList
+  AS SELECT
+    flightId AS flightId,
+    time(time) AS time,
+    flightPositions.sku AS sku,
+    flightPositions.occupancy AS occupancy,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    IFNULL(json_extract(positions.json_position, "$.shelf"), "") AS shelf,
+    IFNULL(flightPositions.barcode, "") AS barcode,
+    IFNULL(flightPositions.gtin, "") AS gtin,
+    IFNULL(flightPositions.lot, "") AS lot,
+    IFNULL(flightPositions.expiry, "") AS expiry,
+    IFNULL(flightPositions.sscc, "") AS sscc,
+    flightPositions.quantity AS quantity,
+    flights.siteId AS siteId
+  FROM
+    flights
+    LEFT JOIN flightPositions USING(flightId)
+    LEFT JOIN positions USING(positionId);
@@ -0,0 +1,30 @@
+-- Drops the fleet and its telemetry
+
+DROP VIEW IF EXISTS v_flightList;
+
+CREATE VIEW v_flightList
+  AS SELECT
+    flightId AS flightId,
+    time(time) AS time,
+    flightPositions.sku AS sku,
+    flightPositions.occupancy AS occupancy,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    IFNULL(json_extract(positions.json_position, "$.shelf"), "") AS shelf,
+    IFNULL(flightPositions.barcode, "") AS barcode,
+    IFNULL(flightPositions.gtin, "") AS gtin,
+    IFNULL(flightPositions.lot, "") AS lot,
+    IFNULL(flightPositions.expiry, "") AS expiry,
+    IFNULL(flightPositions.sscc, "") AS sscc,
+    flightPositions.quantity AS quantity,
+    flights.siteId AS siteId
+  FROM
+    flights
+    LEFT JOIN flightPositions USING(flightId)
+    LEFT JOIN positions USING(positionId);
+
+DROP TABLE IF EXISTS telemetry;
+ALTER TABLE flights DROP COLUMN droneId;
+DROP TABLE IF EXISTS drones;
+ALTER TABLE regions DROP COLUMN requires;
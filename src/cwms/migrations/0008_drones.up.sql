@@ -0,0 +1,59 @@
+-- Drones: a site's fleet with its telemetry, flights are flown by a drone and regions list the capabilities they need
+
+ALTER TABLE regions ADD COLUMN requires text;
+-- requires lists the comma separated drone capabilities needed to scan the region e.g. "barcode,count"
+
+-- Each drone of a site's fleet docks at its home dock between flights
+CREATE TABLE IF NOT EXISTS drones (
+  droneId INTEGER PRIMARY KEY AUTOINCREMENT,
+  name text,
+  capabilities text,
+  homeDock text,
+  status text NOT NULL DEFAULT 'idle',
+  batteryLevel int,
+  lastSeen DATETIME,
+  siteId INTEGER NOT NULL DEFAULT 1 REFERENCES sites(siteId)
+);
+-- capabilities is a comma separated list e.g. "barcode,photo,count"
+-- status is one of idle, charging, flying, maintenance or offline
+
+ALTER TABLE flights ADD COLUMN droneId INTEGER REFERENCES drones(droneId);
+
+-- Telemetry is reported by a drone, during a flight or while docked
+CREATE TABLE IF NOT EXISTS telemetry (
+  telemetryId INTEGER PRIMARY KEY AUTOINCREMENT,
+  droneId INTEGER NOT NULL REFERENCES drones(droneId),
+  flightId INTEGER REFERENCES flights(flightId),
+  time DATETIME,
+  status text,
+  batteryLevel int,
+  aisle text
+);
+DROP INDEX IF EXISTS idx_telemetryDrone;
+CREATE INDEX idx_telemetryDrone ON telemetry (droneId, telemetryId);
+
+-- changed views are dropped and recreated
+DROP VIEW IF EXISTS v_flightList;
+
+CREATE VIEW v_flightList
+  AS SELECT
+    flightId AS flightId,
+    time(time) AS time,
+    flightPositions.sku AS sku,
+    flightPositions.occupancy AS occupancy,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    IFNULL(json_extract(positions.json_position, "$.shelf"), "") AS shelf,
+    IFNULL(flightPositions.barcode, "") AS barcode,
+    IFNULL(flightPositions.gtin, "") AS gtin,
+    IFNULL(flightPositions.lot, "") AS lot,
+    IFNULL(flightPositions.expiry, "") AS expiry,
+    IFNULL(flightPositions.sscc, "") AS sscc,
+    flightPositions.quantity AS quantity,
+    IFNULL(flights.droneId, 0) AS droneId,
+    flights.siteId AS siteId
+  FROM
+    flights
+    LEFT JOIN flightPositions USING(flightId)
+    LEFT JOIN positions USING(positionId);
@@ -0,0 +1,4 @@
+-- Drops the inventory paging indexes
+
+DROP INDEX IF EXISTS idx_inventoryStop;
+DROP INDEX IF EXISTS idx_inventoryPosition;
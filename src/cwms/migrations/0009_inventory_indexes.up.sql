@@ -0,0 +1,6 @@
+-- Inventory paging: index the inventory by position and by scan time
+
+DROP INDEX IF EXISTS idx_inventoryPosition;
+CREATE INDEX idx_inventoryPosition ON inventory (positionId);
+DROP INDEX IF EXISTS idx_inventoryStop;
+CREATE INDEX idx_inventoryStop ON inventory (stopTime);
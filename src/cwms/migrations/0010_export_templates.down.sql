@@ -0,0 +1,3 @@
+-- Drops the export templates
+
+DROP TABLE IF EXISTS exportTemplates;
@@ -0,0 +1,12 @@
+-- Export templates choose the Wms fields (by json name) and header labels of a site's exports
+
+-- fields is a json array e.g. [{"field":"sku","label":"Item"}]
+CREATE TABLE IF NOT EXISTS exportTemplates (
+  templateId INTEGER PRIMARY KEY AUTOINCREMENT,
+  siteId INTEGER NOT NULL REFERENCES sites(siteId),
+  name TEXT NOT NULL,
+  fields TEXT NOT NULL,
+  dateFormat TEXT,
+  delimiter TEXT,
+  UNIQUE (siteId, name)
+);
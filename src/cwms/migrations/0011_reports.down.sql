@@ -0,0 +1,4 @@
+-- Drops the report schedules and their runs
+
+DROP TABLE IF EXISTS reportRuns;
+DROP TABLE IF EXISTS reportSchedules;
@@ -0,0 +1,29 @@
+-- Report schedules deliver an inventory export at the times of a cron expression
+
+-- filters holds export url parameters e.g. "aisle=1a&template=acme"; report is inventory or discrepancy
+-- delivery is folder (destination is a drop directory under the report directory) or smtp (destination is mail addresses)
+CREATE TABLE IF NOT EXISTS reportSchedules (
+  scheduleId INTEGER PRIMARY KEY AUTOINCREMENT,
+  siteId INTEGER NOT NULL REFERENCES sites(siteId),
+  name TEXT NOT NULL,
+  report TEXT NOT NULL DEFAULT 'inventory',
+  format TEXT NOT NULL DEFAULT 'csv',
+  filters TEXT,
+  cron TEXT NOT NULL,
+  delivery TEXT NOT NULL,
+  destination TEXT,
+  enabled INTEGER NOT NULL DEFAULT 1,
+  nextRun TEXT
+);
+
+-- status is running, ok or failed; failed runs are alerts until acknowledged
+CREATE TABLE IF NOT EXISTS reportRuns (
+  runId INTEGER PRIMARY KEY AUTOINCREMENT,
+  scheduleId INTEGER NOT NULL REFERENCES reportSchedules(scheduleId),
+  startTime TEXT NOT NULL,
+  stopTime TEXT,
+  status TEXT NOT NULL,
+  message TEXT,
+  bytes INTEGER,
+  acknowledged INTEGER NOT NULL DEFAULT 0
+);
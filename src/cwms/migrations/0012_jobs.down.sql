@@ -0,0 +1,4 @@
+-- Drops the background jobs and their runs
+
+DROP TABLE IF EXISTS jobRuns;
+DROP TABLE IF EXISTS jobs;
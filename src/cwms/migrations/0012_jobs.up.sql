@@ -0,0 +1,20 @@
+-- Background jobs are registered by the server; the schedule, enabled state and timeout (seconds, 0 for none) are stored here
+
+CREATE TABLE IF NOT EXISTS jobs (
+  name TEXT PRIMARY KEY,
+  cron TEXT NOT NULL,
+  enabled INTEGER NOT NULL DEFAULT 1,
+  timeout INTEGER NOT NULL DEFAULT 0
+);
+
+-- trigger is schedule or manual; status is queued, running, ok, failed, cancelled or interrupted
+CREATE TABLE IF NOT EXISTS jobRuns (
+  runId INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL,
+  trigger TEXT NOT NULL,
+  startTime TEXT NOT NULL,
+  stopTime TEXT,
+  status TEXT NOT NULL,
+  message TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_jobRunsName ON jobRuns (name, runId);
@@ -0,0 +1,6 @@
+-- Drops the summary tables and the indexes maintaining them
+DROP INDEX IF EXISTS idx_regionPositionPosition;
+DROP INDEX IF EXISTS idx_positionAisle;
+DROP INDEX IF EXISTS idx_regionSummarySite;
+DROP TABLE IF EXISTS regionSummaries;
+DROP TABLE IF EXISTS aisleSummaries;
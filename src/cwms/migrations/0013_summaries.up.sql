@@ -0,0 +1,75 @@
+-- Summary tables: the v_aisleStats aggregates kept per aisle and per region
+-- They are updated in the transaction changing the inventory or its discrepancies, cwms rebuild recomputes them.
+
+CREATE TABLE IF NOT EXISTS aisleSummaries (
+  siteId INTEGER NOT NULL REFERENCES sites(siteId),
+  aisle text NOT NULL,
+  numberRecords int NOT NULL,
+  numberException int NOT NULL,
+  numberEmpty int NOT NULL,
+  numberOccupied int NOT NULL,
+  numberUnscanned int NOT NULL,
+  numberQuantityMismatch int NOT NULL,
+  expectedQuantity int NOT NULL,
+  lastScanned text,
+  PRIMARY KEY (siteId, aisle)
+);
+
+CREATE TABLE IF NOT EXISTS regionSummaries (
+  regionId INTEGER PRIMARY KEY REFERENCES regions(regionId),
+  siteId INTEGER NOT NULL REFERENCES sites(siteId),
+  numberRecords int NOT NULL,
+  numberException int NOT NULL,
+  numberEmpty int NOT NULL,
+  numberOccupied int NOT NULL,
+  numberUnscanned int NOT NULL,
+  numberQuantityMismatch int NOT NULL,
+  expectedQuantity int NOT NULL,
+  lastScanned text
+);
+DROP INDEX IF EXISTS idx_regionSummarySite;
+CREATE INDEX idx_regionSummarySite ON regionSummaries (siteId);
+
+-- finding the positions of a changed aisle, and the regions of a changed position, must not scan the tables
+DROP INDEX IF EXISTS idx_positionAisle;
+CREATE INDEX idx_positionAisle ON positions (siteId, IFNULL(json_extract(json_position, '$.aisle'), ''));
+DROP INDEX IF EXISTS idx_regionPositionPosition;
+CREATE INDEX idx_regionPositionPosition ON regionPositions (positionId);
+
+INSERT INTO aisleSummaries
+  SELECT
+    siteId,
+    IFNULL(aisle, ''),
+    count(*),
+    sum(case when discrepancy != "" then 1 else 0 end),
+    sum(case when sku = "empty" then 1 else 0 end),
+    sum(case when sku != "empty" and sku is not null then 1 else 0 end),
+    sum(case when sku is null then 1 else 0 end),
+    sum(case when discrepancy = 'quantity' then 1 else 0 end),
+    sum(quantity),
+    max(stopTime)
+  FROM
+    v_inventory
+  WHERE
+    siteId IS NOT NULL
+  GROUP BY
+    siteId, IFNULL(aisle, '');
+
+INSERT INTO regionSummaries
+  SELECT
+    regionId,
+    regions.siteId,
+    count(*),
+    sum(case when discrepancy != "" then 1 else 0 end),
+    sum(case when sku = "empty" then 1 else 0 end),
+    sum(case when sku != "empty" and sku is not null then 1 else 0 end),
+    sum(case when sku is null then 1 else 0 end),
+    sum(case when discrepancy = 'quantity' then 1 else 0 end),
+    sum(quantity),
+    max(stopTime)
+  FROM
+    v_inventory
+    JOIN regionPositions USING(positionId)
+    JOIN regions USING(regionId)
+  GROUP BY
+    regionId;
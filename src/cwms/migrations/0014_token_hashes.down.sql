@@ -0,0 +1,3 @@
+-- Hashes cannot be reverted to the tokens, users need new tokens after reverting
+
+ALTER TABLE users RENAME COLUMN tokenHash TO token;
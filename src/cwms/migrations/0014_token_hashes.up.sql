@@ -0,0 +1,4 @@
+-- Api tokens are stored as their hex encoded sha256, the sha256 sql function is registered by the server
+
+ALTER TABLE users RENAME COLUMN token TO tokenHash;
+UPDATE users SET tokenHash = sha256(tokenHash);
@@ -0,0 +1,31 @@
+-- Reverts image urls to the bare image path
+
+UPDATE images SET imageUrl = '/images/' || hash WHERE hash IS NOT NULL;
+
+DROP VIEW IF EXISTS v_inventory;
+
+CREATE VIEW v_inventory
+  AS SELECT
+    inventoryId,
+    startTime,
+    stopTime,
+    items.sku AS sku,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    IFNULL(json_extract(positions.json_position, "$.shelf"), "") AS shelf,
+    IFNULL(json_extract(positions.json_position, "$.displayname"), "") AS displayName,
+    items.discrepancy AS discrepancy,
+    images.imageUrl AS imageUrl,
+    positionId,
+    '/images/' || images.hash || '/thumbnail' AS thumbnailUrl,
+    items.gtin AS gtin,
+    items.lot AS lot,
+    items.expiry AS expiry,
+    IFNULL(items.quantity, 0) AS quantity,
+    positions.siteId AS siteId
+  FROM
+    inventory
+    LEFT JOIN positions USING(positionId)
+    LEFT JOIN items USING(itemId)
+    LEFT JOIN images USING(imageId);
@@ -0,0 +1,34 @@
+-- Images are served to the site they were taken at, the urls of images of other than the default site carry the site parameter
+
+UPDATE images SET imageUrl = imageUrl || '?site=' || (
+    SELECT code FROM flightPositions JOIN flights USING(flightId) JOIN sites USING(siteId) WHERE flightPositions.fpId = images.fpId)
+  WHERE hash IS NOT NULL AND fpId IN (SELECT fpId FROM flightPositions JOIN flights USING(flightId) WHERE siteId != 1);
+
+-- the thumbnail url follows the image url
+DROP VIEW IF EXISTS v_inventory;
+
+CREATE VIEW v_inventory
+  AS SELECT
+    inventoryId,
+    startTime,
+    stopTime,
+    items.sku AS sku,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    IFNULL(json_extract(positions.json_position, "$.shelf"), "") AS shelf,
+    IFNULL(json_extract(positions.json_position, "$.displayname"), "") AS displayName,
+    items.discrepancy AS discrepancy,
+    images.imageUrl AS imageUrl,
+    positionId,
+    replace(images.imageUrl, images.hash, images.hash || '/thumbnail') AS thumbnailUrl,
+    items.gtin AS gtin,
+    items.lot AS lot,
+    items.expiry AS expiry,
+    IFNULL(items.quantity, 0) AS quantity,
+    positions.siteId AS siteId
+  FROM
+    inventory
+    LEFT JOIN positions USING(positionId)
+    LEFT JOIN items USING(itemId)
+    LEFT JOIN images USING(imageId);
@@ -0,0 +1,3 @@
+-- Drops the import time of inventory records
+
+ALTER TABLE inventory DROP COLUMN importedTime;
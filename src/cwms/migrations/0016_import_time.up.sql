@@ -0,0 +1,4 @@
+-- Imported records replace the item at their position, the import time is kept apart from the scan times,
+-- startTime and stopTime are only set by a scan or by an imported record that carries them
+
+ALTER TABLE inventory ADD COLUMN importedTime DATETIME;
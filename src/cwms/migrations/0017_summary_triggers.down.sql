@@ -0,0 +1,9 @@
+-- Drops the triggers refreshing summaries on region membership and position edits
+
+DROP TRIGGER IF EXISTS t_positionDelete;
+DROP TRIGGER IF EXISTS t_positionUpdate;
+DROP TRIGGER IF EXISTS t_regionPositionUpdate;
+DROP TRIGGER IF EXISTS t_regionPositionDelete;
+DROP TRIGGER IF EXISTS t_regionPositionInsert;
+DROP VIEW IF EXISTS v_regionSummary;
+DROP VIEW IF EXISTS v_aisleSummary;
@@ -0,0 +1,88 @@
+-- Region membership and position edits refresh the summaries they change, whichever path writes them
+-- v_aisleSummary and v_regionSummary compute summary rows as cwms rebuild does, the triggers replace the affected rows.
+
+DROP VIEW IF EXISTS v_aisleSummary;
+CREATE VIEW v_aisleSummary
+  AS SELECT
+    siteId,
+    IFNULL(aisle, '') AS aisle,
+    count(*) AS numberRecords,
+    sum(case when discrepancy != "" then 1 else 0 end) AS numberException,
+    sum(case when sku = "empty" then 1 else 0 end) AS numberEmpty,
+    sum(case when sku != "empty" and sku is not null then 1 else 0 end) AS numberOccupied,
+    sum(case when sku is null then 1 else 0 end) AS numberUnscanned,
+    sum(case when discrepancy = 'quantity' then 1 else 0 end) AS numberQuantityMismatch,
+    sum(quantity) AS expectedQuantity,
+    max(stopTime) AS lastScanned
+  FROM
+    v_inventory
+  WHERE
+    siteId IS NOT NULL
+  GROUP BY
+    siteId, IFNULL(aisle, '');
+
+DROP VIEW IF EXISTS v_regionSummary;
+CREATE VIEW v_regionSummary
+  AS SELECT
+    regionId,
+    regions.siteId AS siteId,
+    count(*) AS numberRecords,
+    sum(case when discrepancy != "" then 1 else 0 end) AS numberException,
+    sum(case when sku = "empty" then 1 else 0 end) AS numberEmpty,
+    sum(case when sku != "empty" and sku is not null then 1 else 0 end) AS numberOccupied,
+    sum(case when sku is null then 1 else 0 end) AS numberUnscanned,
+    sum(case when discrepancy = 'quantity' then 1 else 0 end) AS numberQuantityMismatch,
+    sum(quantity) AS expectedQuantity,
+    max(stopTime) AS lastScanned
+  FROM
+    v_inventory
+    JOIN regionPositions USING(positionId)
+    JOIN regions USING(regionId)
+  GROUP BY
+    regionId;
+
+-- a region left without positions has no summary, as after cwms rebuild
+DROP TRIGGER IF EXISTS t_regionPositionInsert;
+CREATE TRIGGER t_regionPositionInsert AFTER INSERT ON regionPositions
+BEGIN
+  DELETE FROM regionSummaries WHERE regionId = NEW.regionId;
+  INSERT INTO regionSummaries SELECT * FROM v_regionSummary WHERE regionId = NEW.regionId;
+END;
+
+DROP TRIGGER IF EXISTS t_regionPositionDelete;
+CREATE TRIGGER t_regionPositionDelete AFTER DELETE ON regionPositions
+BEGIN
+  DELETE FROM regionSummaries WHERE regionId = OLD.regionId;
+  INSERT INTO regionSummaries SELECT * FROM v_regionSummary WHERE regionId = OLD.regionId;
+END;
+
+DROP TRIGGER IF EXISTS t_regionPositionUpdate;
+CREATE TRIGGER t_regionPositionUpdate AFTER UPDATE ON regionPositions
+BEGIN
+  DELETE FROM regionSummaries WHERE regionId IN (OLD.regionId, NEW.regionId);
+  INSERT INTO regionSummaries SELECT * FROM v_regionSummary WHERE regionId IN (OLD.regionId, NEW.regionId);
+END;
+
+-- a position moved to another aisle or site changes the summaries of both aisles and of its regions
+DROP TRIGGER IF EXISTS t_positionUpdate;
+CREATE TRIGGER t_positionUpdate AFTER UPDATE OF json_position, siteId ON positions
+BEGIN
+  DELETE FROM aisleSummaries WHERE (siteId, aisle) IN (VALUES
+    (OLD.siteId, IFNULL(json_extract(OLD.json_position, '$.aisle'), '')), (NEW.siteId, IFNULL(json_extract(NEW.json_position, '$.aisle'), '')));
+  INSERT INTO aisleSummaries SELECT * FROM v_aisleSummary WHERE (siteId, aisle) IN (VALUES
+    (OLD.siteId, IFNULL(json_extract(OLD.json_position, '$.aisle'), '')), (NEW.siteId, IFNULL(json_extract(NEW.json_position, '$.aisle'), '')));
+  DELETE FROM regionSummaries WHERE regionId IN (SELECT regionId FROM regionPositions WHERE positionId = NEW.positionId);
+  INSERT INTO regionSummaries SELECT * FROM v_regionSummary
+    WHERE regionId IN (SELECT regionId FROM regionPositions WHERE positionId = NEW.positionId);
+END;
+
+DROP TRIGGER IF EXISTS t_positionDelete;
+CREATE TRIGGER t_positionDelete AFTER DELETE ON positions
+BEGIN
+  DELETE FROM aisleSummaries WHERE siteId = OLD.siteId AND aisle = IFNULL(json_extract(OLD.json_position, '$.aisle'), '');
+  INSERT INTO aisleSummaries SELECT * FROM v_aisleSummary
+    WHERE siteId = OLD.siteId AND aisle = IFNULL(json_extract(OLD.json_position, '$.aisle'), '');
+  DELETE FROM regionSummaries WHERE regionId IN (SELECT regionId FROM regionPositions WHERE positionId = OLD.positionId);
+  INSERT INTO regionSummaries SELECT * FROM v_regionSummary
+    WHERE regionId IN (SELECT regionId FROM regionPositions WHERE positionId = OLD.positionId);
+END;
@@ -0,0 +1,4 @@
+-- Makes quantity tolerances global again
+
+DROP INDEX IF EXISTS idx_toleranceSite;
+ALTER TABLE quantityTolerances DROP COLUMN siteId;
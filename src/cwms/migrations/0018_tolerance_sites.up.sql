@@ -0,0 +1,5 @@
+-- Quantity tolerances belong to a site, existing tolerances to the default site 1
+
+ALTER TABLE quantityTolerances ADD COLUMN siteId INTEGER NOT NULL DEFAULT 1 REFERENCES sites(siteId);
+DROP INDEX IF EXISTS idx_toleranceSite;
+CREATE INDEX idx_toleranceSite ON quantityTolerances (siteId);
@@ -1,48 +1,62 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // MissionControls holds page navigation and mission control fields
 type MissionControls struct {
-	Curr, Next, Prev       string   // Mission Nav
-	SingleDay              bool     // Has a single day been selected?
-	Selection              string   // selection choice "all" or a specific date
-	Scope                  string   // filter scope: blank or "issues"
-	Days                   []string // list of days
-	CurrentStatus          string   // Charging, Waiting, In Flight
-	BatteryLevel           string   // Battery charge level
-	TimeUntilNextFlight    string   // Time until next flight (when battery is charged)
-	LastCompleteInventory  string   //
-	DaysLeftInCurrentCycle string   //
-	AveDaysToCompleteCycle string   //
+	Curr, Next, Prev       string        // Mission Nav
+	SingleDay              bool          // Has a single day been selected?
+	Selection              string        // selection choice "all" or a specific date
+	Scope                  string        // filter scope: blank or "issues"
+	Days                   []string      // list of days
+	SiteId                 int           // site the missions belong to
+	Fleet                  []DroneStatus // drones of the site and their next planned mission
+	LastCompleteInventory  string        //
+	DaysLeftInCurrentCycle string        //
+	AveDaysToCompleteCycle string        //
+}
+
+// DroneStatus is a drone of the fleet with the start of its next planned mission
+type DroneStatus struct {
+	Drone
+	NextFlight string // Start of the next planned mission, blank when none is planned
 }
 
 // toSqlStmt generates a sql statement based on the current set of page controls
 func (mc MissionControls) toSqlStmt() (sqlstmt string) {
 	var sel, order string
 	sel = `select entry, region, frequency, IFNULL(aisle, ""), IFNULL(block,""), IFNULL(slot,"") from v_schedule `
+	where := fmt.Sprintf(`where siteId = '%v'`, mc.SiteId)
 	order = `order by entry`
-	sqlstmt = fmt.Sprintf("%s %s", sel, order)
+	sqlstmt = fmt.Sprintf("%s %s %s", sel, where, order)
 	return
 }
 
-// missionControls generates a set of mission nav and mission controls based on day and scope
-func missionControls(day, scope string) (mc MissionControls, err error) {
+// missionControls generates a set of mission nav and mission controls for a site based on day and scope
+func missionControls(siteId int, day, scope string) (mc MissionControls, err error) {
 	var days []string
-	days, err = fetchDays(scope)
+	days, err = fetchDays(siteId, scope)
 	if err != nil {
 		return
 	}
+	if len(days) == 0 {
+		mc.SiteId = siteId
+		return
+	}
 	if day == "" {
 		day = days[0]
 	}
 	mc = missionNav(day, days)
+	mc.SiteId = siteId
 	mc.Scope = scope
 	mc.Selection = day
 	mc.SingleDay = day != "all"
-	mc.BatteryLevel = "50%"
-	mc.CurrentStatus = "Charging"
-	mc.TimeUntilNextFlight = "15 minutes"
+	if mc.Fleet, err = fetchFleetStatus(siteId); err != nil {
+		return
+	}
 	mc.LastCompleteInventory = "April 15, 2020"
 	mc.DaysLeftInCurrentCycle = "20 days"
 	mc.AveDaysToCompleteCycle = "45 day"
@@ -62,3 +76,26 @@ func missionNav(curr string, dl []string) (mc MissionControls) {
 	}
 	return
 }
+
+// fetchFleetStatus returns the drones of a site with the start of each drone's next planned mission
+func fetchFleetStatus(siteId int) (fl []DroneStatus, err error) {
+	var dl DroneList
+	if dl, err = FetchDrones(siteId); err != nil {
+		return
+	}
+	var ql QueueList
+	if ql, err = FetchQueueList(siteId, time.Now().UTC()); err != nil {
+		return
+	}
+	for _, d := range dl {
+		ds := DroneStatus{Drone: d}
+		for _, q := range ql {
+			if q.DroneId == d.Id {
+				ds.NextFlight = q.StartTime
+				break
+			}
+		}
+		fl = append(fl, ds)
+	}
+	return
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiFormats are the content types of the formats api responses are available in
+var apiFormats = map[string]string{
+	"json": "application/json",
+	"xml":  "application/xml",
+	"csv":  "text/csv; charset=utf-8",
+}
+
+// acceptFormats maps the media ranges of an Accept header to api formats
+var acceptFormats = map[string]string{
+	"application/json": "json",
+	"application/xml":  "xml",
+	"text/xml":         "xml",
+	"text/csv":         "csv",
+	"application/*":    "json",
+	"*/*":              "json",
+}
+
+// negotiateFormat picks the api format of a response: the ?format= override, else the most preferred
+// acceptable media type of the Accept header, json when there is no Accept header.
+func negotiateFormat(r *http.Request) (string, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		if _, ok := apiFormats[f]; !ok {
+			return "", errBadRequest("format must be json, xml or csv")
+		}
+		return f, nil
+	}
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return "json", nil
+	}
+
+	format, best := "", 0.0
+	for _, mr := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(mr)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		if f, ok := acceptFormats[mt]; ok && q > best {
+			format, best = f, q
+		}
+	}
+	if format == "" {
+		return "", errNotAcceptable("available as application/json, application/xml or text/csv")
+	}
+	return format, nil
+}
+
+// encodeApiData writes api response data as xml or csv
+// Inventory is written with the export columns of the /export/ routes, other data by reflection on its struct fields.
+func encodeApiData(out io.Writer, format string, data interface{}) error {
+	switch d := data.(type) {
+	case nil:
+		return nil
+	case WmsList:
+		if format == "csv" {
+			return encodeCsv(out, defaultLayout(), listSource(d))
+		}
+		return encodeXml(out, defaultLayout(), listSource(d))
+	case InventoryPage:
+		if format == "csv" {
+			return encodeCsv(out, defaultLayout(), listSource(d.Items))
+		}
+	}
+	if format == "csv" {
+		return encodeCsvData(out, data)
+	}
+	return encodeXmlData(out, data)
+}
+
+// encodeXmlData writes data as an xml document, a slice within an element named for its type
+func encodeXmlData(out io.Writer, data interface{}) (err error) {
+	enc := xml.NewEncoder(out)
+	enc.Indent(" ", "   ")
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Slice {
+		// encode an addressable copy so fields use their pointer marshallers
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		if err = enc.Encode(p.Interface()); err != nil {
+			return
+		}
+		return enc.Flush()
+	}
+
+	name := v.Type().Name()
+	if name == "" {
+		name = schemaName(v.Type().Elem()) + "List"
+	}
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if err = enc.EncodeToken(start); err != nil {
+		return
+	}
+	for i := 0; i < v.Len(); i++ {
+		if err = enc.Encode(v.Index(i).Addr().Interface()); err != nil {
+			return
+		}
+	}
+	if err = enc.EncodeToken(start.End()); err != nil {
+		return
+	}
+	return enc.Flush()
+}
+
+// csvField is a column of the csv encoding of a struct, the index path of its field
+type csvField struct {
+	name  string
+	index []int
+}
+
+// csvFields returns the columns of a struct type, named by csv tag, else json name
+// Fields tagged "-" are skipped and embedded structs are flattened.
+func csvFields(t reflect.Type, parent []int) (fl []csvField) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		index := append(append([]int{}, parent...), i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("json") == "" {
+			fl = append(fl, csvFields(f.Type, index)...)
+			continue
+		}
+		name := strings.Split(f.Tag.Get("csv"), ",")[0]
+		if name == "" {
+			name = strings.Split(f.Tag.Get("json"), ",")[0]
+		}
+		if f.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fl = append(fl, csvField{name, index})
+	}
+	return
+}
+
+// csvText formats a field value as a csv cell, values that are not scalars as json
+func csvText(v reflect.Value) (string, error) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+	switch x := v.Interface().(type) {
+	case time.Time:
+		return x.Format(time.RFC3339Nano), nil
+	case NullString:
+		return x.String, nil
+	}
+	switch v.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v.Interface()), nil
+	}
+	if v.CanAddr() {
+		v = v.Addr()
+	}
+	b, err := json.Marshal(v.Interface())
+	return string(b), err
+}
+
+// encodeCsvData writes a struct, or a slice of structs, as csv with a header row of its field names
+func encodeCsvData(out io.Writer, data interface{}) (err error) {
+	v := reflect.ValueOf(data)
+	t := v.Type()
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	} else {
+		v = reflect.Append(reflect.MakeSlice(reflect.SliceOf(t), 0, 1), v)
+	}
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("%v has no csv columns", t)
+	}
+
+	fl := csvFields(t, nil)
+	writer := csv.NewWriter(out)
+	row := make([]string, len(fl))
+	for i, f := range fl {
+		row[i] = f.name
+	}
+	if err = writer.Write(row); err != nil {
+		return
+	}
+	for i := 0; i < v.Len(); i++ {
+		for j, f := range fl {
+			if row[j], err = csvText(v.Index(i).FieldByIndex(f.index)); err != nil {
+				return
+			}
+		}
+		if err = writer.Write(row); err != nil {
+			return
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
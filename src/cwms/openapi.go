@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// openApiSchemas generates the component schemas of the json types the api routes document
+type openApiSchemas map[string]interface{}
+
+// ref returns the schema of a Go type, adding named struct types to the components and referencing them
+func (sc openApiSchemas) ref(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(NullString{}):
+		return map[string]interface{}{"type": "string", "nullable": true}
+	case reflect.TypeOf(json.RawMessage{}):
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": sc.ref(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": sc.ref(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return sc.object(t)
+		}
+		name := schemaName(t)
+		if _, ok := sc[name]; !ok {
+			sc[name] = nil // placeholder for recursive types
+			sc[name] = sc.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// object returns the object schema of a struct type from its json field names
+func (sc openApiSchemas) object(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	var required []string
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if f.Anonymous && tag == "" {
+				addFields(f.Type)
+				continue
+			}
+			if f.PkgPath != "" || tag == "-" {
+				continue
+			}
+			sl := strings.Split(tag, ",")
+			name := sl[0]
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = sc.ref(f.Type)
+			if len(sl) == 1 && f.Type.Kind() != reflect.Ptr {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+	schema := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// upperFirst returns s with its first letter in upper case
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// schemaName is the component name of a struct type, exported
+func schemaName(t reflect.Type) string {
+	return upperFirst(t.Name())
+}
+
+// content returns the media type map of a request or response body
+// A string names a content type without a json schema.
+func (sc openApiSchemas) content(body interface{}) map[string]interface{} {
+	if ct, ok := body.(string); ok {
+		return map[string]interface{}{ct: map[string]interface{}{}}
+	}
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": sc.ref(reflect.TypeOf(body))}}
+}
+
+// operationId derives an operation id from the method and pattern e.g. getDronesByIdTelemetry
+func operationId(method, pattern string) string {
+	id := strings.ToLower(method)
+	for _, s := range splitPath(pattern) {
+		if strings.HasPrefix(s, "{") {
+			s = "By" + upperFirst(strings.Trim(s, "{}"))
+		}
+		for _, w := range strings.FieldsFunc(s, func(r rune) bool { return r == '.' || r == '-' }) {
+			id += upperFirst(w)
+		}
+	}
+	return id
+}
+
+// successStatus is the status a route responds with, following the method as in jsonApi unless the route sets it
+func successStatus(rt apiRoute) int {
+	switch {
+	case rt.Status != 0:
+		return rt.Status
+	case rt.Method == http.MethodPost:
+		return http.StatusCreated
+	case rt.Method == http.MethodDelete:
+		return http.StatusNoContent
+	}
+	return http.StatusOK
+}
+
+// openApiDocument generates the OpenAPI 3 document of the /api/v1 routes
+func openApiDocument(routes []apiRoute) map[string]interface{} {
+	schemas := openApiSchemas{"Problem": openApiSchemas{}.object(reflect.TypeOf(problem{}))}
+	problemContent := map[string]interface{}{"application/problem+json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/Problem"}}}
+
+	paths := make(map[string]map[string]interface{})
+	for _, rt := range routes {
+		var params []interface{}
+		for _, s := range splitPath(rt.Pattern) {
+			if !strings.HasPrefix(s, "{") {
+				continue
+			}
+			name := strings.Trim(s, "{}")
+			schema := map[string]interface{}{"type": "string"}
+			if name == "id" || strings.HasSuffix(name, "Id") {
+				schema["type"] = "integer"
+			}
+			params = append(params, map[string]interface{}{"name": name, "in": "path", "required": true, "schema": schema})
+		}
+		query := rt.Query
+		if _, ok := rt.Response.(string); rt.Response != nil && !ok {
+			query = append(query, apiParam{"format", "json, xml or csv, overrides the Accept header"})
+		}
+		for _, q := range query {
+			params = append(params, map[string]interface{}{"name": q.Name, "in": "query", "description": q.Description, "schema": map[string]interface{}{"type": "string"}})
+		}
+
+		status := successStatus(rt)
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		if rt.Response != nil && status != http.StatusNoContent {
+			content := schemas.content(rt.Response)
+			if _, ok := rt.Response.(string); !ok {
+				content["application/xml"] = map[string]interface{}{}
+				content["text/csv"] = map[string]interface{}{}
+			}
+			success["content"] = content
+		}
+		op := map[string]interface{}{
+			"operationId": operationId(rt.Method, rt.Pattern),
+			"summary":     rt.Summary,
+			"tags":        []string{rt.Tag},
+			"responses": map[string]interface{}{
+				strconv.Itoa(status): success,
+				"default":            map[string]interface{}{"description": "Problem", "content": problemContent},
+			},
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		if rt.Body != nil {
+			op["requestBody"] = map[string]interface{}{"required": true, "content": schemas.content(rt.Body)}
+		}
+		if rt.Scoped {
+			op["description"] = "Also served under /sites/{site}" + rt.Pattern + " for a site other than the default."
+		}
+
+		if paths[rt.Pattern] == nil {
+			paths[rt.Pattern] = make(map[string]interface{})
+		}
+		paths[rt.Pattern][strings.ToLower(rt.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Corvus WMS API",
+			"version": "1",
+			"description": "Drone inventory scans reconciled against the warehouse management system. " +
+				"Responses are json, xml or csv by the Accept header or ?format=. Errors are RFC 7807 problem documents.",
+		},
+		"servers":  []interface{}{map[string]interface{}{"url": "/api/v1"}},
+		"security": []interface{}{map[string]interface{}{"bearer": []string{}}},
+		"paths":    paths,
+		"components": map[string]interface{}{
+			"schemas":         schemas,
+			"securitySchemes": map[string]interface{}{"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"}},
+		},
+	}
+}
+
+// handleOpenApi returns the endpoint serving the OpenAPI document of the routes
+// accepts:
+//
+//	GET /api/v1/openapi.json
+func handleOpenApi(routes []apiRoute) http.HandlerFunc {
+	doc, err := json.MarshalIndent(openApiDocument(routes), "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Write(doc)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRoutesResolve(t *testing.T) {
+	routes := apiRoutes()
+	for _, ar := range []*apiRouter{newApiRouter("/api/v1", false, routes), newApiRouter("/api", true, routes)} {
+		for _, e := range ar.entries {
+			if e.route.Handler == nil {
+				t.Errorf("%s %s%s has no handler", e.route.Method, ar.prefix, e.pattern)
+				continue
+			}
+			// path parameters are never literal segments of another pattern
+			segs := splitPath(e.pattern)
+			for i, s := range segs {
+				if strings.HasPrefix(s, "{") {
+					segs[i] = "0"
+				}
+			}
+			if routed := routeOf(ar, segs, false, e.route.Method); routed != e.route {
+				t.Errorf("%s %s%s is routed to %s", e.route.Method, ar.prefix, e.pattern, describeRoute(routed))
+			}
+			if e.route.Scoped {
+				if routed := routeOf(ar, segs, true, e.route.Method); routed != e.route {
+					t.Errorf("%s %s/sites/{site}%s is routed to %s", e.route.Method, ar.prefix, e.pattern, describeRoute(routed))
+				}
+			}
+		}
+	}
+}
+
+// routeOf returns the route the router serves a method on path segments with
+func routeOf(ar *apiRouter, segs []string, scoped bool, method string) *apiRoute {
+	el, _ := ar.match(segs, scoped)
+	for _, e := range el {
+		if e.route.Method == method {
+			return e.route
+		}
+	}
+	return nil
+}
+
+// describeRoute names a route in test failures
+func describeRoute(rt *apiRoute) string {
+	if rt == nil {
+		return "no route"
+	}
+	return rt.Method + " " + rt.Pattern
+}
+
+func TestOpenApiDocument(t *testing.T) {
+	routes := apiRoutes()
+	w := httptest.NewRecorder()
+	routes[len(routes)-1].Handler(w, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	var doc struct {
+		OpenApi    string                                       `json:"openapi"`
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("openapi.json does not parse: %v", err)
+	}
+	if doc.OpenApi != "3.0.3" {
+		t.Errorf("openapi version %q", doc.OpenApi)
+	}
+
+	// the document route itself is added after the document is generated
+	operationIds := make(map[string]string)
+	for _, rt := range routes[:len(routes)-1] {
+		op, ok := doc.Paths[rt.Pattern][strings.ToLower(rt.Method)]
+		if !ok {
+			t.Errorf("%s %s is not documented", rt.Method, rt.Pattern)
+			continue
+		}
+		id, _ := op["operationId"].(string)
+		if other, ok := operationIds[id]; ok {
+			t.Errorf("%s %s and %s share the operation id %q", rt.Method, rt.Pattern, other, id)
+		}
+		operationIds[id] = rt.Method + " " + rt.Pattern
+	}
+
+	// every schema reference resolves to a component
+	var refs func(v interface{})
+	refs = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				if _, ok := doc.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")]; !ok {
+					t.Errorf("reference %q has no component schema", ref)
+				}
+			}
+			for _, e := range v {
+				refs(e)
+			}
+		case []interface{}:
+			for _, e := range v {
+				refs(e)
+			}
+		}
+	}
+	var raw map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &raw)
+	refs(raw)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// pageData loads the data of a dashboard page on first use and keeps it for the rest of the request
+// Handlers ask only for what they render, so a page showing the statistics does not load the inventory.
+type pageData struct {
+	r      *http.Request
+	siteId int
+
+	pc    *PageControls
+	wl    WmsList
+	wlOk  bool
+	stats *Stats
+}
+
+// newPageData returns the page data of a request, nothing is loaded until asked for
+func newPageData(r *http.Request) *pageData {
+	return &pageData{r: r, siteId: requestSite(r).Id}
+}
+
+// PageControls returns the page navigation of the aisle and scope url parameters
+func (pd *pageData) PageControls() (pc PageControls, err error) {
+	if pd.pc == nil {
+		urlParams := pd.r.URL.Query()
+		if pc, err = pageControls(pd.siteId, urlParams.Get("aisle"), urlParams.Get("scope")); err != nil {
+			return
+		}
+		pd.pc = &pc
+	}
+	return *pd.pc, nil
+}
+
+// Inventory returns the inventory selected by the page controls
+func (pd *pageData) Inventory() (wl WmsList, err error) {
+	if !pd.wlOk {
+		var pc PageControls
+		if pc, err = pd.PageControls(); err != nil {
+			return
+		}
+		af := pc.toAisleFilter()
+		af.SiteId = pd.siteId
+		if pd.wl, err = FetchInventory(af); err != nil {
+			return
+		}
+		pd.wlOk = true
+	}
+	return pd.wl, nil
+}
+
+// Stats returns the inventory statistics of the site
+func (pd *pageData) Stats() (stats Stats, err error) {
+	if pd.stats == nil {
+		if stats, err = fetchStats(pd.siteId); err != nil {
+			return
+		}
+		pd.stats = &stats
+	}
+	return *pd.stats, nil
+}
+
+// pageFail logs a page error and responds with a plain internal server error
+func pageFail(w http.ResponseWriter, err error) {
+	log.Println(err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
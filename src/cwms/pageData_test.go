@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// benchmarkSlots is the size of the synthetic warehouse the page benchmarks render
+const benchmarkSlots = 100000
+
+// seedBenchmarkDb opens a database holding a synthetic warehouse of the default site
+// The warehouse has 100 aisles of 10 blocks of 100 slots, each slot holding one scanned item, one in fifty with a discrepancy.
+func seedBenchmarkDb(b *testing.B, slots int) {
+	b.Helper()
+	openTestDb(b)
+	if _, err := MigrateUp(0); err != nil {
+		b.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`with recursive n(i) as (select 1 union all select i + 1 from n where i < ?)
+		insert into positions (positionId, json_position, siteId)
+		select i, json_object('aisle', printf('%da', (i - 1) / 1000 + 1), 'block', printf('%d', (i - 1) / 100 % 10 + 1), 'slot', printf('%d', (i - 1) % 100 + 1)), 1 from n`,
+		`with recursive n(i) as (select 1 union all select i + 1 from n where i < ?)
+		insert into items (itemId, sku, discrepancy, quantity)
+		select i, printf('SKU%06d', i), case when i % 50 = 0 then 'missing' else '' end, 1 from n`,
+		`with recursive n(i) as (select 1 union all select i + 1 from n where i < ?)
+		insert into inventory (startTime, stopTime, itemId, positionId)
+		select '2020-04-04 19:22:45', '2020-04-04 19:24:45', i, i from n`,
+	} {
+		if _, err := db.Exec(stmt, slots); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := RebuildSummaries(); err != nil {
+		b.Fatal(err)
+	}
+	var err error
+	if pages, err = parsePages(assetFiles); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// renderAllData renders a page the way the imw middleware did before page data,
+// loading the page controls, the inventory they select and the statistics whatever the page shows
+func renderAllData(page string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		urlParams := r.URL.Query()
+		siteId := requestSite(r).Id
+		pc, err := pageControls(siteId, urlParams.Get("aisle"), urlParams.Get("scope"))
+		if err != nil {
+			pageFail(w, err)
+			return
+		}
+		af := pc.toAisleFilter()
+		af.SiteId = siteId
+		wl, err := FetchInventory(af)
+		if err != nil {
+			pageFail(w, err)
+			return
+		}
+		stats, err := fetchStats(siteId)
+		if err != nil {
+			pageFail(w, err)
+			return
+		}
+		tm := map[string]interface{}{"PageControls": pc, "Inventory": wl, "Stats": stats, "MapMode": urlParams.Get("map")}
+		if err = executeTemplate(page, tm, w); err != nil {
+			pageFail(w, err)
+		}
+	}
+}
+
+func BenchmarkPages(b *testing.B) {
+	seedBenchmarkDb(b, benchmarkSlots)
+	for _, page := range []struct {
+		name, url, template string
+		handler             http.HandlerFunc
+	}{
+		{"dashboard", "/dashboard/", "dashboard.html", handleDashboard},
+		{"inventory", "/inventory/?aisle=all", "inventory.html", handleInventory},
+		{"hybrid", "/?aisle=50a", "hybrid.html", handleHybrid},
+	} {
+		for _, render := range []struct {
+			name    string
+			handler http.HandlerFunc
+		}{
+			{"allData", renderAllData(page.template)},
+			{"pageData", page.handler},
+		} {
+			b.Run(page.name+"/"+render.name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					w := httptest.NewRecorder()
+					render.handler(w, httptest.NewRequest(http.MethodGet, page.url, nil))
+					if w.Code != http.StatusOK {
+						b.Fatalf("GET %s: status %d", page.url, w.Code)
+					}
+				}
+			})
+		}
+	}
+}
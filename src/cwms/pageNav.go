@@ -19,13 +19,18 @@ func (pc PageControls) toAisleFilter() (af AisleFilter) {
 	return
 }
 
-// pageControls generates a set of page nav and page controls based on aisle and scope
-func pageControls(aisle, scope string) (pc PageControls, err error) {
+// pageControls generates a set of page nav and page controls based on site, aisle and scope
+func pageControls(siteId int, aisle, scope string) (pc PageControls, err error) {
 	var aisles []string
-	aisles, err = fetchAisles(scope)
+	aisles, err = fetchAisles(siteId, scope)
 	if err != nil {
 		return
 	}
+	if len(aisles) == 0 {
+		// a site without inventory has nothing to navigate
+		pc.Scope = scope
+		return
+	}
 	if aisle == "" {
 		aisle = aisles[0]
 	}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"cwms/api"
+)
+
+// positionScan is a flight scan of a position as used by reconciliation
+type positionScan struct {
+	FpId       int
+	PositionId int
+	Aisle      string
+	Sku        string
+	Gtin       string
+	Lot        string
+	Quantity   sql.NullInt64 // observed, null when not counted
+}
+
+// wmsItem is the warehouse management system item held at a position
+type wmsItem struct {
+	ItemId   int
+	Sku      string
+	Gtin     string
+	Lot      string
+	Quantity int // expected, 0 when not tracked
+}
+
+// ReconcileResult summarises a reconciliation run
+type ReconcileResult = api.ReconcileResult
+
+// isEmpty reports whether the scan saw an empty slot
+func (ps positionScan) isEmpty() bool {
+	return ps.Sku == "empty" || (ps.Sku == "" && ps.Gtin == "")
+}
+
+// scanDiscrepancy compares a scan with the item the warehouse expects at the position
+// Items are matched on GTIN, and lot when both sides carry one, falling back to the exact sku.
+// Matching items whose counted quantity falls outside the tolerance are a quantity mismatch.
+func scanDiscrepancy(it wmsItem, ps positionScan, tl ToleranceList) string {
+	if d := identityDiscrepancy(it, ps); d != "" {
+		return d
+	}
+	if it.Sku != "empty" && it.Quantity > 0 && ps.Quantity.Valid &&
+		!tl.lookup(it.Sku, ps.Aisle).allows(it.Quantity, int(ps.Quantity.Int64)) {
+		return "quantity"
+	}
+	return ""
+}
+
+// identityDiscrepancy compares what was scanned at a position with the item the warehouse expects
+func identityDiscrepancy(it wmsItem, ps positionScan) string {
+	switch {
+	case it.Sku == "empty" && ps.isEmpty():
+		return ""
+	case ps.isEmpty():
+		return "missing"
+	case it.Sku == "empty":
+		return "unexpected"
+	case it.Gtin != "" && ps.Gtin != "":
+		if normalizeGTIN(it.Gtin) != normalizeGTIN(ps.Gtin) {
+			return "mismatch"
+		}
+		if it.Lot != "" && ps.Lot != "" && !strings.EqualFold(it.Lot, ps.Lot) {
+			return "lot"
+		}
+		return ""
+	case it.Sku == ps.Sku:
+		return ""
+	default:
+		return "mismatch"
+	}
+}
+
+// fetchPositionScans performs a query on flightPositions and returns the scans of a flight
+func fetchPositionScans(flightId int) (psl []positionScan, err error) {
+	var rows *sql.Rows
+	if rows, err = db.Query(`select fpId, positionId, IFNULL(json_extract(json_position, '$.aisle'), ""), IFNULL(sku, ""), IFNULL(gtin, ""), IFNULL(lot, ""), quantity from flightPositions join positions using(positionId) where flightId = ? order by fpId`, flightId); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var ps positionScan
+	for rows.Next() {
+		if err = rows.Scan(StructForScan(&ps)...); err != nil {
+			return
+		}
+		psl = append(psl, ps)
+	}
+	err = rows.Err()
+	return
+}
+
+// fetchPositionItem returns the item most recently recorded at a position by the warehouse inventory
+func fetchPositionItem(tx *sql.Tx, positionId int) (it wmsItem, err error) {
+	err = tx.QueryRow(`select itemId, IFNULL(sku, ""), IFNULL(gtin, ""), IFNULL(lot, ""), IFNULL(quantity, 0) from inventory join items using(itemId) where positionId = ? order by inventoryId desc limit 1`, positionId).
+		Scan(StructForScan(&it)...)
+	return
+}
+
+// ReconcileFlight compares each position scanned on a flight with the warehouse inventory
+// and records the resulting discrepancy on the inventory item. Positions without inventory are skipped.
+// The discrepancies and the summaries of the reconciled aisles are updated in one transaction.
+func ReconcileFlight(flightId int) (rr ReconcileResult, err error) {
+	rr.FlightId = flightId
+
+	var psl []positionScan
+	if psl, err = fetchPositionScans(flightId); err != nil {
+		return
+	}
+	// the tolerances are those of the site the flight scanned
+	var siteId int
+	if err = db.QueryRow(`select siteId from flights where flightId = ?`, flightId).Scan(&siteId); err != nil {
+		return
+	}
+	var tl ToleranceList
+	if tl, err = FetchTolerances(siteId); err != nil {
+		return
+	}
+
+	var tx *sql.Tx
+	if tx, err = db.Begin(); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var positionIds []int
+	for _, ps := range psl {
+		var it wmsItem
+		if it, err = fetchPositionItem(tx, ps.PositionId); err == sql.ErrNoRows {
+			err = nil
+			continue
+		} else if err != nil {
+			return
+		}
+
+		d := scanDiscrepancy(it, ps, tl)
+		if _, err = tx.Exec(`update items set discrepancy = ? where itemId = ?`, d, it.ItemId); err != nil {
+			return
+		}
+		positionIds = append(positionIds, ps.PositionId)
+		rr.Reconciled++
+		if d != "" {
+			rr.Discrepancies++
+		}
+	}
+	if err = refreshSummaries(tx, positionIds); err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+// reconcileJob reconciles the latest scan of every position against the warehouse inventory,
+// which may have been imported since the flight was ingested. Flights are reconciled oldest first so later scans win.
+func reconcileJob(ctx context.Context) (string, error) {
+	rows, err := db.Query(`select distinct max(flightId) as flightId from flightPositions group by positionId order by flightId`)
+	if err != nil {
+		return "", err
+	}
+	var fl []int
+	for rows.Next() {
+		var flightId int
+		if err = rows.Scan(&flightId); err != nil {
+			rows.Close()
+			return "", err
+		}
+		fl = append(fl, flightId)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+
+	var total ReconcileResult
+	for _, flightId := range fl {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		var rr ReconcileResult
+		if rr, err = ReconcileFlight(flightId); err != nil {
+			break
+		}
+		total.Reconciled += rr.Reconciled
+		total.Discrepancies += rr.Discrepancies
+	}
+	return fmt.Sprintf("reconciled %d positions on %d flights, %d discrepancies", total.Reconciled, len(fl), total.Discrepancies), err
+}
+
+// handleApiReconcile is the endpoint for reconciliation restful api
+// accepts:
+//
+//	POST /api/v1/flights/:id/reconcile
+//
+// Reconciles the flight against the inventory and writes a json summary.
+func handleApiReconcile(w http.ResponseWriter, r *http.Request) {
+	flightId, err := pathInt(pathParam(r, "id"), "flight id")
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	var siteId int
+	if err = db.QueryRow(`select siteId from flights where flightId = ?`, flightId).Scan(&siteId); err == sql.ErrNoRows || (err == nil && siteId != requestSite(r).Id) {
+		apiFail(w, r, errNotFound("flight %d not found", flightId))
+		return
+	} else if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+
+	rr, err := ReconcileFlight(flightId)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if err = jsonApiStatus(w, r, http.StatusOK, rr); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,642 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportDir is the root of the report drop directories, overridden by CWMS_REPORT_DIR
+const reportDir = "./reports/"
+
+// sftpDir is the root of the local sftp stand-in, overridden by CWMS_SFTP_DIR
+// Each sftp host is a directory of the stand-in, remote paths are relative to it.
+const sftpDir = "./sftp/"
+
+// ReportSchedule delivers an inventory export at the times of a cron expression, matches the reportSchedules table
+type ReportSchedule struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Report      string `json:"report"`      // inventory or discrepancy
+	Format      string `json:"format"`      // an export format: csv, json, xml, xlsx or ndjson
+	Filters     string `json:"filters"`     // export url parameters e.g. "aisle=1a&template=acme"
+	Cron        string `json:"cron"`        // minute hour day-of-month month day-of-week, in server local time
+	Delivery    string `json:"delivery"`    // folder, sftp or smtp
+	Destination string `json:"destination"` // drop directory under the site's report directory, host:path for sftp, or comma separated mail addresses
+	Enabled     bool   `json:"enabled"`
+	NextRun     string `json:"nextRun"`
+	SiteId      int    `json:"-"`
+}
+
+// ReportRun is a run of a report schedule, matches the reportRuns table
+type ReportRun struct {
+	Id           int    `json:"id"`
+	ScheduleId   int    `json:"scheduleId"`
+	StartTime    string `json:"startTime"`
+	StopTime     string `json:"stopTime"`
+	Status       string `json:"status"` // running, ok or failed
+	Message      string `json:"message"`
+	Bytes        int64  `json:"bytes"`
+	Acknowledged bool   `json:"acknowledged"`
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+	return
+}
+
+// lineWriter breaks its output into lines of at most width bytes, as mail bodies require
+type lineWriter struct {
+	w     io.Writer
+	width int
+	col   int
+}
+
+func (lw *lineWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if lw.col == lw.width {
+			if _, err = io.WriteString(lw.w, "\r\n"); err != nil {
+				return
+			}
+			lw.col = 0
+		}
+		chunk := p
+		if len(chunk) > lw.width-lw.col {
+			chunk = chunk[:lw.width-lw.col]
+		}
+		var m int
+		m, err = lw.w.Write(chunk)
+		n += m
+		lw.col += m
+		if err != nil {
+			return
+		}
+		p = p[m:]
+	}
+	return
+}
+
+// reportRoot returns the report drop directory root
+func reportRoot() string {
+	if d := os.Getenv("CWMS_REPORT_DIR"); d != "" {
+		return d
+	}
+	return reportDir
+}
+
+// fileSafe replaces the characters of s that are not safe in a file name
+func fileSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// dropDir returns the drop directory of a site's destination, which cannot escape the site's report directory
+func dropDir(siteCode, destination string) string {
+	return filepath.Join(reportRoot(), fileSafe(siteCode), filepath.FromSlash(path.Clean("/"+destination)))
+}
+
+// sftpRoot returns the root of the local sftp stand-in
+func sftpRoot() string {
+	if d := os.Getenv("CWMS_SFTP_DIR"); d != "" {
+		return d
+	}
+	return sftpDir
+}
+
+// sftpTarget splits an sftp destination host:path into its host and remote directory
+func sftpTarget(destination string) (host, dir string, err error) {
+	parts := strings.SplitN(destination, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.Trim(parts[0], "-.0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return "", "", fmt.Errorf("sftp destination must be host:path")
+	}
+	return strings.ToLower(parts[0]), path.Clean("/" + parts[1]), nil
+}
+
+// validate checks a schedule of a site and fills in its defaults
+func (rs *ReportSchedule) validate() (err error) {
+	if rs.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if rs.Report == "" {
+		rs.Report = "inventory"
+	}
+	if rs.Report != "inventory" && rs.Report != "discrepancy" {
+		return fmt.Errorf("report must be inventory or discrepancy")
+	}
+	if rs.Format == "" {
+		rs.Format = "csv"
+	}
+	if _, ok := exportFormats[rs.Format]; !ok {
+		return fmt.Errorf("unknown format %q", rs.Format)
+	}
+	if _, err = parseCron(rs.Cron); err != nil {
+		return
+	}
+	if _, _, err = rs.query(); err != nil {
+		return
+	}
+	switch rs.Delivery {
+	case "folder":
+	case "sftp":
+		if _, _, err = sftpTarget(rs.Destination); err != nil {
+			return fmt.Errorf("invalid destination: %v", err)
+		}
+	case "smtp":
+		if _, err = mail.ParseAddressList(rs.Destination); err != nil {
+			return fmt.Errorf("invalid destination: %v", err)
+		}
+	default:
+		return fmt.Errorf("delivery must be folder, sftp or smtp")
+	}
+	return
+}
+
+// query returns the export filters and layout of the report
+func (rs ReportSchedule) query() (iq InventoryQuery, el exportLayout, err error) {
+	var v url.Values
+	if v, err = url.ParseQuery(rs.Filters); err != nil {
+		return iq, el, fmt.Errorf("invalid filters: %v", err)
+	}
+	if rs.Report == "discrepancy" && v.Get("discrepancy") == "" {
+		v.Set("discrepancy", "all")
+	}
+	return parseExport(rs.SiteId, v)
+}
+
+// filename returns the name of the report file delivered at t
+func (rs ReportSchedule) filename(t time.Time) string {
+	return fmt.Sprintf("%s-%s.%s", fileSafe(rs.Name), t.Format("20060102-1504"), rs.Format)
+}
+
+// deliverFolder writes the report to a drop directory
+// The report is written under a temporary name and renamed, so the folder only ever holds complete reports.
+func (rs ReportSchedule) deliverFolder(dir string, t time.Time, write func(io.Writer) error) (n int64, err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	var f *os.File
+	if f, err = os.CreateTemp(dir, ".report-*"); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	cw := &countingWriter{w: f}
+	if err = write(cw); err == nil {
+		err = f.Chmod(0644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return
+	}
+	n = cw.n
+	err = os.Rename(f.Name(), filepath.Join(dir, rs.filename(t)))
+	return
+}
+
+// deliverSftp uploads the report to the local sftp stand-in of its host, which stands in for the site's sftp server
+// A host the stand-in does not hold is unreachable. Like sftp clients the upload is renamed into place once complete.
+func (rs ReportSchedule) deliverSftp(siteCode string, t time.Time, write func(io.Writer) error) (n int64, err error) {
+	var host, dir string
+	if host, dir, err = sftpTarget(rs.Destination); err != nil {
+		return
+	}
+	root := filepath.Join(sftpRoot(), fileSafe(siteCode), host)
+	if fi, serr := os.Stat(root); serr != nil || !fi.IsDir() {
+		return 0, fmt.Errorf("sftp host %q is unreachable", host)
+	}
+	return rs.deliverFolder(filepath.Join(root, filepath.FromSlash(dir)), t, write)
+}
+
+// deliverSmtp mails the report as an attachment using the CWMS_SMTP_ADDR server
+// CWMS_SMTP_FROM sets the sender, CWMS_SMTP_USER and CWMS_SMTP_PASSWORD authenticate when set.
+func (rs ReportSchedule) deliverSmtp(t time.Time, write func(io.Writer) error) (n int64, err error) {
+	addr := os.Getenv("CWMS_SMTP_ADDR")
+	if addr == "" {
+		return 0, fmt.Errorf("smtp is not configured, set CWMS_SMTP_ADDR")
+	}
+	from := os.Getenv("CWMS_SMTP_FROM")
+	if from == "" {
+		from = "cwms@localhost"
+	}
+	var sender *mail.Address
+	if sender, err = mail.ParseAddress(from); err != nil {
+		return 0, fmt.Errorf("invalid CWMS_SMTP_FROM: %v", err)
+	}
+	var to []*mail.Address
+	if to, err = mail.ParseAddressList(rs.Destination); err != nil {
+		return
+	}
+
+	var c *smtp.Client
+	if c, err = smtp.Dial(addr); err != nil {
+		return
+	}
+	defer c.Close()
+	host, _, _ := net.SplitHostPort(addr)
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return
+		}
+	}
+	if user := os.Getenv("CWMS_SMTP_USER"); user != "" {
+		if err = c.Auth(smtp.PlainAuth("", user, os.Getenv("CWMS_SMTP_PASSWORD"), host)); err != nil {
+			return
+		}
+	}
+	if err = c.Mail(sender.Address); err != nil {
+		return
+	}
+	var rcpt []string
+	for _, a := range to {
+		if err = c.Rcpt(a.Address); err != nil {
+			return
+		}
+		rcpt = append(rcpt, a.String())
+	}
+
+	var wc io.WriteCloser
+	if wc, err = c.Data(); err != nil {
+		return
+	}
+	mw := multipart.NewWriter(wc)
+	filename := rs.filename(t)
+	fmt.Fprintf(wc, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n",
+		sender, strings.Join(rcpt, ", "), mime.QEncoding.Encode("utf-8", "Report: "+rs.Name), t.Format(time.RFC1123Z), mw.Boundary())
+
+	var pw io.Writer
+	if pw, err = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}}); err != nil {
+		return
+	}
+	fmt.Fprintf(pw, "The %s report %s is attached.\r\n", rs.Report, filename)
+
+	if pw, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {exportFormats[rs.Format].ContentType},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": filename})},
+	}); err != nil {
+		return
+	}
+	enc := base64.NewEncoder(base64.StdEncoding, &lineWriter{w: pw, width: 76})
+	cw := &countingWriter{w: enc}
+	if err = write(cw); err != nil {
+		return
+	}
+	if err = enc.Close(); err != nil {
+		return
+	}
+	if err = mw.Close(); err != nil {
+		return
+	}
+	if err = wc.Close(); err != nil {
+		return
+	}
+	n = cw.n
+	err = c.Quit()
+	return
+}
+
+// RunReport produces and delivers a report, recording the run in the report history
+// Failed runs are logged and listed as alerts until acknowledged.
+func RunReport(rs ReportSchedule) (run ReportRun, err error) {
+	start := time.Now()
+	run = ReportRun{ScheduleId: rs.Id, StartTime: start.UTC().Format(time.RFC3339), Status: "running"}
+	var res sql.Result
+	if res, err = db.Exec(`insert into reportRuns (scheduleId, startTime, status) values (?, ?, ?)`, run.ScheduleId, run.StartTime, run.Status); err != nil {
+		return
+	}
+	var id int64
+	if id, err = res.LastInsertId(); err != nil {
+		return
+	}
+	run.Id = int(id)
+
+	var s Site
+	iq, el, rerr := rs.query()
+	if rerr == nil {
+		s, rerr = FetchSite(strconv.Itoa(rs.SiteId))
+	}
+	if rerr == nil {
+		write := func(out io.Writer) error { return exportFormats[rs.Format].write(out, iq, el) }
+		switch rs.Delivery {
+		case "smtp":
+			run.Bytes, rerr = rs.deliverSmtp(start, write)
+		case "sftp":
+			run.Bytes, rerr = rs.deliverSftp(s.Code, start, write)
+		default:
+			run.Bytes, rerr = rs.deliverFolder(dropDir(s.Code, rs.Destination), start, write)
+		}
+	}
+
+	run.StopTime = time.Now().UTC().Format(time.RFC3339)
+	run.Status = "ok"
+	if rerr != nil {
+		run.Status, run.Message = "failed", rerr.Error()
+		log.Printf("report %q failed: %v", rs.Name, rerr)
+	}
+	_, err = db.Exec(`update reportRuns set stopTime = ?, status = ?, message = ?, bytes = ? where runId = ?`,
+		run.StopTime, run.Status, run.Message, run.Bytes, run.Id)
+	return
+}
+
+const reportScheduleColumns = `scheduleId, name, report, format, IFNULL(filters, ""), cron, delivery, IFNULL(destination, ""), enabled, IFNULL(nextRun, ""), siteId`
+
+// fetchReportSchedules returns the report schedules matching a where clause
+func fetchReportSchedules(where string, args ...interface{}) (rsl []ReportSchedule, err error) {
+	var rows *sql.Rows
+	if rows, err = db.Query(`select `+reportScheduleColumns+` from reportSchedules where `+where+` order by scheduleId`, args...); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var rs ReportSchedule
+	for rows.Next() {
+		if err = rows.Scan(StructForScan(&rs)...); err != nil {
+			return
+		}
+		rsl = append(rsl, rs)
+	}
+	err = rows.Err()
+	return
+}
+
+// FetchReportSchedules returns the report schedules of a site
+func FetchReportSchedules(siteId int) ([]ReportSchedule, error) {
+	defer observeQuery("FetchReportSchedules", time.Now())
+	return fetchReportSchedules(`siteId = ?`, siteId)
+}
+
+// FetchReportSchedule returns a report schedule of a site, sql.ErrNoRows when there is none
+func FetchReportSchedule(siteId, id int) (rs ReportSchedule, err error) {
+	defer observeQuery("FetchReportSchedule", time.Now())
+	var rsl []ReportSchedule
+	if rsl, err = fetchReportSchedules(`siteId = ? and scheduleId = ?`, siteId, id); err != nil {
+		return
+	}
+	if len(rsl) == 0 {
+		return rs, sql.ErrNoRows
+	}
+	return rsl[0], nil
+}
+
+// CreateReportSchedule stores a validated report schedule for a site and schedules its first run
+func CreateReportSchedule(siteId int, rs ReportSchedule) (ReportSchedule, error) {
+	cs, err := parseCron(rs.Cron)
+	if err != nil {
+		return rs, err
+	}
+	rs.SiteId = siteId
+	rs.NextRun = cs.next(time.Now()).UTC().Format(time.RFC3339)
+	res, err := db.Exec(`insert into reportSchedules (siteId, name, report, format, filters, cron, delivery, destination, enabled, nextRun) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		siteId, rs.Name, rs.Report, rs.Format, rs.Filters, rs.Cron, rs.Delivery, rs.Destination, rs.Enabled, rs.NextRun)
+	if err != nil {
+		return rs, err
+	}
+	id, err := res.LastInsertId()
+	rs.Id = int(id)
+	return rs, err
+}
+
+// DeleteReportSchedule removes a report schedule of a site and its run history
+func DeleteReportSchedule(siteId, id int) (err error) {
+	if _, err = db.Exec(`delete from reportRuns where scheduleId in (select scheduleId from reportSchedules where siteId = ? and scheduleId = ?)`, siteId, id); err != nil {
+		return
+	}
+	_, err = db.Exec(`delete from reportSchedules where siteId = ? and scheduleId = ?`, siteId, id)
+	return
+}
+
+// fetchReportRuns returns report runs of a site matching a where clause, newest first
+func fetchReportRuns(siteId int, where string, args ...interface{}) (rl []ReportRun, err error) {
+	var rows *sql.Rows
+	if rows, err = db.Query(`select runId, scheduleId, startTime, IFNULL(stopTime, ""), status, IFNULL(message, ""), IFNULL(bytes, 0), acknowledged
+		from reportRuns join reportSchedules using(scheduleId) where siteId = ? and `+where+` order by runId desc limit 100`, append([]interface{}{siteId}, args...)...); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var run ReportRun
+	for rows.Next() {
+		if err = rows.Scan(StructForScan(&run)...); err != nil {
+			return
+		}
+		rl = append(rl, run)
+	}
+	err = rows.Err()
+	return
+}
+
+// reportJob runs the enabled report schedules whose next run is due
+// The next run is moved on before the report runs, so a slow or failing report is not repeated.
+// Failed reports are recorded in the report history rather than failing the job.
+func reportJob(ctx context.Context) (string, error) {
+	now := time.Now()
+	rsl, err := fetchReportSchedules(`enabled and nextRun <= ?`, now.UTC().Format(time.RFC3339))
+	if err != nil {
+		return "", err
+	}
+	var n int
+	for _, rs := range rsl {
+		if ctx.Err() != nil {
+			break
+		}
+		cs, err := parseCron(rs.Cron)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if _, err = db.Exec(`update reportSchedules set nextRun = ? where scheduleId = ?`, cs.next(now).UTC().Format(time.RFC3339), rs.Id); err != nil {
+			return "", err
+		}
+		if _, err = RunReport(rs); err != nil {
+			return "", err
+		}
+		n++
+	}
+	return fmt.Sprintf("ran %d reports", n), nil
+}
+
+// handleApiReportSchedules is the endpoint for scheduled report delivery restful api
+// accepts:
+//
+//	GET  /api/v1/reports/schedules
+//	POST /api/v1/reports/schedules   {"name": "nightly", "report": "discrepancy", "format": "xlsx", "cron": "0 6 * * 1-5", "delivery": "smtp", "destination": "ops@example.com", "enabled": true}
+func handleApiReportSchedules(w http.ResponseWriter, r *http.Request) {
+	siteId := requestSite(r).Id
+	if r.Method == http.MethodPost {
+		rs := ReportSchedule{Enabled: true}
+		if err := json.NewDecoder(r.Body).Decode(&rs); err != nil {
+			apiFail(w, r, errBadRequest("%v", err))
+			return
+		}
+		rs.SiteId = siteId
+		if err := rs.validate(); err != nil {
+			apiFail(w, r, errBadRequest("%v", err))
+			return
+		}
+		rs, err := CreateReportSchedule(siteId, rs)
+		if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if err = jsonApi(w, r, rs, false); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	rsl, err := FetchReportSchedules(siteId)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if err = jsonApi(w, r, rsl, false); err != nil {
+		log.Println(err)
+	}
+}
+
+// requestReportSchedule fetches the report schedule addressed by the id path parameter, writing the error response when there is none
+func requestReportSchedule(w http.ResponseWriter, r *http.Request) (rs ReportSchedule, ok bool) {
+	id, err := pathInt(pathParam(r, "id"), "schedule id")
+	if err == nil {
+		if rs, err = FetchReportSchedule(requestSite(r).Id, id); err == sql.ErrNoRows {
+			err = errNotFound("report schedule %d not found", id)
+		}
+	}
+	if err != nil {
+		apiFail(w, r, err)
+		return rs, false
+	}
+	return rs, true
+}
+
+// handleApiReportSchedule is the endpoint for a report schedule
+// accepts:
+//
+//	GET    /api/v1/reports/schedules/:id
+//	DELETE /api/v1/reports/schedules/:id
+func handleApiReportSchedule(w http.ResponseWriter, r *http.Request) {
+	rs, ok := requestReportSchedule(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodDelete {
+		if err := DeleteReportSchedule(rs.SiteId, rs.Id); err != nil {
+			apiFail(w, r, err)
+			return
+		}
+	}
+	if err := jsonApi(w, r, rs, false); err != nil {
+		log.Println(err)
+	}
+}
+
+// handleApiReportScheduleRun runs a scheduled report now
+// accepts:
+//
+//	POST /api/v1/reports/schedules/:id/run
+func handleApiReportScheduleRun(w http.ResponseWriter, r *http.Request) {
+	rs, ok := requestReportSchedule(w, r)
+	if !ok {
+		return
+	}
+	run, err := RunReport(rs)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if err = jsonApi(w, r, run, false); err != nil {
+		log.Println(err)
+	}
+}
+
+// handleApiReportScheduleRuns is the run history of a report schedule, newest first
+// accepts:
+//
+//	GET /api/v1/reports/schedules/:id/runs
+func handleApiReportScheduleRuns(w http.ResponseWriter, r *http.Request) {
+	rs, ok := requestReportSchedule(w, r)
+	if !ok {
+		return
+	}
+	rl, err := fetchReportRuns(rs.SiteId, `scheduleId = ?`, rs.Id)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if err = jsonApi(w, r, rl, false); err != nil {
+		log.Println(err)
+	}
+}
+
+// handleApiReportAlerts is the endpoint for failed report alerts
+// accepts:
+//
+//	GET    /api/v1/reports/alerts          failed runs not yet acknowledged
+//	DELETE /api/v1/reports/alerts/:runId   acknowledges a failed run
+func handleApiReportAlerts(w http.ResponseWriter, r *http.Request) {
+	siteId := requestSite(r).Id
+	if r.Method == http.MethodDelete {
+		runId, err := pathInt(pathParam(r, "runId"), "run id")
+		if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		res, err := db.Exec(`update reportRuns set acknowledged = 1 where runId = ? and status = 'failed' and scheduleId in (select scheduleId from reportSchedules where siteId = ?)`, runId, siteId)
+		if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			apiFail(w, r, errNotFound("alert %d not found", runId))
+			return
+		}
+		if err := jsonApi(w, r, nil, false); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	rl, err := fetchReportRuns(siteId, `status = 'failed' and not acknowledged`)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if err = jsonApi(w, r, rl, false); err != nil {
+		log.Println(err)
+	}
+}
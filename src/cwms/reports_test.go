@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReportDelivery(t *testing.T) {
+	seedTestDb(t)
+	reports, sftp := t.TempDir(), t.TempDir()
+	t.Setenv("CWMS_REPORT_DIR", reports)
+	t.Setenv("CWMS_SFTP_DIR", sftp)
+	north, err := FetchSite("north")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sites delivering to the same destination do not share a folder
+	for _, s := range []Site{{Id: defaultSiteId, Code: "default"}, north} {
+		rs, err := CreateReportSchedule(s.Id, ReportSchedule{Name: "shared", Report: "inventory", Format: "csv", Cron: "0 6 * * *", Delivery: "folder", Destination: "acme"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if run, err := RunReport(rs); err != nil || run.Status != "ok" {
+			t.Fatalf("%s folder delivery %+v: %v", s.Code, run, err)
+		}
+		if files, _ := filepath.Glob(filepath.Join(reports, s.Code, "acme", "shared-*.csv")); len(files) != 1 {
+			t.Errorf("%s reports %q, expected one in its own folder", s.Code, files)
+		}
+	}
+
+	rs := ReportSchedule{Name: "upload", Report: "discrepancy", Format: "json", Cron: "0 6 * * *", Delivery: "sftp", Destination: "../sftp.example.com:/../outbound", SiteId: north.Id}
+	if err = rs.validate(); err == nil {
+		t.Errorf("destination %q validated", rs.Destination)
+	}
+	rs.Destination = "sftp.example.com:/../outbound"
+	if err = rs.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if rs, err = CreateReportSchedule(north.Id, rs); err != nil {
+		t.Fatal(err)
+	}
+	if run, err := RunReport(rs); err != nil || run.Status != "failed" || !strings.Contains(run.Message, "unreachable") {
+		t.Errorf("delivery to a host the stand-in does not hold %+v: %v", run, err)
+	}
+	host := filepath.Join(sftp, "north", "sftp.example.com")
+	if err = os.MkdirAll(host, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if run, err := RunReport(rs); err != nil || run.Status != "ok" {
+		t.Errorf("sftp delivery %+v: %v", run, err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(host, "outbound", "upload-*.json")); len(files) != 1 {
+		t.Errorf("uploads %q, expected one in the remote directory of the host", files)
+	}
+}
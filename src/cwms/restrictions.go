@@ -2,47 +2,48 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
 	"strings"
+	"time"
+
+	"cwms/api"
 )
 
-// restriction definition matches database table
-// xml and json reflection tags determine how the restrictions appear the response
-type Restriction struct {
-	Id             int      `xml:"id,attr" json:"id"`
-	Aisles         []string `json:"region"`
-	Name           string   `xml:"name,attr" json:"-"`
-	StartDate      string   `xml:"date>start" json:"startDate"`
-	StopDate       string   `xml:"date>stop" json:"stopDate"`
-	StartTime      string   `xml:"time>start" json:"startTime"`
-	StopTime       string   `xml:"time>stop" json:"stopTime"`
-	EnabledDays    []bool   `json:"enabledDays"`
-	PeriodicityNum int      `xml:"periodicityNum" json:"periodicityNum"`
-	Periodicity    string   `xml:"periodicity" json:"periodicity"`
-	Region         int      `xml:"region" json:"-"`
-}
-type RestrictionList []Restriction
+// Restriction is a period a region is closed to flights, matching the restrictions table
+type (
+	Restriction     = api.Restriction
+	RestrictionList = api.RestrictionList
+	NewRestriction  = api.NewRestriction
+)
 
 // RestrictionFilter holds Restriction filter information
 // Restriction and tbd filters a cumulative
 type RestrictionFilter struct {
-	Id   int    // Filter on id
-	Name string // Filter on name
+	Id     int    // Filter on id
+	Name   string // Filter on name
+	SiteId int    // Filter on site
 }
 
-// toSqlStmt generates a sql statement based on the current set of page controls
-func (rf RestrictionFilter) toSqlStmt() (sqlstmt string) {
+// toSqlStmt generates a sql statement and its arguments based on the current set of page controls
+func (rf RestrictionFilter) toSqlStmt() (sqlstmt string, args []interface{}) {
 	var sel, order string
 	var where []string
-	sel = `select restrictionId, name, startDate, stopDate, startTime, stopTime, periodicityNum, periodicity, regionId from restrictions `
+	// the DATETIME columns hold dates and clock times as text, the driver would parse them as timestamps
+	sel = `select restrictionId, name, cast(startDate as text), cast(stopDate as text), cast(startTime as text), cast(stopTime as text), periodicityNum, periodicity, regionId from restrictions `
 	if rf.Name != "" {
-		where = append(where, fmt.Sprintf(`name ='%s'`, rf.Name))
+		where = append(where, `name = ?`)
+		args = append(args, rf.Name)
 	}
 	if rf.Id != 0 {
-		where = append(where, fmt.Sprintf(`restrictionId = %v`, rf.Id))
+		where = append(where, `restrictionId = ?`)
+		args = append(args, rf.Id)
+	}
+	if rf.SiteId != 0 {
+		where = append(where, `siteId = ?`)
+		args = append(args, rf.SiteId)
 	}
 	order = `order by regionId`
 	if len(where) > 0 {
@@ -53,38 +54,78 @@ func (rf RestrictionFilter) toSqlStmt() (sqlstmt string) {
 	return
 }
 
-func FetchRegionAisles(region int) (al []string) {
+// fetchAislesOfRegions returns the aisles of each of the regions in one query
+func fetchAislesOfRegions(regionIds []int) (ra map[int][]string, err error) {
+	defer observeQuery("fetchAislesOfRegions", time.Now())
+	ra = make(map[int][]string)
+	if len(regionIds) == 0 {
+		return
+	}
+	// the ids are bound as one json array, however many regions there are
+	var ids []byte
+	if ids, err = json.Marshal(regionIds); err != nil {
+		return
+	}
 	var rows *sql.Rows
-	var err error
-	rows, err = db.Query("select distinct aisle from v_regionPosition where regionId=?", region)
-
+	rows, err = db.Query(`select regionId, aisle from v_regionPosition where regionId in (select value from json_each(?))
+		and aisle is not null group by regionId, aisle order by regionId, aisle`, string(ids))
 	if err != nil {
-		log.Println(err)
 		return
 	}
 	defer rows.Close()
 
 	// Process database query results
+	var regionId int
 	var aisle string
 	for rows.Next() {
-		if err = rows.Scan(&aisle); err != nil {
+		if err = rows.Scan(&regionId, &aisle); err != nil {
 			return
 		}
-		al = append(al, aisle)
+		ra[regionId] = append(ra[regionId], aisle)
 	}
+	err = rows.Err()
 	return
 }
 
-func FetchEnabledDays(region int) (edl []bool) {
-	edl = []bool{true, false, false, false, false, false, true}
+// clockTime formats a stored restriction time as hh:mm, leaving a time it cannot parse as it is
+func clockTime(s string) string {
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("15:04")
+		}
+	}
+	return s
+}
+
+// enabledDays returns the days of the week, from Sunday, a periodicity restricts
+// A periodicity that names no days, such as daily or everyday, restricts every day.
+func enabledDays(periodicity string) (edl []bool) {
+	edl = make([]bool, 7)
+	p := strings.ToLower(periodicity)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		weekend := d == time.Saturday || d == time.Sunday
+		switch p {
+		case "weekdays":
+			edl[d] = !weekend
+		case "weekends":
+			edl[d] = weekend
+		case "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday":
+			edl[d] = p == strings.ToLower(d.String())
+		default:
+			edl[d] = true
+		}
+	}
 	return
 }
 
 // FetchRestrictions performs a query on restrictions and returns the results in a RestrictionList.
+// The aisles of the restricted regions are fetched in one further query whatever the number of restrictions.
 func FetchRestrictions(rf RestrictionFilter) (rl RestrictionList, err error) {
+	defer observeQuery("FetchRestrictions", time.Now())
 	// Execute database query
 	var rows *sql.Rows
-	rows, err = db.Query(rf.toSqlStmt())
+	sqlstmt, args := rf.toSqlStmt()
+	rows, err = db.Query(sqlstmt, args...)
 
 	if err != nil {
 		return
@@ -93,6 +134,8 @@ func FetchRestrictions(rf RestrictionFilter) (rl RestrictionList, err error) {
 
 	// Process database query results
 	var record Restriction
+	var regionIds []int
+	seen := make(map[int]bool)
 	for rows.Next() {
 		err = rows.Scan(&record.Id,
 			&record.Name,
@@ -106,36 +149,144 @@ func FetchRestrictions(rf RestrictionFilter) (rl RestrictionList, err error) {
 		if err != nil {
 			return
 		}
-		record.Aisles = FetchRegionAisles(record.Id)
-		record.EnabledDays = FetchEnabledDays(record.Id)
+		record.StartTime, record.StopTime = clockTime(record.StartTime), clockTime(record.StopTime)
+		record.EnabledDays = enabledDays(record.Periodicity)
+		if !seen[record.Region] {
+			seen[record.Region] = true
+			regionIds = append(regionIds, record.Region)
+		}
 		rl = append(rl, record)
 	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	rows.Close()
+
+	// Assemble the aisles of each restriction's region
+	var ra map[int][]string
+	if ra, err = fetchAislesOfRegions(regionIds); err != nil {
+		return
+	}
+	for i := range rl {
+		rl[i].Aisles = ra[rl[i].Region]
+	}
 	return
 }
 
+// validateRestriction checks the dates and times of a new restriction and fills in its default periodicity
+func validateRestriction(nr *NewRestriction) error {
+	if nr.Name == "" || nr.RegionName == "" {
+		return fmt.Errorf("name and regionName are required")
+	}
+	start, err := time.Parse("2006-01-02", nr.StartDate)
+	if err != nil {
+		return fmt.Errorf("startDate %q is not yyyy-mm-dd", nr.StartDate)
+	}
+	stop, err := time.Parse("2006-01-02", nr.StopDate)
+	if err != nil {
+		return fmt.Errorf("stopDate %q is not yyyy-mm-dd", nr.StopDate)
+	}
+	if stop.Before(start) {
+		return fmt.Errorf("stopDate is before startDate")
+	}
+	for _, t := range []string{nr.StartTime, nr.StopTime} {
+		if _, err = time.Parse("15:04", t); err != nil {
+			return fmt.Errorf("time %q is not hh:mm", t)
+		}
+	}
+	if nr.PeriodicityNum < 0 {
+		return fmt.Errorf("periodicityNum must not be negative")
+	}
+	if nr.PeriodicityNum == 0 {
+		nr.PeriodicityNum = 1
+	}
+	if nr.Periodicity == "" {
+		nr.Periodicity = "daily"
+	}
+	return nil
+}
+
+// CreateRestriction closes a named region of a site to flights
+func CreateRestriction(siteId int, nr NewRestriction) (r Restriction, err error) {
+	var regionId int
+	if regionId, err = fetchRegionId(siteId, nr.RegionName); err == sql.ErrNoRows {
+		return r, errBadRequest("region %q not found", nr.RegionName)
+	} else if err != nil {
+		return
+	}
+
+	var res sql.Result
+	if res, err = db.Exec(`insert into restrictions (name, startDate, stopDate, startTime, stopTime, periodicityNum, periodicity, regionId, siteId) values (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		nr.Name, nr.StartDate, nr.StopDate, nr.StartTime, nr.StopTime, nr.PeriodicityNum, nr.Periodicity, regionId, siteId); err != nil {
+		return
+	}
+	var id int64
+	if id, err = res.LastInsertId(); err != nil {
+		return
+	}
+	var rl RestrictionList
+	if rl, err = FetchRestrictions(RestrictionFilter{Id: int(id), SiteId: siteId}); err != nil {
+		return
+	}
+	if len(rl) == 0 {
+		return r, sql.ErrNoRows
+	}
+	return rl[0], nil
+}
+
 // handleApiRestrictions is the endpoint for restrictions restful api
 // accepts:
-//  /restrictions
-//	/restrictions/:name
-// Sets restrictions filter based on name and writes a json response with
+//
+//	GET  /api/v1/restrictions
+//	GET  /api/v1/restrictions/:id
+//	POST /api/v1/restrictions    {"name": "", "regionName": "", "startDate": "2020-04-04", "stopDate": "2020-04-05", "startTime": "10:00", "stopTime": "13:00"}
+//
+// Sets restrictions filter based on id and writes a json response with
 // a list of restrictions.
 func handleApiRestrictions(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var nr NewRestriction
+		if err := json.NewDecoder(r.Body).Decode(&nr); err != nil {
+			apiFail(w, r, errBadRequest("%v", err))
+			return
+		}
+		if err := validateRestriction(&nr); err != nil {
+			apiFail(w, r, errBadRequest("%v", err))
+			return
+		}
+		rs, err := CreateRestriction(requestSite(r).Id, nr)
+		if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if err = jsonApi(w, r, rs, false); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
 	// Fetch restrictions based on filter
 	var rf RestrictionFilter
+	rf.SiteId = requestSite(r).Id
 
-	// Get segment list from request, set discrepancy filter if the last segment is a specific aisle
-	sl := strings.Split(r.URL.Path, "/")
-	if len(sl) > 0 {
-		ls := sl[len(sl)-1]
-		if ls != "" {
-			rf.Id, _ = strconv.Atoi(ls)
+	// Set restriction filter if the path names a restriction
+	if id := pathParam(r, "id"); id != "" {
+		var err error
+		if rf.Id, err = pathInt(id, "restriction id"); err != nil {
+			apiFail(w, r, err)
+			return
 		}
 	}
 
 	// Fetch restrictions filtered by restriction filter
 	rl, err := FetchRestrictions(rf)
 	if err != nil {
-		log.Println(err)
+		apiFail(w, r, err)
+		return
+	}
+	if rf.Id != 0 && len(rl) == 0 {
+		apiFail(w, r, errNotFound("restriction %d not found", rf.Id))
+		return
 	}
 
 	// Send filtered restriction list in json response
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnabledDays(t *testing.T) {
+	all := []bool{true, true, true, true, true, true, true}
+	for _, test := range []struct {
+		periodicity string
+		days        []bool // from Sunday
+	}{
+		{"daily", all},
+		{"everyday", all},
+		{"", all},
+		{"weekdays", []bool{false, true, true, true, true, true, false}},
+		{"Weekends", []bool{true, false, false, false, false, false, true}},
+		{"sunday", []bool{true, false, false, false, false, false, false}},
+		{"monday", []bool{false, true, false, false, false, false, false}},
+		{"tuesday", []bool{false, false, true, false, false, false, false}},
+		{"wednesday", []bool{false, false, false, true, false, false, false}},
+		{"Thursday", []bool{false, false, false, false, true, false, false}},
+		{"friday", []bool{false, false, false, false, false, true, false}},
+		{"saturday", []bool{false, false, false, false, false, false, true}},
+	} {
+		if days := enabledDays(test.periodicity); !reflect.DeepEqual(days, test.days) {
+			t.Errorf("enabledDays(%q) = %v, expected %v", test.periodicity, days, test.days)
+		}
+	}
+}
+
+// queryCount returns the number of calls of a Fetch function observed by the query metrics
+func queryCount(function string) uint64 {
+	key := labelPairs(dbQueryDuration.labels, []string{function})
+	dbQueryDuration.mu.Lock()
+	defer dbQueryDuration.mu.Unlock()
+	if s, ok := dbQueryDuration.series[key]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func TestFetchRestrictionsAisles(t *testing.T) {
+	openTestDb(t)
+	if _, err := MigrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`insert into positions (positionId, json_position) values
+			(1, '{"aisle":"1a", "block":"1", "slot":"1"}'), (2, '{"aisle":"1a", "block":"1", "slot":"2"}'),
+			(3, '{"aisle":"1b", "block":"1", "slot":"1"}'), (4, '{"aisle":"2a", "block":"1", "slot":"1"}'),
+			(5, '{"aisle":"2a", "block":"2", "slot":"1"}'), (6, '{"aisle":"3a", "block":"1", "slot":"1"}')`,
+		`insert into regions (regionId, name, frequency) values (1, 'north', 1), (2, 'south', 1), (3, 'empty', 1), (4, 'east', 1)`,
+		`insert into regionPositions (regionId, positionId) values (1, 3), (1, 1), (1, 2), (2, 4), (2, 5), (4, 6), (4, 2)`,
+		`insert into restrictions (restrictionId, name, startDate, stopDate, startTime, stopTime, periodicityNum, periodicity, regionId) values
+			(1, 'north', '2020-04-04', '2020-04-05', '10:00', '13:00', 1, 'daily', 1),
+			(2, 'south', '2020-04-04', '2020-04-05', '10:00', '13:00', 1, 'weekdays', 2),
+			(3, 'north again', '2020-04-06', '2020-04-07', '08:00', '09:00', 1, 'daily', 1),
+			(4, 'empty', '2020-04-04', '2020-04-05', '10:00', '13:00', 1, 'daily', 3),
+			(5, 'east', '2020-04-04', '2020-04-05', '10:00', '13:00', 1, 'daily', 4)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := queryCount("fetchAislesOfRegions")
+	rl, err := FetchRestrictions(RestrictionFilter{SiteId: defaultSiteId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := queryCount("fetchAislesOfRegions") - before; n != 1 {
+		t.Errorf("aisles of the regions fetched in %d queries, expected 1", n)
+	}
+
+	// each aisle of a region once, in order
+	expected := map[int][]string{1: {"1a", "1b"}, 2: {"2a"}, 3: {"1a", "1b"}, 4: nil, 5: {"1a", "3a"}}
+	if len(rl) != len(expected) {
+		t.Fatalf("%d restrictions, expected %d", len(rl), len(expected))
+	}
+	for _, r := range rl {
+		if !reflect.DeepEqual(r.Aisles, expected[r.Id]) {
+			t.Errorf("restriction %d over aisles %q, expected %q", r.Id, r.Aisles, expected[r.Id])
+		}
+	}
+
+	// more regions than sqlite binds parameters
+	regionIds := make([]int, 40000)
+	for i := range regionIds {
+		regionIds[i] = i + 1
+	}
+	ra, err := fetchAislesOfRegions(regionIds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[int][]string{1: {"1a", "1b"}, 2: {"2a"}, 4: {"1a", "3a"}}; !reflect.DeepEqual(ra, expected) {
+		t.Errorf("aisles of %d regions %q, expected %q", len(regionIds), ra, expected)
+	}
+}
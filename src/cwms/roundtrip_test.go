@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"cwms/api"
+	"cwms/client"
+)
+
+// testClient serves the api of the sample warehouse and returns a client of it
+func testClient(t *testing.T) *client.Client {
+	t.Helper()
+	seedTestDb(t)
+	srv := httptest.NewServer(newApiRouter("/api/v1", false, apiRoutes()))
+	t.Cleanup(srv.Close)
+	return client.New(srv.URL, "")
+}
+
+func TestRestrictionRoundTrip(t *testing.T) {
+	c := testClient(t)
+	ctx := context.Background()
+	nr := api.NewRestriction{Name: "mezzanine", RegionName: "region2", StartDate: "2020-05-01", StopDate: "2020-05-02",
+		StartTime: "10:00", StopTime: "13:30", Periodicity: "weekdays"}
+	r, err := c.AddRestriction(ctx, nr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rl, err := c.Restrictions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, listed := range rl {
+		if listed.Id != r.Id {
+			continue
+		}
+		if listed.StartDate != nr.StartDate || listed.StopDate != nr.StopDate || listed.StartTime != nr.StartTime || listed.StopTime != nr.StopTime {
+			t.Errorf("listed %s %s %s %s, expected %s %s %s %s", listed.StartDate, listed.StopDate, listed.StartTime, listed.StopTime,
+				nr.StartDate, nr.StopDate, nr.StartTime, nr.StopTime)
+		}
+		if listed.Periodicity != "weekdays" || listed.PeriodicityNum != 1 || len(listed.Aisles) == 0 {
+			t.Errorf("listed %+v, expected every weekday over the aisles of region2", listed)
+		}
+		return
+	}
+	t.Errorf("restriction %d is not listed", r.Id)
+}
+
+func TestImportRoundTrip(t *testing.T) {
+	c := testClient(t)
+	ctx := context.Background()
+	before, err := c.Aisle(ctx, "2a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csv := "aisle,block,slot,display_name,sku,gtin,lot,expiry,quantity,image_url\n" +
+		before[0].Aisle + "," + before[0].Block + "," + before[0].Slot + ",,sku-imported,,LOT1,2030-01-31,12,\n"
+	for i := 0; i < 2; i++ {
+		ir, err := c.Import(ctx, "text/csv", []byte(csv))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ir.Imported != 1 || ir.Replaced != 1 || ir.Positions != 0 {
+			t.Errorf("import %d: %+v, expected one record replacing the item at its position", i, ir)
+		}
+	}
+
+	after, err := c.Aisle(ctx, "2a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Fatalf("%d records in aisle 2a after the import, expected %d", len(after), len(before))
+	}
+	for _, w := range after {
+		if w.Block != before[0].Block || w.Slot != before[0].Slot {
+			continue
+		}
+		if w.SKU.String != "sku-imported" || w.Lot.String != "LOT1" || w.Expiry.String != "2030-01-31" || w.Quantity != 12 {
+			t.Errorf("imported record %+v", w)
+		}
+		if !w.StopTime.Equal(before[0].StopTime) {
+			t.Errorf("scanned at %v after the import, expected %v", w.StopTime, before[0].StopTime)
+		}
+	}
+}
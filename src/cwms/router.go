@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+)
+
+// apiRoute is an api endpoint, the routing table entry it is served by and the OpenAPI operation it is documented as
+type apiRoute struct {
+	Method   string
+	Pattern  string   // path under the api root, {name} segments are path parameters
+	Legacy   []string // deprecated unversioned aliases under /api, with the same path parameters
+	Handler  http.HandlerFunc
+	Scoped   bool // site scoped, also served under /sites/{site} and authorized by smw
+	Tag      string
+	Summary  string
+	Query    []apiParam
+	Body     interface{} // json request body, or a content type string for other bodies
+	Response interface{} // json response, or a content type string for other responses
+	Status   int         // success status when it does not follow the method as in jsonApi
+}
+
+// apiParam is a documented query parameter
+type apiParam struct {
+	Name, Description string
+}
+
+// apiParamsKey is the request context key holding the path parameters of a routed api request
+type apiParamsKey struct{}
+
+// apiPrefixKey is the request context key holding the api root a request was routed under
+type apiPrefixKey struct{}
+
+// pathParam returns a path parameter of a routed api request
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(apiParamsKey{}).(map[string]string)
+	return params[name]
+}
+
+// routeEntry is a route compiled against one of its patterns
+type routeEntry struct {
+	route   *apiRoute
+	pattern string
+	segs    []string
+}
+
+// apiRouter dispatches api requests by method and path to the routes of a routing table
+// The versioned router serves each route's Pattern, the legacy router its Legacy aliases.
+type apiRouter struct {
+	prefix  string
+	legacy  bool
+	entries []routeEntry
+}
+
+// newApiRouter compiles a routing table for the api root prefix
+func newApiRouter(prefix string, legacy bool, routes []apiRoute) *apiRouter {
+	ar := &apiRouter{prefix: prefix, legacy: legacy}
+	for i := range routes {
+		rt := &routes[i]
+		patterns := []string{rt.Pattern}
+		if legacy {
+			patterns = rt.Legacy
+		}
+		for _, p := range patterns {
+			ar.entries = append(ar.entries, routeEntry{route: rt, pattern: p, segs: splitPath(p)})
+		}
+	}
+	return ar
+}
+
+// splitPath splits a path into its segments, ignoring leading and trailing slashes
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// matchSegs matches path segments against a pattern, returning the path parameters
+func matchSegs(pattern, segs []string) (map[string]string, bool) {
+	if len(pattern) != len(segs) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, ps := range pattern {
+		if strings.HasPrefix(ps, "{") && strings.HasSuffix(ps, "}") {
+			if segs[i] == "" {
+				return nil, false
+			}
+			params[ps[1:len(ps)-1]] = segs[i]
+		} else if ps != segs[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// moreSpecific reports whether pattern a is preferred to pattern b, a literal segment beats a parameter
+func moreSpecific(a, b []string) bool {
+	for i := range a {
+		ap, bp := strings.HasPrefix(a[i], "{"), strings.HasPrefix(b[i], "{")
+		if ap != bp {
+			return bp
+		}
+	}
+	return false
+}
+
+// match returns the entries of the most specific pattern matching the path segments, and its parameters
+func (ar *apiRouter) match(segs []string, scoped bool) (el []routeEntry, params map[string]string) {
+	var best []string
+	for _, e := range ar.entries {
+		if scoped && !e.route.Scoped {
+			continue
+		}
+		p, ok := matchSegs(e.segs, segs)
+		if !ok {
+			continue
+		}
+		switch {
+		case best == nil || moreSpecific(e.segs, best):
+			best, params, el = e.segs, p, []routeEntry{e}
+		case strings.Join(e.segs, "/") == strings.Join(best, "/"):
+			el = append(el, e)
+		}
+	}
+	return
+}
+
+// ServeHTTP routes a request, answering 404 for unknown paths and 405 with the allowed methods for unknown methods
+// Site scoped routes are also matched under sites/{site}, with the site in the request context.
+func (ar *apiRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segs := splitPath(strings.TrimPrefix(r.URL.Path, ar.prefix))
+	el, params := ar.match(segs, false)
+	site := ""
+	if el == nil && len(segs) > 2 && segs[0] == "sites" {
+		if el, params = ar.match(segs[2:], true); el != nil {
+			site = segs[1]
+		}
+	}
+	if el == nil {
+		apiNotFound(w, r)
+		return
+	}
+
+	// Label request metrics by route pattern rather than mux prefix
+	if sw, ok := w.(*statusWriter); ok {
+		sw.route = ar.prefix + el[0].pattern
+		if site != "" {
+			sw.route = ar.prefix + "/sites/{site}" + el[0].pattern
+		}
+	}
+
+	var e *routeEntry
+	var allow []string
+	for i := range el {
+		m := el[i].route.Method
+		allow = append(allow, m)
+		if m == http.MethodGet {
+			allow = append(allow, http.MethodHead)
+		}
+		if e == nil && (m == r.Method || (m == http.MethodGet && r.Method == http.MethodHead)) {
+			e = &el[i]
+		}
+	}
+	allow = append(allow, http.MethodOptions)
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	if r.Method == http.MethodOptions {
+		// amw answers the preflight request
+		e = &el[0]
+	} else if e == nil {
+		apiFail(w, r, errMethodNotAllowed(r))
+		return
+	}
+
+	sitePrefix := ""
+	ctx := context.WithValue(r.Context(), apiParamsKey{}, params)
+	ctx = context.WithValue(ctx, apiPrefixKey{}, ar.prefix)
+	if site != "" {
+		s, err := FetchSite(site)
+		if err == sql.ErrNoRows {
+			apiFail(w, r, errNotFound("site %q not found", site))
+			return
+		} else if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		ctx = context.WithValue(ctx, siteKey{}, s)
+		sitePrefix = "/sites/" + site
+	}
+
+	if ar.legacy {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "</api/v1"+sitePrefix+expandPattern(e.route.Pattern, params)+`>; rel="successor-version"`)
+	}
+
+	h := e.route.Handler
+	if e.route.Scoped {
+		h = smw(h)
+	}
+	amw(h)(w, r.WithContext(ctx))
+}
+
+// expandPattern substitutes path parameters into a route pattern
+func expandPattern(pattern string, params map[string]string) string {
+	segs := splitPath(pattern)
+	for i, s := range segs {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			segs[i] = params[s[1:len(s)-1]]
+		}
+	}
+	return "/" + strings.Join(segs, "/")
+}
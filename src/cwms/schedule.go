@@ -2,10 +2,12 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"log"
 	"net/http"
-	"strconv"
-	"strings"
+	"time"
+
+	"cwms/api"
 )
 
 type Mms struct {
@@ -59,6 +61,7 @@ type MmsSummaryList []MmsSummary
 type mmwHandler func(tm map[string]interface{}, ml MmsList, w http.ResponseWriter, r *http.Request)
 
 // mmw mission middleware fetches missions, statistics, and mission controls and loads them into the template map
+//
 //	mmw does "everything":
 //	• loads missions into the template map (tm)
 //	• loads mission controls into the template map
@@ -68,7 +71,7 @@ func mmw(next mmwHandler) http.HandlerFunc {
 		urlParams := r.URL.Query()
 
 		// Create mission controls
-		mc, err := missionControls(urlParams.Get("day"), urlParams.Get("scope"))
+		mc, err := missionControls(requestSite(r).Id, urlParams.Get("day"), urlParams.Get("scope"))
 		if err != nil {
 			log.Println(err)
 		}
@@ -94,6 +97,7 @@ func mmw(next mmwHandler) http.HandlerFunc {
 
 // FetchSchedule performs a query on v_inventory and returns the results in a WmsList.
 func FetchSchedule(mc MissionControls) (ml MmsList, err error) {
+	defer observeQuery("FetchSchedule", time.Now())
 	// Execute database query
 	var rows *sql.Rows
 	rows, err = db.Query(mc.toSqlStmt())
@@ -120,11 +124,11 @@ func FetchSchedule(mc MissionControls) (ml MmsList, err error) {
 	return
 }
 
-// fetchDays performs a query on v_schedule and returns the results in a dayList
-func fetchDays(filter string) (dayList []string, err error) {
+// fetchDays performs a query on v_schedule and returns the regions of a site in a dayList
+func fetchDays(siteId int, filter string) (dayList []string, err error) {
 	// Execute database query
 	var rows *sql.Rows
-	rows, err = db.Query(`select distinct region from v_schedule order by entry`)
+	rows, err = db.Query(`select distinct region from v_schedule where siteId = ? order by entry`, siteId)
 	if err != nil {
 		return
 	}
@@ -142,114 +146,333 @@ func fetchDays(filter string) (dayList []string, err error) {
 	return
 }
 
-type Queue struct {
-	Id            int      `json:"id"`
-	Aisles        []string `json:"region"`
-	StartTime     string   `json:"startTime"`
-	StopTime      string   `json:"stopTimeEstimate"`
-	LastCompleted string   `json:"lastCompleted"`
-	Frequency     int      `json:"frequency"`
+// missionScanTime is the planning estimate of the time a drone takes to scan one position
+const missionScanTime = 20 * time.Second
+
+// Queue is a scheduled mission: an event scanning a region, planned onto a drone of the site's fleet
+type (
+	Queue     = api.Queue
+	QueueList = api.QueueList
+)
+
+// planQueue assigns each mission, in queue order, to the capable drone that can start it soonest
+// A mission does not start until every earlier mission sharing one of its aisles has finished,
+// so no two drones scan the same aisle at the same time.
+func planQueue(ql QueueList, dl DroneList, start time.Time) {
+	droneFree := make(map[int]time.Time)
+	aisleFree := make(map[string]time.Time)
+	for i := range ql {
+		q := &ql[i]
+		if q.Positions == 0 {
+			continue
+		}
+
+		// the mission cannot start before its aisles are clear
+		clear := start
+		for _, a := range q.Aisles {
+			if t, ok := aisleFree[a]; ok && t.After(clear) {
+				clear = t
+			}
+		}
+
+		var best Drone
+		var bestStart time.Time
+		for _, d := range dl {
+			if !d.available() || !d.can(q.Requires) {
+				continue
+			}
+			s := clear
+			if t, ok := droneFree[d.Id]; ok && t.After(s) {
+				s = t
+			}
+			if best.Id == 0 || s.Before(bestStart) {
+				best, bestStart = d, s
+			}
+		}
+		if best.Id == 0 {
+			continue
+		}
+
+		stop := bestStart.Add(time.Duration(q.Positions) * missionScanTime)
+		q.DroneId = best.Id
+		q.StartTime = bestStart.Format(time.RFC3339)
+		q.StopTime = stop.Format(time.RFC3339)
+		droneFree[best.Id] = stop
+		for _, a := range q.Aisles {
+			aisleFree[a] = stop
+		}
+	}
 }
-type QueueList []Queue
 
-func FetchQueue(id int) (q Queue, err error) {
-	q = Queue{Id: id,
-		Aisles:        []string{"1a", "1b"},
-		StartTime:     "0001-01-01T00:00:00Z",
-		StopTime:      "0001-01-01T00:00:00Z",
-		LastCompleted: "0001-01-01T00:00:00Z",
-		Frequency:     1}
+// fetchRegionAisles returns the aisles of each region of a site
+func fetchRegionAisles(siteId int) (ra map[int][]string, err error) {
+	var rows *sql.Rows
+	if rows, err = db.Query(`select regionId, aisle from v_regionPosition join regions using(regionId) where siteId = ? and aisle is not null group by regionId, aisle order by regionId, aisle`, siteId); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	ra = make(map[int][]string)
+	var regionId int
+	var aisle string
+	for rows.Next() {
+		if err = rows.Scan(&regionId, &aisle); err != nil {
+			return
+		}
+		ra[regionId] = append(ra[regionId], aisle)
+	}
+	err = rows.Err()
 	return
 }
 
-func FetchQueueList() (ql QueueList, err error) {
-	for i := 1; i < 11; i++ {
-		q, _ := FetchQueue(i)
-		ql = append(ql, q)
+// fetchRegionCompleted returns the time each region of a site was last scanned
+func fetchRegionCompleted(siteId int) (rc map[int]string, err error) {
+	var rows *sql.Rows
+	if rows, err = db.Query(`select regionId, lastScanned from regionSummaries where siteId = ? and lastScanned is not null`, siteId); err != nil {
+		return
 	}
+	defer rows.Close()
+
+	rc = make(map[int]string)
+	var regionId int
+	var completed string
+	for rows.Next() {
+		if err = rows.Scan(&regionId, &completed); err != nil {
+			return
+		}
+		rc[regionId] = completed
+	}
+	err = rows.Err()
 	return
 }
 
-func handleApiQueue(w http.ResponseWriter, r *http.Request) {
-	// Get segment list from request, set discrepancy filter if the last segment is a specific aisle
-	sl := strings.Split(r.URL.Path, "/")
-	if len(sl) > 0 {
-		ls := sl[len(sl)-1]
-		if ls != "" {
-			id, _ := strconv.Atoi(ls)
-			q, err := FetchQueue(id)
-			if err != nil {
-				log.Println(err)
-			}
-
-			if err = jsonApi(w, r, q, false); err != nil {
-				log.Println(err)
-			}
+// FetchQueueList returns the mission queue of a site, planned across its fleet from start
+func FetchQueueList(siteId int, start time.Time) (ql QueueList, err error) {
+	defer observeQuery("FetchQueueList", time.Now())
+	var rows *sql.Rows
+	if rows, err = db.Query(`select entry, IFNULL(regions.name, ""), IFNULL(frequency, 0), IFNULL(requires, ""), regionId,
+		(select count(*) from regionPositions where regionPositions.regionId = regions.regionId)
+		from events join regions using(regionId) where siteId = ? order by entry`, siteId); err != nil {
+		return
+	}
+	defer rows.Close()
 
-		} else {
-			ql, err := FetchQueueList()
-			if err != nil {
-				log.Println(err)
-			}
+	for rows.Next() {
+		q := Queue{Aisles: []string{}}
+		if err = rows.Scan(&q.Id, &q.Region, &q.Frequency, &q.Requires, &q.RegionId, &q.Positions); err != nil {
+			return
+		}
+		ql = append(ql, q)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
 
-			// Send filter inventory in json response
-			if err = jsonApi(w, r, ql, false); err != nil {
-				log.Println(err)
-			}
+	var ra map[int][]string
+	if ra, err = fetchRegionAisles(siteId); err != nil {
+		return
+	}
+	var rc map[int]string
+	if rc, err = fetchRegionCompleted(siteId); err != nil {
+		return
+	}
+	for i := range ql {
+		if a, ok := ra[ql[i].RegionId]; ok {
+			ql[i].Aisles = a
 		}
+		ql[i].LastCompleted = rc[ql[i].RegionId]
 	}
+
+	var dl DroneList
+	if dl, err = FetchDrones(siteId); err != nil {
+		return
+	}
+	planQueue(ql, dl, start)
+	return
 }
 
-type CustomQueue struct {
-	Id        int      `json:"id"`
-	Aisles    []string `json:"region"`
-	StartTime string   `json:"startTime"`
-	StopTime  string   `json:"stopTime"`
+// FetchQueue returns a single planned mission of a site, sql.ErrNoRows when there is no such mission
+func FetchQueue(siteId, id int) (q Queue, err error) {
+	defer observeQuery("FetchQueue", time.Now())
+	var ql QueueList
+	if ql, err = FetchQueueList(siteId, time.Now().UTC()); err != nil {
+		return
+	}
+	for _, q = range ql {
+		if q.Id == id {
+			return
+		}
+	}
+	return Queue{}, sql.ErrNoRows
 }
-type CustomQueueList []CustomQueue
 
-func FetchCustomQueue(id int) (q CustomQueue, err error) {
-	q = CustomQueue{Id: id,
-		Aisles:    []string{"1a", "1b"},
-		StartTime: "0001-01-01T00:00:00Z",
-		StopTime:  "0001-01-01T00:00:00Z"}
+// fetchRegionId returns the id of a named region of a site
+func fetchRegionId(siteId int, name string) (id int, err error) {
+	err = db.QueryRow(`select regionId from regions where siteId = ? and name = ?`, siteId, name).Scan(&id)
 	return
 }
 
-func FetchCustomQueueList() (ql CustomQueueList, err error) {
-	for i := 1; i < 11; i++ {
-		q, _ := FetchCustomQueue(i)
-		ql = append(ql, q)
+// CreateQueueEntry appends a mission over a named region to the end of a site's queue
+// Entries are numbered within each site, the new entry takes the site's next number in the transaction that inserts it.
+func CreateQueueEntry(siteId int, region string) (q Queue, err error) {
+	var regionId int
+	if regionId, err = fetchRegionId(siteId, region); err == sql.ErrNoRows {
+		return q, errBadRequest("region %q not found", region)
+	} else if err != nil {
+		return
 	}
-	return
+
+	var tx *sql.Tx
+	if tx, err = db.Begin(); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var res sql.Result
+	if res, err = tx.Exec(`insert into events (name, entry, regionId) select 'event' || (IFNULL(max(entry), 0) + 1), IFNULL(max(entry), 0) + 1, ?
+		from events where regionId in (select regionId from regions where siteId = ?)`, regionId, siteId); err != nil {
+		return
+	}
+	var eventId int64
+	if eventId, err = res.LastInsertId(); err != nil {
+		return
+	}
+	var entry int
+	if err = tx.QueryRow(`select entry from events where eventId = ?`, eventId).Scan(&entry); err != nil {
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		return
+	}
+	return FetchQueue(siteId, entry)
 }
 
-func handleApiCustomQueue(w http.ResponseWriter, r *http.Request) {
-	// Get segment list from request, set discrepancy filter if the last segment is a specific aisle
-	sl := strings.Split(r.URL.Path, "/")
-	if len(sl) > 0 {
-		ls := sl[len(sl)-1]
-		if ls != "" {
-			id, _ := strconv.Atoi(ls)
-			q, err := FetchCustomQueue(id)
-			if err != nil {
-				log.Println(err)
-			}
+// ReorderQueue puts the missions of a site's queue in the order of the listed entries
+// Every entry of the site is listed once; the site keeps its entry numbers, reassigned in the new order.
+func ReorderQueue(siteId int, order []int) (err error) {
+	var tx *sql.Tx
+	if tx, err = db.Begin(); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
 
-			if err = jsonApi(w, r, q, false); err != nil {
-				log.Println(err)
-			}
+	var rows *sql.Rows
+	if rows, err = tx.Query(`select eventId, entry from events join regions using(regionId) where siteId = ? order by entry`, siteId); err != nil {
+		return
+	}
+	events := make(map[int]int)
+	var entries []int
+	for rows.Next() {
+		var eventId, entry int
+		if err = rows.Scan(&eventId, &entry); err != nil {
+			rows.Close()
+			return
+		}
+		events[entry] = eventId
+		entries = append(entries, entry)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
 
-		} else {
-			ql, err := FetchCustomQueueList()
-			if err != nil {
-				log.Println(err)
-			}
+	if len(order) != len(entries) {
+		return errBadRequest("order must list each of the %d queue entries once", len(entries))
+	}
+	for i, entry := range order {
+		eventId, ok := events[entry]
+		if !ok {
+			return errBadRequest("queue entry %d not found or listed twice", entry)
+		}
+		delete(events, entry)
+		if _, err = tx.Exec(`update events set entry = ? where eventId = ?`, entries[i], eventId); err != nil {
+			return
+		}
+	}
+	return tx.Commit()
+}
 
-			// Send filter inventory in json response
-			if err = jsonApi(w, r, ql, false); err != nil {
-				log.Println(err)
-			}
+// handleApiQueue is the endpoint for the mission queue restful api
+// accepts:
+//
+//	GET  /api/v1/queue
+//	GET  /api/v1/queue/:id
+//	POST /api/v1/queue       {"regionName": "region1"}
+//	PUT  /api/v1/queue       [3, 1, 2]
+//
+// Missions are planned across the site's fleet from the time of the request.
+func handleApiQueue(w http.ResponseWriter, r *http.Request) {
+	siteId := requestSite(r).Id
+
+	switch r.Method {
+	case http.MethodPost:
+		var q Queue
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			apiFail(w, r, errBadRequest("%v", err))
+			return
+		}
+		if q.Region == "" {
+			apiFail(w, r, errBadRequest("regionName is required"))
+			return
+		}
+		q, err := CreateQueueEntry(siteId, q.Region)
+		if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if err = jsonApi(w, r, q, false); err != nil {
+			log.Println(err)
+		}
+		return
+	case http.MethodPut:
+		var order []int
+		if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+			apiFail(w, r, errBadRequest("%v", err))
+			return
+		}
+		if err := ReorderQueue(siteId, order); err != nil {
+			apiFail(w, r, err)
+			return
 		}
 	}
+
+	// Fetch a single mission if the path names a queue entry
+	if entry := pathParam(r, "id"); entry != "" {
+		id, err := pathInt(entry, "queue entry")
+		if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		q, err := FetchQueue(siteId, id)
+		if err == sql.ErrNoRows {
+			apiFail(w, r, errNotFound("queue entry %d not found", id))
+			return
+		} else if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if err = jsonApi(w, r, q, false); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	ql, err := FetchQueueList(siteId, time.Now().UTC())
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+
+	// Send planned queue in json response
+	if err = jsonApi(w, r, ql, false); err != nil {
+		log.Println(err)
+	}
 }
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestQueueEntriesPerSite(t *testing.T) {
+	seedTestDb(t)
+	north, err := FetchSite("north")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(`insert into regions (name, frequency, siteId) values ('mezzanine', 1, ?)`, north.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// each site numbers its own entries, the sample warehouse queues 14 at the default site
+	for _, test := range []struct {
+		siteId int
+		region string
+		entry  int
+	}{
+		{north.Id, "mezzanine", 1},
+		{defaultSiteId, "region2", 15},
+		{north.Id, "mezzanine", 2},
+	} {
+		q, err := CreateQueueEntry(test.siteId, test.region)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Id != test.entry {
+			t.Errorf("site %d queued %s as entry %d, expected %d", test.siteId, test.region, q.Id, test.entry)
+		}
+	}
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSiteId is the site served by the unprefixed api routes and the html pages without a site parameter
+const defaultSiteId = 1
+
+// Site definition matches the sites table
+type Site struct {
+	Id   int    `xml:"id,attr" json:"id"`
+	Code string `xml:"code" json:"code"`
+	Name string `xml:"name" json:"name"`
+}
+
+type SiteList []Site
+
+// User definition matches the users table, the token is only returned when the user is created
+type User struct {
+	Id    int    `json:"id"`
+	Name  string `json:"name"`
+	Token string `json:"token,omitempty"`
+}
+
+// SitePermission grants a user a role at a site
+type SitePermission struct {
+	SiteId int    `json:"siteId"`
+	UserId int    `json:"userId"`
+	Role   string `json:"role"`
+}
+
+// SiteSummary totals the aisle statistics of a site
+type SiteSummary struct {
+	Site
+	Aisles                 int            `json:"aisles"`
+	NumberOccupied         int            `json:"numberOccupied"`
+	NumberEmpty            int            `json:"numberEmpty"`
+	NumberException        int            `json:"numberException"`
+	NumberUnscanned        int            `json:"numberUnscanned"`
+	NumberQuantityMismatch int            `json:"numberQuantityMismatch"`
+	LastScanned            string         `json:"lastScanned"`
+	AisleStats             AisleStatsList `json:"aisleStats"`
+}
+
+// siteKey is the request context key holding the Site of a site prefixed request
+type siteKey struct{}
+
+// requestSite returns the site a request is scoped to, the default site for unprefixed routes and pages without a site parameter
+func requestSite(r *http.Request) Site {
+	if s, ok := r.Context().Value(siteKey{}).(Site); ok {
+		return s
+	}
+	return Site{Id: defaultSiteId, Code: "default"}
+}
+
+// roleAllows reports whether a role permits the request method
+func roleAllows(role, method string) bool {
+	switch role {
+	case "admin", "editor":
+		return true
+	case "viewer":
+		return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+	}
+	return false
+}
+
+// validRole reports whether role is a known site role
+func validRole(role string) bool {
+	return role == "viewer" || role == "editor" || role == "admin"
+}
+
+// authEnabled reports whether any user exists, before which the api is open
+func authEnabled() (enabled bool, err error) {
+	err = db.QueryRow(`select exists (select 1 from users)`).Scan(&enabled)
+	return
+}
+
+// tokenHash returns the hex encoded sha256 of an api token, users store the hash rather than the token
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// requestToken returns the api token of a request, sent as a bearer token or by browsers as the basic auth password
+func requestToken(r *http.Request) string {
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// fetchTokenUser returns the user holding an api token, 0 when no user does
+// The token is hashed once and looked up on the unique index of the stored hashes.
+func fetchTokenUser(token string) (userId int, err error) {
+	defer observeQuery("fetchTokenUser", time.Now())
+	if token == "" {
+		return
+	}
+	if err = db.QueryRow(`select userId from users where tokenHash = ?`, tokenHash(token)).Scan(&userId); err == sql.ErrNoRows {
+		err = nil
+	}
+	return
+}
+
+// fetchUserRole returns the role a user holds at a site, blank when it holds none
+// Admins of the default site are admins of every site.
+func fetchUserRole(userId, siteId int) (role string, err error) {
+	err = db.QueryRow(`select role from siteUsers where userId = ? and (siteId = ? or (siteId = ? and role = 'admin'))
+		order by role = 'admin' desc limit 1`, userId, siteId, defaultSiteId).Scan(&role)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return
+}
+
+// authorize checks that the request may perform its method at a site with at least the given role
+// It writes the error response and returns false when the request is refused.
+func authorize(w http.ResponseWriter, r *http.Request, siteId int, admin bool) bool {
+	enabled, err := authEnabled()
+	if err != nil {
+		apiFail(w, r, err)
+		return false
+	}
+	if !enabled {
+		return true
+	}
+	userId, err := fetchTokenUser(requestToken(r))
+	if err != nil {
+		apiFail(w, r, err)
+		return false
+	}
+	if userId == 0 {
+		// browsers prompt for basic auth credentials on pages
+		w.Header().Add("WWW-Authenticate", `Bearer realm="cwms"`)
+		w.Header().Add("WWW-Authenticate", `Basic realm="cwms"`)
+		apiFail(w, r, errUnauthorized("a valid bearer token is required"))
+		return false
+	}
+	role, err := fetchUserRole(userId, siteId)
+	if err != nil {
+		apiFail(w, r, err)
+		return false
+	}
+	if !roleAllows(role, r.Method) || (admin && role != "admin") {
+		apiFail(w, r, errForbidden("%s requires a role this user does not hold at the site", r.Method))
+		return false
+	}
+	return true
+}
+
+// smw site middleware checks the caller's permission at the request's site
+func smw(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authorize(w, r, requestSite(r).Id, false) {
+			next(w, r)
+		}
+	}
+}
+
+// pmw page middleware scopes a page, map, export or image request to the site of its site url parameter,
+// the default site without one, and checks the caller's permission there
+func pmw(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if code := r.URL.Query().Get("site"); code != "" {
+			s, err := FetchSite(code)
+			if err == sql.ErrNoRows {
+				apiFail(w, r, errNotFound("site %q not found", code))
+				return
+			} else if err != nil {
+				apiFail(w, r, err)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), siteKey{}, s))
+		}
+		smw(next)(w, r)
+	}
+}
+
+// siteQuery returns the url query that keeps page links within the request's site, blank for the default site
+func siteQuery(r *http.Request) string {
+	if s := requestSite(r); s.Id != defaultSiteId {
+		return "?site=" + url.QueryEscape(s.Code)
+	}
+	return ""
+}
+
+// FetchSite returns the site with the given code, or numeric id
+func FetchSite(code string) (s Site, err error) {
+	defer observeQuery("FetchSite", time.Now())
+	err = db.QueryRow(`select siteId, code, IFNULL(name, "") from sites where code = ? or cast(siteId as text) = ?`, code, code).
+		Scan(&s.Id, &s.Code, &s.Name)
+	return
+}
+
+// FetchSites performs a query on sites and returns the results in a SiteList
+func FetchSites() (sl SiteList, err error) {
+	defer observeQuery("FetchSites", time.Now())
+	var rows *sql.Rows
+	if rows, err = db.Query(`select siteId, code, IFNULL(name, "") from sites order by siteId`); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var s Site
+	for rows.Next() {
+		if err = rows.Scan(StructForScan(&s)...); err != nil {
+			return
+		}
+		sl = append(sl, s)
+	}
+	err = rows.Err()
+	return
+}
+
+// reservedSiteCodes are the site codes taken by the site routes, /sites/summary totals every site
+var reservedSiteCodes = []string{"summary"}
+
+// validate checks the code of a new site, which addresses the site beside its numeric id
+func (s Site) validate() error {
+	if s.Code == "" {
+		return fmt.Errorf("code is required")
+	}
+	if _, err := strconv.Atoi(s.Code); err == nil {
+		return fmt.Errorf("code must not be numeric")
+	}
+	for _, code := range reservedSiteCodes {
+		if strings.EqualFold(s.Code, code) {
+			return fmt.Errorf("code %q is reserved", s.Code)
+		}
+	}
+	return nil
+}
+
+// CreateSite stores a new site
+func CreateSite(s Site) (Site, error) {
+	res, err := db.Exec(`insert into sites (code, name) values (?, ?)`, s.Code, s.Name)
+	if err != nil {
+		return s, err
+	}
+	id, err := res.LastInsertId()
+	s.Id = int(id)
+	return s, err
+}
+
+// CreateUser stores a new user with a random api token, of which only the hash is kept
+// The first user is made admin of the default site so that permissions can be granted.
+func CreateUser(name string) (u User, err error) {
+	b := make([]byte, 24)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	u = User{Name: name, Token: hex.EncodeToString(b)}
+
+	var tx *sql.Tx
+	if tx, err = db.Begin(); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var res sql.Result
+	if res, err = tx.Exec(`insert into users (name, tokenHash) values (?, ?)`, u.Name, tokenHash(u.Token)); err != nil {
+		return
+	}
+	var id int64
+	if id, err = res.LastInsertId(); err != nil {
+		return
+	}
+	u.Id = int(id)
+	if _, err = tx.Exec(`insert into siteUsers (siteId, userId, role) select ?, ?, 'admin' where (select count(*) from users) = 1`, defaultSiteId, u.Id); err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+// SetPermission grants a user a role at a site, an empty role revokes access
+func SetPermission(sp SitePermission) (err error) {
+	if sp.Role == "" {
+		_, err = db.Exec(`delete from siteUsers where siteId = ? and userId = ?`, sp.SiteId, sp.UserId)
+		return
+	}
+	_, err = db.Exec(`insert or replace into siteUsers (siteId, userId, role) values (?, ?, ?)`, sp.SiteId, sp.UserId, sp.Role)
+	return
+}
+
+// FetchPermissions returns the users granted access to a site
+func FetchPermissions(siteId int) (spl []SitePermission, err error) {
+	defer observeQuery("FetchPermissions", time.Now())
+	var rows *sql.Rows
+	if rows, err = db.Query(`select siteId, userId, role from siteUsers where siteId = ? order by userId`, siteId); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var sp SitePermission
+	for rows.Next() {
+		if err = rows.Scan(StructForScan(&sp)...); err != nil {
+			return
+		}
+		spl = append(spl, sp)
+	}
+	err = rows.Err()
+	return
+}
+
+// visibleSites filters a site list to the sites the request may view
+// The request's token is looked up once, then its role at each site.
+func visibleSites(r *http.Request, sl SiteList) (vl SiteList, err error) {
+	var enabled bool
+	if enabled, err = authEnabled(); err != nil || !enabled {
+		return sl, err
+	}
+	var userId int
+	if userId, err = fetchTokenUser(requestToken(r)); err != nil || userId == 0 {
+		return
+	}
+	for _, s := range sl {
+		var role string
+		if role, err = fetchUserRole(userId, s.Id); err != nil {
+			return
+		}
+		if role != "" {
+			vl = append(vl, s)
+		}
+	}
+	return
+}
+
+// FetchSiteSummaries totals the aisle statistics of each site
+func FetchSiteSummaries(sl SiteList) (ssl []SiteSummary, err error) {
+	defer observeQuery("FetchSiteSummaries", time.Now())
+	for _, s := range sl {
+		ss := SiteSummary{Site: s}
+		if ss.AisleStats, err = fetchAisleStats(s.Id); err != nil {
+			return
+		}
+		for _, as := range ss.AisleStats {
+			ss.Aisles++
+			ss.NumberOccupied += as.NumberOccupied
+			ss.NumberEmpty += as.NumberEmpty
+			ss.NumberException += as.NumberException
+			ss.NumberUnscanned += as.NumberUnscanned
+			ss.NumberQuantityMismatch += as.NumberQuantityMismatch
+			if as.LastScanned > ss.LastScanned {
+				ss.LastScanned = as.LastScanned
+			}
+		}
+		ssl = append(ssl, ss)
+	}
+	return
+}
+
+// handleApiUsers is the endpoint for api users
+// accepts:
+//
+//	POST /api/v1/users   {"name": "ops"}
+//
+// Creating users requires the admin role at the default site once any user exists.
+func handleApiUsers(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, defaultSiteId, true) {
+		return
+	}
+	var u User
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil || u.Name == "" {
+		apiFail(w, r, errBadRequest("name is required"))
+		return
+	}
+	u, err := CreateUser(u.Name)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if err = jsonApi(w, r, u, false); err != nil {
+		log.Println(err)
+	}
+}
+
+// handleSitePermissions manages the users granted access to a site
+// accepts:
+//
+//	GET    /api/v1/sites/:site/permissions
+//	POST   /api/v1/sites/:site/permissions   {"userId": 2, "role": "viewer"}
+//	DELETE /api/v1/sites/:site/permissions   {"userId": 2}
+//
+// A site is addressed by its code or id.
+func handleSitePermissions(w http.ResponseWriter, r *http.Request) {
+	s, err := FetchSite(pathParam(r, "site"))
+	if err == sql.ErrNoRows {
+		apiFail(w, r, errNotFound("site %q not found", pathParam(r, "site")))
+		return
+	} else if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if !authorize(w, r, s.Id, r.Method != http.MethodGet) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		spl, err := FetchPermissions(s.Id)
+		if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if err = jsonApi(w, r, spl, false); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	var sp SitePermission
+	if err := json.NewDecoder(r.Body).Decode(&sp); err != nil {
+		apiFail(w, r, errBadRequest("invalid permission: %v", err))
+		return
+	}
+	sp.SiteId = s.Id
+	if r.Method == http.MethodDelete {
+		sp.Role = ""
+	} else if !validRole(sp.Role) {
+		apiFail(w, r, errBadRequest("role must be viewer, editor or admin"))
+		return
+	}
+	if err := SetPermission(sp); err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if err := jsonApi(w, r, sp, false); err != nil {
+		log.Println(err)
+	}
+}
+
+// handleApiSites is the endpoint for the sites the caller can see
+// accepts:
+//
+//	GET  /api/v1/sites
+//	POST /api/v1/sites            {"code": "north", "name": "North DC"}
+//
+// Site scoped routes are served under /api/v1/sites/:site/ by the api router.
+func handleApiSites(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if !authorize(w, r, defaultSiteId, true) {
+			return
+		}
+		var s Site
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			apiFail(w, r, errBadRequest("invalid site: %v", err))
+			return
+		}
+		if err := s.validate(); err != nil {
+			apiFail(w, r, errBadRequest("%v", err))
+			return
+		}
+		s, err := CreateSite(s)
+		if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if err = jsonApi(w, r, s, false); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	sites, err := FetchSites()
+	if err == nil {
+		sites, err = visibleSites(r, sites)
+	}
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if err = jsonApi(w, r, sites, false); err != nil {
+		log.Println(err)
+	}
+}
+
+// handleApiSiteSummaries totals the aisle statistics of each site the caller can see
+// accepts:
+//
+//	GET /api/v1/sites/summary
+func handleApiSiteSummaries(w http.ResponseWriter, r *http.Request) {
+	sites, err := FetchSites()
+	if err == nil {
+		sites, err = visibleSites(r, sites)
+	}
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	ssl, err := FetchSiteSummaries(sites)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	if err = jsonApi(w, r, ssl, false); err != nil {
+		log.Println(err)
+	}
+}
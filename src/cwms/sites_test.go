@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMigrateTokenHashes(t *testing.T) {
+	openTestDb(t)
+	if _, err := MigrateUp(13); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`insert into users (name, token) values ('ops', 'secret')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+	userId, err := fetchTokenUser("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userId != 1 {
+		t.Errorf("token of user %d after hashing, expected user 1", userId)
+	}
+}
+
+func TestPageAuthorization(t *testing.T) {
+	seedTestDb(t)
+	// the sample warehouse has a second site
+	north, err := FetchSite("north")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := CreateUser("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	viewer, err := CreateUser("viewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = SetPermission(SitePermission{SiteId: north.Id, UserId: viewer.Id, Role: "viewer"}); err != nil {
+		t.Fatal(err)
+	}
+	var stored int
+	if err = db.QueryRow(`select count(*) from users where tokenHash in (?, ?)`, admin.Token, viewer.Token).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 0 {
+		t.Error("tokens are stored in plaintext")
+	}
+
+	h := pmw(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(requestSite(r).Code))
+	})
+	for _, test := range []struct {
+		name, url, bearer, password string
+		status                      int
+		site                        string
+	}{
+		{"no token", "/dashboard/", "", "", http.StatusUnauthorized, ""},
+		{"unknown token", "/dashboard/", "nosuch", "", http.StatusUnauthorized, ""},
+		{"admin", "/dashboard/", admin.Token, "", http.StatusOK, "default"},
+		{"admin of every site", "/dashboard/?site=north", admin.Token, "", http.StatusOK, "north"},
+		{"basic auth", "/dashboard/?site=north", "", admin.Token, http.StatusOK, "north"},
+		{"viewer without a role", "/dashboard/", viewer.Token, "", http.StatusForbidden, ""},
+		{"viewer", "/map/?site=north", viewer.Token, "", http.StatusOK, "north"},
+		{"unknown site", "/map/?site=south", admin.Token, "", http.StatusNotFound, ""},
+	} {
+		r := httptest.NewRequest(http.MethodGet, test.url, nil)
+		if test.bearer != "" {
+			r.Header.Set("Authorization", "Bearer "+test.bearer)
+		}
+		if test.password != "" {
+			r.SetBasicAuth("user", test.password)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: status %d, expected %d", test.name, w.Code, test.status)
+		} else if test.site != "" && w.Body.String() != test.site {
+			t.Errorf("%s: served site %q, expected %q", test.name, w.Body.String(), test.site)
+		}
+	}
+}
+
+func TestTokenLookup(t *testing.T) {
+	seedTestDb(t)
+	// the first user is the admin of every site
+	if _, err := CreateUser("admin"); err != nil {
+		t.Fatal(err)
+	}
+	u, err := CreateUser("ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userId, err := fetchTokenUser(u.Token); err != nil || userId != u.Id {
+		t.Errorf("token of user %d, expected user %d: %v", userId, u.Id, err)
+	}
+	if userId, err := fetchTokenUser(u.Token + "0"); err != nil || userId != 0 {
+		t.Errorf("unknown token of user %d, expected none: %v", userId, err)
+	}
+
+	// the hash is looked up on an index rather than by scanning the users
+	var id, parent, notused int
+	var detail string
+	if err = db.QueryRow(`explain query plan select userId from users where tokenHash = ?`, tokenHash(u.Token)).Scan(&id, &parent, &notused, &detail); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(detail, "INDEX") {
+		t.Errorf("token lookup plan %q, expected an index search", detail)
+	}
+
+	if err = SetPermission(SitePermission{SiteId: 2, UserId: u.Id, Role: "viewer"}); err != nil {
+		t.Fatal(err)
+	}
+	sl, err := FetchSites()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/sites", nil)
+	r.Header.Set("Authorization", "Bearer "+u.Token)
+	before := queryCount("fetchTokenUser")
+	vl, err := visibleSites(r, sl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vl) != 1 || vl[0].Id != 2 {
+		t.Errorf("visible sites %+v, expected the site of the viewer", vl)
+	}
+	if n := queryCount("fetchTokenUser") - before; n != 1 {
+		t.Errorf("token looked up %d times for %d sites, expected once", n, len(sl))
+	}
+}
+
+func TestSiteValidation(t *testing.T) {
+	for _, test := range []struct {
+		code  string
+		valid bool
+	}{
+		{"south", true},
+		{"", false},
+		{"42", false},
+		{"summary", false},
+		{"Summary", false},
+		{"summary-east", true},
+	} {
+		if err := (Site{Code: test.code}).validate(); (err == nil) != test.valid {
+			t.Errorf("site code %q: %v, expected valid %v", test.code, err, test.valid)
+		}
+	}
+}
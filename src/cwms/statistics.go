@@ -3,27 +3,30 @@ package main
 import (
 	"log"
 	"net/http"
+	"time"
+
+	"cwms/api"
 )
 
 // restriction definition matches database table
 // xml and json reflection tags determine how the restrictions appear the response
-type Statistics struct {
-	Except30 []int `json:"exceptionsLast30Days"`
-}
+type Statistics = api.Statistics
 
 // FetchRestrictions performs a query on restrictions and returns the results in a RestrictionList.
 func FetchStatistics() (s Statistics, err error) {
+	defer observeQuery("FetchStatistics", time.Now())
 	s.Except30 = []int{0, 0, 0, 1, 2, 3, 2, 0, 1, 0, 2, 3, 2, 3, 2, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
 	return
 }
 
 // handleApiStatistics is the endpoint for statistics restful api
 // accepts:
-//  /api/statistics
+//
+//	GET /api/v1/statistics
 func handleApiStatistics(w http.ResponseWriter, r *http.Request) {
 	// Fetch Statistics
 	if s, err := FetchStatistics(); err != nil {
-		log.Println(err)
+		apiFail(w, r, err)
 	} else if err = jsonApi(w, r, s, false); err != nil {
 		log.Println(err)
 	}
@@ -1,6 +1,6 @@
 package main
 
-// Stats contains a set of statistics derived from v_inventory view
+// Stats contains a set of statistics derived from the aisle summaries and positions
 type Stats struct {
 	TotalSkus   int
 	SkuIssues   int
@@ -9,24 +9,19 @@ type Stats struct {
 	TotalSlots  int
 }
 
-// fetchStats performs various queries on v_inventory and returns the results in Stats
-func fetchStats() (stats Stats, err error) {
-	err = db.QueryRow(`select count(1) from v_inventory`).Scan(&stats.TotalSkus)
+// fetchStats performs various queries on aisleSummaries and positions and returns the results for a site in Stats
+func fetchStats(siteId int) (stats Stats, err error) {
+	err = db.QueryRow(`select IFNULL(sum(numberRecords), 0), IFNULL(sum(numberException), 0) from aisleSummaries where siteId = ?`, siteId).Scan(&stats.TotalSkus, &stats.SkuIssues)
 	if err != nil {
 		return
 	}
 
-	err = db.QueryRow(`select count(1) from v_inventory where discrepancy !=""`).Scan(&stats.SkuIssues)
+	err = db.QueryRow(`select count (1) from positions where siteId = ?`, siteId).Scan(&stats.TotalSlots)
 	if err != nil {
 		return
 	}
 
-	err = db.QueryRow(`select count (1) from positions`).Scan(&stats.TotalSlots)
-	if err != nil {
-		return
-	}
-
-	err = db.QueryRow(`select count (1) from positions`).Scan(&stats.FilledSlots)
+	err = db.QueryRow(`select count (1) from positions where siteId = ?`, siteId).Scan(&stats.FilledSlots)
 	if err != nil {
 		return
 	}
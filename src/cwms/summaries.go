@@ -0,0 +1,108 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// summaryColumns are the aggregate columns shared by aisleSummaries and regionSummaries
+const summaryColumns = `numberRecords, numberException, numberEmpty, numberOccupied, numberUnscanned, numberQuantityMismatch, expectedQuantity, lastScanned`
+
+// summaryAggregates computes summaryColumns over v_inventory rows, as v_aisleStats does
+const summaryAggregates = `count(*) as numberRecords,
+	sum(case when discrepancy != "" then 1 else 0 end) as numberException,
+	sum(case when sku = "empty" then 1 else 0 end) as numberEmpty,
+	sum(case when sku != "empty" and sku is not null then 1 else 0 end) as numberOccupied,
+	sum(case when sku is null then 1 else 0 end) as numberUnscanned,
+	sum(case when discrepancy = 'quantity' then 1 else 0 end) as numberQuantityMismatch,
+	sum(quantity) as expectedQuantity,
+	max(stopTime) as lastScanned`
+
+// aisleSummarySelect computes aisleSummaries rows, the %s verb extends its where clause
+const aisleSummarySelect = `select siteId, IFNULL(aisle, '') as aisle, ` + summaryAggregates + `
+	from v_inventory where siteId is not null%s group by siteId, IFNULL(aisle, '')`
+
+// regionSummarySelect computes regionSummaries rows, the %s verb adds a where clause
+const regionSummarySelect = `select regionId, regions.siteId as siteId, ` + summaryAggregates + `
+	from v_inventory join regionPositions using(positionId) join regions using(regionId)%s group by regionId`
+
+// changedAisles selects the site and aisle of the positions in the json array parameter ?1
+const changedAisles = `select siteId, IFNULL(json_extract(json_position, '$.aisle'), '') from positions where positionId in (select value from json_each(?1))`
+
+// changedRegions selects the regions holding the positions in the json array parameter ?1
+const changedRegions = `select regionId from regionPositions where positionId in (select value from json_each(?1))`
+
+// refreshSummaryStmts recompute the summaries of the aisles and regions holding the changed positions
+var refreshSummaryStmts = []string{
+	`delete from aisleSummaries where (siteId, aisle) in (` + changedAisles + `)`,
+	`insert into aisleSummaries (siteId, aisle, ` + summaryColumns + `) ` + fmt.Sprintf(aisleSummarySelect,
+		` and positionId in (select positionId from positions where (siteId, IFNULL(json_extract(json_position, '$.aisle'), '')) in (`+changedAisles+`))`),
+	`delete from regionSummaries where regionId in (` + changedRegions + `)`,
+	`insert into regionSummaries (regionId, siteId, ` + summaryColumns + `) ` + fmt.Sprintf(regionSummarySelect, ` where regionId in (`+changedRegions+`)`),
+}
+
+// rebuildSummaryStmts recompute every summary
+var rebuildSummaryStmts = []string{
+	`delete from aisleSummaries`,
+	`insert into aisleSummaries (siteId, aisle, ` + summaryColumns + `) ` + fmt.Sprintf(aisleSummarySelect, ""),
+	`delete from regionSummaries`,
+	`insert into regionSummaries (regionId, siteId, ` + summaryColumns + `) ` + fmt.Sprintf(regionSummarySelect, ""),
+}
+
+// refreshSummaries recomputes the summaries of the aisles and regions holding the positions,
+// in the transaction that changed their inventory or discrepancies.
+// Region membership and position edits are refreshed by the triggers of migration 0017.
+func refreshSummaries(tx *sql.Tx, positionIds []int) (err error) {
+	defer observeQuery("refreshSummaries", time.Now())
+	if len(positionIds) == 0 {
+		return
+	}
+	var ids []byte
+	if ids, err = json.Marshal(positionIds); err != nil {
+		return
+	}
+	for _, stmt := range refreshSummaryStmts {
+		if _, err = tx.Exec(stmt, string(ids)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// rebuildSummaries recomputes every aisle and region summary in the transaction
+func rebuildSummaries(tx *sql.Tx) (err error) {
+	for _, stmt := range rebuildSummaryStmts {
+		if _, err = tx.Exec(stmt); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// RebuildSummaries recomputes every aisle and region summary from the inventory
+func RebuildSummaries() (err error) {
+	defer observeQuery("RebuildSummaries", time.Now())
+	var tx *sql.Tx
+	if tx, err = db.Begin(); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	if err = rebuildSummaries(tx); err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+// staleSummaries selects a description of each summary row that differs from its recomputation by sel, or is missing from either
+func staleSummaries(table, keys, sel, describe string) string {
+	computed := fmt.Sprintf(sel, "")
+	stored := `select ` + keys + `, ` + summaryColumns + ` from ` + table
+	return `select distinct ` + describe + ` from (select * from (` + computed + ` except ` + stored + `)
+		union all select * from (` + stored + ` except ` + computed + `)) order by 1`
+}
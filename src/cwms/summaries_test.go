@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+// staleSummaryFindings runs the doctor checks comparing the stored summaries with their recomputation
+func staleSummaryFindings(t *testing.T) (findings []string) {
+	t.Helper()
+	checks := map[string]bool{"stale aisle summaries, run cwms rebuild": false, "stale region summaries, run cwms rebuild": false}
+	for _, dc := range doctorChecks {
+		if _, ok := checks[dc.Name]; !ok {
+			continue
+		}
+		checks[dc.Name] = true
+		f, err := dc.run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		findings = append(findings, f...)
+	}
+	for name, found := range checks {
+		if !found {
+			t.Fatalf("no doctor check %q", name)
+		}
+	}
+	return
+}
+
+func TestSummaryMembership(t *testing.T) {
+	seedTestDb(t)
+	regionRecords := func(regionId int) (n int) {
+		t.Helper()
+		if err := db.QueryRow(`select IFNULL((select numberRecords from regionSummaries where regionId = ?), 0)`, regionId).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	aisleRecords := func(aisle string) (n int) {
+		t.Helper()
+		if err := db.QueryRow(`select IFNULL((select numberRecords from aisleSummaries where siteId = 1 and aisle = ?), 0)`, aisle).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	before, aisleBefore := regionRecords(1), aisleRecords("1a")
+
+	for _, test := range []struct {
+		name, stmt string
+		check      func() bool
+	}{
+		{"position joins a region", `insert into regionPositions (regionId, positionId) values (1, 14)`,
+			func() bool { return regionRecords(1) > before }},
+		{"position moves region", `update regionPositions set regionId = 2 where regionId = 1 and positionId = 14`,
+			func() bool { return regionRecords(1) == before }},
+		{"region is emptied", `delete from regionPositions where regionId = 2`,
+			func() bool { return regionRecords(2) == 0 }},
+		{"position moves aisle", `update positions set json_position = json_set(json_position, '$.aisle', '9z') where positionId = 1`,
+			func() bool { return aisleRecords("1a") < aisleBefore && aisleRecords("9z") > 0 }},
+		{"position is removed", `delete from positions where positionId = 2`,
+			func() bool { return aisleRecords("1a") < aisleBefore-2 }},
+	} {
+		if _, err := db.Exec(test.stmt); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if f := staleSummaryFindings(t); len(f) > 0 {
+			t.Errorf("%s: stale summaries %q", test.name, f)
+		}
+		if !test.check() {
+			t.Errorf("%s: summaries not refreshed", test.name)
+		}
+	}
+}
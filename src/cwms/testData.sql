@@ -130,9 +130,16 @@ insert into restrictions (name, startDate, stopDate, startTime, stopTime, period
 insert into restrictions (name, startDate, stopDate, startTime, stopTime, periodicityNum, periodicity, regionId) values ("area57", "2020-04-04", "2020-04-05", "10:00", "13:00", 3, "daily", 4);
 insert into restrictions (name, startDate, stopDate, startTime, stopTime, periodicityNum, periodicity, regionId) values ("breezeway", "2020-04-04", "2020-04-05", "10:00", "13:00", 2, "daily", 5);
 
-insert into flights (time) values ("10:00");
-insert into flights (time) values ("10:01");
-insert into flights (time) values ("10:02");
+insert into drones (name, capabilities, homeDock, status, batteryLevel, lastSeen) values ("corvus-1", "barcode,photo,count", "dock-a", "charging", 50, "2020-04-04 19:22:45.007");
+insert into drones (name, capabilities, homeDock, status, batteryLevel, lastSeen) values ("corvus-2", "barcode,photo", "dock-b", "idle", 100, "2020-04-04 19:22:45.007");
+update regions set requires = "count" where name = "region4";
+
+insert into flights (time, droneId) values ("10:00", 1);
+insert into flights (time, droneId) values ("10:01", 2);
+insert into flights (time, droneId) values ("10:02", 1);
+
+insert into telemetry (droneId, flightId, time, status, batteryLevel, aisle) values (1, 3, "2020-04-04 10:02:00.000", "flying", 80, "1b");
+insert into telemetry (droneId, flightId, time, status, batteryLevel, aisle) values (1, null, "2020-04-04 10:20:00.000", "charging", 50, null);
 
 insert into flightPositions (flightId, positionId, sku, occupancy) values (1, 1, "000SKU005", "12.1");
 insert into flightPositions (flightId, positionId, sku, occupancy) values (1, 2, "000SKU006", "12.2");
@@ -142,4 +149,37 @@ insert into flightPositions (flightId, positionId, sku, occupancy) values (2, 5,
 insert into flightPositions (flightId, positionId, sku, occupancy) values (2, 6, "000SKU010", "13.3");
 insert into flightPositions (flightId, positionId, sku, occupancy) values (3, 7, "000SKU011", "14.1");
 insert into flightPositions (flightId, positionId, sku, occupancy) values (3, 8, "000SKU012", "14.2");
-insert into flightPositions (flightId, positionId, sku, occupancy) values (3, 9, "000SKU013", "14.3");
\ No newline at end of file
+insert into flightPositions (flightId, positionId, sku, occupancy) values (3, 9, "000SKU013", "14.3");
+insert into flightPositions (flightId, positionId, sku, occupancy, barcode, gtin, lot, expiry) values (3, 24, "09501101530003", "14.4", "]C101095011015300031725123110LOT42", "09501101530003", "LOT42", "2025-12-31");
+
+-- gs1 identifiers
+update items set gtin = "09501101530003", lot = "LOT41", expiry = "2020-05-31", quantity = 12 where sku = "000SKU010";
+
+-- lots and expiry
+update items set lot = "A100", expiry = "2020-04-20", quantity = 40 where sku = "000SKU001";
+update items set lot = "B200", expiry = "2021-01-31", quantity = 24 where sku = "000SKU003";
+update items set lot = "C300", quantity = 6 where sku = "000SKU006";
+
+-- quantity tolerances
+insert into quantityTolerances (sku, aisle, absolute, percent) values (null, null, 0, 0);
+insert into quantityTolerances (sku, aisle, absolute, percent) values (null, "2b", 1, 0);
+insert into quantityTolerances (sku, aisle, absolute, percent) values ("000SKU001", null, 2, 10);
+
+-- export templates
+insert into exportTemplates (siteId, name, fields, dateFormat, delimiter) values (1, "acme", '[{"field":"sku","label":"Item Code"},{"field":"aisle","label":"Aisle"},{"field":"stopTime","label":"Scanned"},{"field":"expiry","label":"Best Before"},{"field":"quantity","label":"Qty"}]', "02/01/2006", ";");
+
+-- report schedules
+insert into reportSchedules (siteId, name, report, format, filters, cron, delivery, destination, nextRun) values (1, "daily-inventory", "inventory", "csv", "template=acme", "0 6 * * *", "folder", "acme", "2021-04-20T06:00:00Z");
+insert into reportSchedules (siteId, name, report, format, filters, cron, delivery, destination, enabled, nextRun) values (1, "weekly-discrepancies", "discrepancy", "xlsx", "sheets=aisle", "0 7 * * 1", "smtp", "ops@example.com", 0, "2021-04-26T07:00:00Z");
+
+-- second site
+insert into sites (code, name) values ("north", "North DC");
+insert into positions (json_position, siteId) values ('{"aisle":"n1", "block":"1", "slot":"1"}', 2);
+insert into positions (json_position, siteId) values ('{"aisle":"n1", "block":"1", "slot":"2"}', 2);
+insert into items (sku, discrepancy) values ("N-SKU001", "");
+insert into items (sku, discrepancy) values ("N-SKU002", "missing");
+insert into inventory (startTime, stopTime, itemId, positionId) values ("2020-04-05 08:00:00.000", "2020-04-05 08:00:00.000", 13, 31);
+insert into inventory (startTime, stopTime, itemId, positionId) values ("2020-04-05 08:00:00.000", "2020-04-05 08:00:00.000", 14, 32);
+insert into drones (name, capabilities, homeDock, status, batteryLevel, siteId) values ("north-1", "barcode", "dock-n", "idle", 100, 2);
+insert into flights (time, siteId, droneId) values ("08:00", 2, 3);
+insert into flightPositions (flightId, positionId, sku, occupancy) values (4, 31, "N-SKU001", "1.0");
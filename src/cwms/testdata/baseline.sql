@@ -0,0 +1,340 @@
+-- The schema and sample warehouse of the baseline release, before schema migrations
+PRAGMA foreign_keys = ON;
+
+
+DROP VIEW IF EXISTS v_schedule;
+DROP VIEW IF EXISTS v_regionPosition;
+DROP VIEW IF EXISTS v_aisleStats;
+DROP VIEW IF EXISTS v_inventory;
+DROP VIEW IF EXISTS v_restrictions;
+DROP VIEW IF EXISTS v_flightList;
+DROP TABLE IF EXISTS inventory;
+DROP TABLE IF EXISTS events;
+DROP TABLE IF EXISTS restrictions;
+DROP TABLE IF EXISTS positions;
+DROP TABLE IF EXISTS regionPositions;
+DROP TABLE IF EXISTS flightPositions;
+DROP TABLE IF EXISTS regions;
+DROP TABLE IF EXISTS flights;
+DROP TABLE IF EXISTS items;
+
+
+CREATE TABLE IF NOT EXISTS positions (
+  positionId INTEGER PRIMARY KEY AUTOINCREMENT,
+  json_position TEXT
+);
+-- Positions are stored in json e.g. {"Aisle":"1a","Shelf":"1","Slot":"1"}
+-- https://www.sqlite.org/json1.html#jex
+-- https://community.esri.com/groups/appstudio/blog/2018/08/21/working-with-json-in-sqlite-databases
+DROP INDEX IF EXISTS idx_aisle;
+CREATE INDEX idx_aisle ON positions (json_extract(json_position, '$.aisle'));
+
+CREATE TABLE IF NOT EXISTS items (
+  itemId INTEGER PRIMARY KEY AUTOINCREMENT,
+  sku TEXT,
+  discrepancy TEXT
+);
+DROP INDEX IF EXISTS idx_sku;
+CREATE INDEX idx_sku ON items (sku);
+DROP INDEX IF EXISTS idx_discrepancy;
+CREATE INDEX idx_discrepancy ON items (discrepancy);
+
+CREATE TABLE IF NOT EXISTS images (
+  imageId INTEGER PRIMARY KEY AUTOINCREMENT,
+  imageUrl TEXT
+);
+
+CREATE TABLE IF NOT EXISTS inventory (
+  inventoryId INTEGER PRIMARY KEY AUTOINCREMENT,
+  startTime DATETIME,
+  stopTime DATETIME,
+  itemId INTEGER REFERENCES items(itemId),
+  positionId INTEGER REFERENCES positions(positionId),
+  imageId INTEGER REFERENCES images(imageId)
+);
+-- Timestamps are stored using unix timestamps
+-- number of seconds that have passed since midnight on the 1st January 1970, UTC time
+-- https://www.sqlite.org/lang_datefunc.html
+-- https://www.sqlite.org/draft/datatype3.html
+
+CREATE VIEW v_inventory
+  AS SELECT
+    inventoryId,
+    startTime,
+    stopTime,
+    items.sku AS sku,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot,
+    json_extract(positions.json_position, "$.shelf") AS shelf,
+    json_extract(positions.json_position, "$.displayname") AS displayName,
+    items.discrepancy AS discrepancy,
+    images.imageUrl AS imageUrl
+  FROM
+    inventory
+    LEFT JOIN positions USING(positionId)
+    LEFT JOIN items USING(itemId)
+    LEFT JOIN images USING(imageId);
+
+CREATE VIEW IF NOT EXISTS v_aisleStats
+  AS SELECT
+    aisle,
+    sum(case when discrepancy != "" then 1 else 0 end) as numberException,
+    sum(case when sku = "empty" then 1 else 0 end) as numberEmpty,
+    sum(case when sku != "empty" and sku is not null then 1 else 0 end) as numberOccupied,
+    sum(case when sku is null then 1 else 0 end) as numberUnscanned,
+    max(stopTime) as lastScanned -- TODO: might not be right
+  FROM
+    v_inventory
+  GROUP BY
+    aisle;
+
+CREATE TABLE IF NOT EXISTS regions (
+  regionId INTEGER PRIMARY KEY AUTOINCREMENT,
+  name string,
+  frequency int
+);
+
+CREATE TABLE IF NOT EXISTS regionPositions (
+  rpId INTEGER PRIMARY KEY AUTOINCREMENT,
+  name string,
+  regionId INTEGER REFERENCES regions(regionId),
+  positionId INTEGER REFERENCES positions(positionId)
+);
+
+CREATE VIEW IF NOT EXISTS v_regionPosition
+  AS
+  SELECT
+    regionId AS regionId,
+    json_extract(positions.json_position, "$.aisle") AS aisle
+  FROM
+    regions
+    LEFT JOIN regionPositions USING(regionId)
+    LEFT JOIN positions USING(positionId);
+
+
+CREATE TABLE IF NOT EXISTS events (
+  eventId INTEGER PRIMARY KEY AUTOINCREMENT,
+  name string,
+  queue string,
+  entry int,
+  regionId INTEGER REFERENCES regions(regionId)
+);
+
+CREATE TABLE IF NOT EXISTS restrictions (
+  restrictionId INTEGER PRIMARY KEY AUTOINCREMENT,
+  name string,
+  startDate DATETIME,
+  stopDate  DATETIME,
+  startTime DATETIME,
+  stopTime  DATETIME,
+  periodicityNum int,
+  periodicity string,
+  regionId INTEGER REFERENCES regions(regionId)
+  -- CHECK (periodicity IN ('weekdays','weekends','everyday','monday','tuesday','wednesday','thursday','friday','saturday','sunday'))
+);
+
+CREATE VIEW IF NOT EXISTS v_restrictions
+  AS SELECT
+    restrictionId AS restrictionId,
+    startDate DATETIME,
+    stopDate  DATETIME,
+    json_extract(positions.json_position, "$.aisle") AS aisle
+  FROM
+    restrictions
+    LEFT JOIN regions USING(regionId)
+    LEFT JOIN regionPositions USING(regionId)
+    LEFT JOIN positions USING(positionId);
+
+CREATE VIEW IF NOT EXISTS v_schedule
+  AS SELECT
+    entry AS entry,
+    queue AS queue,
+    regions.name AS region,
+    regions.frequency AS frequency,
+    restrictions.name AS restriction,
+    restrictions.startTime AS startTime,
+    restrictions.stopTime AS stopTime,
+    restrictions.periodicity AS periodicity,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot
+  FROM
+    events
+    LEFT JOIN regions USING(regionId)
+    LEFT JOIN regionPositions USING(regionId)
+    LEFT JOIN restrictions USING(regionId)
+    LEFT JOIN positions USING(positionId);
+
+CREATE TABLE IF NOT EXISTS flights (
+  flightId  INTEGER PRIMARY KEY AUTOINCREMENT,
+  time DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS flightPositions (
+  fpId  INTEGER PRIMARY KEY AUTOINCREMENT,
+  sku text,
+  occupancy text,
+  flightId INTEGER REFERENCES flights(flightId),
+  positionId INTEGER REFERENCES positions(positionId)
+);
+
+CREATE VIEW v_flightList
+  AS SELECT
+    flightId AS flightId,
+    time(time) AS time,
+    flightPositions.sku AS sku,
+    flightPositions.occupancy AS occupancy,
+    json_extract(positions.json_position, "$.aisle") AS aisle,
+    json_extract(positions.json_position, "$.block") AS block,
+    json_extract(positions.json_position, "$.slot") AS slot
+  FROM
+    flights
+    LEFT JOIN flightPositions USING(flightId)
+    LEFT JOIN positions USING(positionId);
+
+-- positions
+insert into positions (json_position) values ('{"aisle":"1a", "block":"1", "slot":"1"}');
+insert into positions (json_position) values ('{"aisle":"1a", "block":"1", "slot":"2"}');
+insert into positions (json_position) values ('{"aisle":"1a", "block":"1", "slot":"3"}');
+insert into positions (json_position) values ('{"aisle":"1a", "block":"2", "slot":"1"}');
+insert into positions (json_position) values ('{"aisle":"1a", "block":"2", "slot":"2"}');
+insert into positions (json_position) values ('{"aisle":"1a", "block":"2", "slot":"3"}');
+
+insert into positions (json_position) values ('{"aisle":"1b", "block":"1", "slot":"1"}');
+insert into positions (json_position) values ('{"aisle":"1b", "block":"1", "slot":"2"}');
+insert into positions (json_position) values ('{"aisle":"1b", "block":"1", "slot":"3"}');
+insert into positions (json_position) values ('{"aisle":"1b", "block":"2", "slot":"1"}');
+insert into positions (json_position) values ('{"aisle":"1b", "block":"2", "slot":"2"}');
+insert into positions (json_position) values ('{"aisle":"1b", "block":"2", "slot":"3"}');
+
+insert into positions (json_position) values ('{"aisle":"2a", "block":"1", "slot":"1"}');
+insert into positions (json_position) values ('{"aisle":"2a", "block":"1", "slot":"2"}');
+insert into positions (json_position) values ('{"aisle":"2a", "block":"1", "slot":"3"}');
+insert into positions (json_position) values ('{"aisle":"2a", "block":"2", "slot":"1"}');
+insert into positions (json_position) values ('{"aisle":"2a", "block":"2", "slot":"2"}');
+insert into positions (json_position) values ('{"aisle":"2a", "block":"2", "slot":"3"}');
+insert into positions (json_position) values ('{"aisle":"2a", "block":"3", "slot":"1"}');
+insert into positions (json_position) values ('{"aisle":"2a", "block":"3", "slot":"2"}');
+insert into positions (json_position) values ('{"aisle":"2a", "block":"3", "slot":"3"}');
+
+insert into positions (json_position) values ('{"aisle":"2b", "block":"1", "slot":"1"}');
+insert into positions (json_position) values ('{"aisle":"2b", "block":"1", "slot":"2"}');
+insert into positions (json_position) values ('{"aisle":"2b", "block":"1", "slot":"3"}');
+insert into positions (json_position) values ('{"aisle":"2b", "block":"2", "slot":"1"}');
+insert into positions (json_position) values ('{"aisle":"2b", "block":"2", "slot":"2"}');
+insert into positions (json_position) values ('{"aisle":"2b", "block":"2", "slot":"3"}');
+insert into positions (json_position) values ('{"aisle":"2b", "block":"3", "slot":"1"}');
+insert into positions (json_position) values ('{"aisle":"2b", "block":"3", "slot":"2"}');
+insert into positions (json_position) values ('{"aisle":"2b", "block":"3", "slot":"3"}');
+
+-- items
+insert into items (sku, discrepancy) values ("000SKU001", "");
+insert into items (sku, discrepancy) values ("empty", "");
+insert into items (sku, discrepancy) values ("000SKU003", "missing");
+insert into items (sku, discrepancy) values ("000SKU004", "missing");
+insert into items (sku, discrepancy) values ("000SKU005", "moved");
+insert into items (sku, discrepancy) values ("000SKU006", "" );
+insert into items (sku, discrepancy) values ("000SKU007", "");
+insert into items (sku, discrepancy) values ("empty", "");
+insert into items (sku, discrepancy) values ("000SKU008", "missing");
+insert into items (sku, discrepancy) values ("000SKU009", "missing");
+insert into items (sku, discrepancy) values ("000SKU010", "moved");
+insert into items (sku, discrepancy) values ("000SKU011", "" );
+
+-- inventory
+-- insert into item (timestamp, itemId, positionId) values ("YYYY-MM-DD HH:MM:SS.SSS", 1, 1);
+insert into inventory (startTime, stopTime, itemId, positionId) values ("2020-04-04 19:22:45.000", "2020-04-04 19:22:45.000", 1, 1);
+insert into inventory (startTime, stopTime, itemId, positionId) values ("2020-04-04 19:22:45.001", "2020-04-04 19:22:45.001", 2, 1);
+insert into inventory (startTime, stopTime, itemId, positionId) values ("2020-04-04 19:22:45.002", "2020-04-04 19:22:45.002", 3, 2);
+insert into inventory (startTime, stopTime, itemId, positionId) values ("2020-04-04 19:22:45.003", "2020-04-04 19:22:45.003", 4, 4);
+insert into inventory (startTime, stopTime, itemId, positionId) values ("2020-04-04 19:22:45.004", "2020-04-04 19:22:45.004", 5, 7);
+insert into inventory (startTime, stopTime, itemId, positionId) values ("2020-04-04 19:22:45.005", "2020-04-04 19:22:45.005", 6, 9);
+insert into inventory (startTime, stopTime, itemId, positionId) values ("2020-04-04 19:22:45.006", "2020-04-04 19:22:45.006", 7, 14);
+insert into inventory (startTime, stopTime, itemId, positionId) values ("2020-04-04 19:22:45.007", "2020-04-04 19:22:45.007", 8, 19);
+insert into inventory (startTime, stopTime, itemId, positionId) values ("2020-04-04 19:22:45.007", "2020-04-04 19:22:45.007", 9, 15);
+insert into inventory (startTime, stopTime, itemId, positionId) values ("2020-04-04 19:22:45.007", "2020-04-04 19:22:45.007", 10, 6);
+insert into inventory (startTime, stopTime, itemId, positionId) values ("2020-04-04 19:22:45.007", "2020-04-04 19:22:45.007", 11, 24);
+insert into inventory (startTime, stopTime, itemId, positionId) values ("2020-04-04 19:22:45.007", "2020-04-04 19:22:45.007", 12, 23);
+
+insert into regions (name, frequency) values ("region1", 3);
+insert into regions (name, frequency) values ("region2", 1);
+insert into regions (name, frequency) values ("region3", 1);
+insert into regions (name, frequency) values ("region4", 1);
+insert into regions (name, frequency) values ("region5", 1);
+insert into regions (name, frequency) values ("region6", 1);
+insert into regions (name, frequency) values ("region7", 1);
+insert into regions (name, frequency) values ("region8", 1);
+insert into regions (name, frequency) values ("region9", 1);
+insert into regions (name, frequency) values ("region10", 1);
+insert into regions (name, frequency) values ("region11", 1);
+insert into regions (name, frequency) values ("region12", 1);
+
+insert into regionPositions (regionId, positionId) values (1,1);
+insert into regionPositions (regionId, positionId) values (1,2);
+insert into regionPositions (regionId, positionId) values (1,3);
+insert into regionPositions (regionId, positionId) values (1,4);
+insert into regionPositions (regionId, positionId) values (1,5);
+insert into regionPositions (regionId, positionId) values (1,6);
+
+insert into regionPositions (regionId, positionId) values (2,7);
+insert into regionPositions (regionId, positionId) values (2,8);
+insert into regionPositions (regionId, positionId) values (2,9);
+insert into regionPositions (regionId, positionId) values (2,10);
+insert into regionPositions (regionId, positionId) values (2,11);
+insert into regionPositions (regionId, positionId) values (2,12);
+
+insert into regionPositions (regionId, positionId) values (3,13);
+insert into regionPositions (regionId, positionId) values (3,14);
+insert into regionPositions (regionId, positionId) values (3,15);
+insert into regionPositions (regionId, positionId) values (3,16);
+insert into regionPositions (regionId, positionId) values (3,17);
+insert into regionPositions (regionId, positionId) values (3,18);
+insert into regionPositions (regionId, positionId) values (3,19);
+insert into regionPositions (regionId, positionId) values (3,20);
+insert into regionPositions (regionId, positionId) values (3,21);
+
+insert into regionPositions (regionId, positionId) values (4,22);
+insert into regionPositions (regionId, positionId) values (4,23);
+insert into regionPositions (regionId, positionId) values (4,24);
+insert into regionPositions (regionId, positionId) values (4,25);
+insert into regionPositions (regionId, positionId) values (4,26);
+insert into regionPositions (regionId, positionId) values (4,27);
+insert into regionPositions (regionId, positionId) values (4,28);
+insert into regionPositions (regionId, positionId) values (4,29);
+insert into regionPositions (regionId, positionId) values (5,30);
+
+insert into events (name, entry, regionId) values ("event1",  1, 1);
+insert into events (name, entry, regionId) values ("event2",  2, 2);
+insert into events (name, entry, regionId) values ("event3",  3, 3);
+insert into events (name, entry, regionId) values ("event4",  4, 4);
+insert into events (name, entry, regionId) values ("event5",  5, 1);
+insert into events (name, entry, regionId) values ("event6",  6, 5);
+insert into events (name, entry, regionId) values ("event7",  7, 6);
+insert into events (name, entry, regionId) values ("event8",  8, 7);
+insert into events (name, entry, regionId) values ("event9",  9, 8);
+insert into events (name, entry, regionId) values ("event10", 10, 1);
+insert into events (name, entry, regionId) values ("event11", 11, 9);
+insert into events (name, entry, regionId) values ("event12", 12, 10);
+insert into events (name, entry, regionId) values ("event13", 13, 11);
+insert into events (name, entry, regionId) values ("event14", 14, 12);
+
+insert into restrictions (name, startDate, stopDate, startTime, stopTime, periodicityNum, periodicity, regionId) values ("causeway", "2020-04-04", "2020-04-05", "10:00", "13:00", 1, "daily", 1);
+insert into restrictions (name, startDate, stopDate, startTime, stopTime, periodicityNum, periodicity, regionId) values ("crossroads", "2020-04-04", "2020-04-05", "10:00", "13:00", 2, "daily", 2);
+insert into restrictions (name, startDate, stopDate, startTime, stopTime, periodicityNum, periodicity, regionId) values ("footpath", "2020-04-04", "2020-04-05", "10:00", "13:00", 1, "daily", 3);
+insert into restrictions (name, startDate, stopDate, startTime, stopTime, periodicityNum, periodicity, regionId) values ("area57", "2020-04-04", "2020-04-05", "10:00", "13:00", 3, "daily", 4);
+insert into restrictions (name, startDate, stopDate, startTime, stopTime, periodicityNum, periodicity, regionId) values ("breezeway", "2020-04-04", "2020-04-05", "10:00", "13:00", 2, "daily", 5);
+
+insert into flights (time) values ("10:00");
+insert into flights (time) values ("10:01");
+insert into flights (time) values ("10:02");
+
+insert into flightPositions (flightId, positionId, sku, occupancy) values (1, 1, "000SKU005", "12.1");
+insert into flightPositions (flightId, positionId, sku, occupancy) values (1, 2, "000SKU006", "12.2");
+insert into flightPositions (flightId, positionId, sku, occupancy) values (1, 3, "000SKU007", "12.3");
+insert into flightPositions (flightId, positionId, sku, occupancy) values (2, 4, "000SKU008", "13.1");
+insert into flightPositions (flightId, positionId, sku, occupancy) values (2, 5, "000SKU009", "13.2");
+insert into flightPositions (flightId, positionId, sku, occupancy) values (2, 6, "000SKU010", "13.3");
+insert into flightPositions (flightId, positionId, sku, occupancy) values (3, 7, "000SKU011", "14.1");
+insert into flightPositions (flightId, positionId, sku, occupancy) values (3, 8, "000SKU012", "14.2");
+insert into flightPositions (flightId, positionId, sku, occupancy) values (3, 9, "000SKU013", "14.3");
\ No newline at end of file
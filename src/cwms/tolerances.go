@@ -0,0 +1,155 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Tolerance definition matches the quantityTolerances table
+// A blank Sku and Aisle makes the tolerance the warehouse default.
+type Tolerance struct {
+	Id       int     `xml:"id,attr" json:"id"`
+	Sku      string  `xml:"sku,omitempty" json:"sku"`
+	Aisle    string  `xml:"aisle,omitempty" json:"aisle"`
+	Absolute int     `xml:"absolute" json:"absolute"`
+	Percent  float64 `xml:"percent" json:"percent"`
+}
+
+type ToleranceList []Tolerance
+
+// allows reports whether the difference between expected and observed quantities is within the tolerance
+func (t Tolerance) allows(expected, observed int) bool {
+	diff := observed - expected
+	if diff < 0 {
+		diff = -diff
+	}
+	allowed := math.Max(float64(t.Absolute), t.Percent*float64(expected)/100)
+	return float64(diff) <= allowed
+}
+
+// lookup returns the most specific tolerance for a sku in an aisle: sku, then aisle, then the default
+// Without a default, quantities must match exactly.
+func (tl ToleranceList) lookup(sku, aisle string) (t Tolerance) {
+	rank := 0
+	for _, c := range tl {
+		switch {
+		case c.Sku != "" && c.Sku == sku && rank < 3:
+			t, rank = c, 3
+		case c.Sku == "" && c.Aisle != "" && c.Aisle == aisle && rank < 2:
+			t, rank = c, 2
+		case c.Sku == "" && c.Aisle == "" && rank < 1:
+			t, rank = c, 1
+		}
+	}
+	return
+}
+
+// FetchTolerances performs a query on quantityTolerances and returns the tolerances of a site in a ToleranceList
+func FetchTolerances(siteId int) (tl ToleranceList, err error) {
+	defer observeQuery("FetchTolerances", time.Now())
+	var rows *sql.Rows
+	if rows, err = db.Query(`select toleranceId, IFNULL(sku, ""), IFNULL(aisle, ""), IFNULL(absolute, 0), IFNULL(percent, 0) from quantityTolerances where siteId = ? order by toleranceId`, siteId); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var t Tolerance
+	for rows.Next() {
+		if err = rows.Scan(StructForScan(&t)...); err != nil {
+			return
+		}
+		tl = append(tl, t)
+	}
+	err = rows.Err()
+	return
+}
+
+// CreateTolerance stores a tolerance of a site, replacing any existing tolerance of the site for the same sku and aisle
+func CreateTolerance(siteId int, t Tolerance) (Tolerance, error) {
+	if _, err := db.Exec(`delete from quantityTolerances where siteId = ? and IFNULL(sku, "") = ? and IFNULL(aisle, "") = ?`, siteId, t.Sku, t.Aisle); err != nil {
+		return t, err
+	}
+	res, err := db.Exec(`insert into quantityTolerances (sku, aisle, absolute, percent, siteId) values (NULLIF(?, ""), NULLIF(?, ""), ?, ?, ?)`,
+		t.Sku, t.Aisle, t.Absolute, t.Percent, siteId)
+	if err != nil {
+		return t, err
+	}
+	id, err := res.LastInsertId()
+	t.Id = int(id)
+	return t, err
+}
+
+// DeleteTolerance removes a tolerance of a site, sql.ErrNoRows when the site has no such tolerance
+func DeleteTolerance(siteId, id int) (err error) {
+	var res sql.Result
+	if res, err = db.Exec(`delete from quantityTolerances where toleranceId = ? and siteId = ?`, id, siteId); err != nil {
+		return
+	}
+	var n int64
+	if n, err = res.RowsAffected(); err == nil && n == 0 {
+		err = sql.ErrNoRows
+	}
+	return
+}
+
+// handleApiTolerances is the endpoint for quantity tolerances restful api
+// accepts:
+//
+//	GET    /api/v1/tolerances
+//	POST   /api/v1/tolerances       {"sku": "", "aisle": "", "absolute": 1, "percent": 5}
+//	DELETE /api/v1/tolerances/:id
+func handleApiTolerances(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var t Tolerance
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			apiFail(w, r, errBadRequest("%v", err))
+			return
+		}
+		if t.Sku != "" && t.Aisle != "" {
+			apiFail(w, r, errBadRequest("a tolerance applies to a sku or an aisle, not both"))
+			return
+		}
+		if t.Absolute < 0 || t.Percent < 0 {
+			apiFail(w, r, errBadRequest("tolerance must not be negative"))
+			return
+		}
+		t, err := CreateTolerance(requestSite(r).Id, t)
+		if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if err = jsonApi(w, r, t, false); err != nil {
+			log.Println(err)
+		}
+	case http.MethodDelete:
+		id, err := pathInt(pathParam(r, "id"), "tolerance id")
+		if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if err = DeleteTolerance(requestSite(r).Id, id); err == sql.ErrNoRows {
+			apiFail(w, r, errNotFound("tolerance %d not found", id))
+			return
+		} else if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if err := jsonApi(w, r, nil, false); err != nil {
+			log.Println(err)
+		}
+	default:
+		tl, err := FetchTolerances(requestSite(r).Id)
+		if err != nil {
+			apiFail(w, r, err)
+			return
+		}
+		if err = jsonApi(w, r, tl, false); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestSiteTolerances(t *testing.T) {
+	seedTestDb(t)
+	north, err := FetchSite("north")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := FetchTolerances(defaultSiteId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loose, err := CreateTolerance(defaultSiteId, Tolerance{Sku: "T", Absolute: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tl, err := FetchTolerances(north.Id); err != nil || len(tl) != 0 {
+		t.Errorf("north tolerances %+v, expected none: %v", tl, err)
+	}
+	if err = DeleteTolerance(north.Id, loose.Id); err != sql.ErrNoRows {
+		t.Errorf("deleting a tolerance of another site: %v, expected %v", err, sql.ErrNoRows)
+	}
+	if tl, err := FetchTolerances(defaultSiteId); err != nil || len(tl) != len(before)+1 {
+		t.Errorf("default site tolerances %+v, expected the one created kept: %v", tl, err)
+	}
+
+	// north flight 4 counts 11 cases of the 10 expected at position 31, within the default site tolerance of sku T
+	for _, stmt := range []string{
+		`update items set sku = 'T', gtin = NULL, lot = NULL, quantity = 10
+			where itemId = (select itemId from inventory where positionId = 31 order by inventoryId desc limit 1)`,
+		`update flightPositions set sku = 'T', gtin = NULL, lot = NULL, quantity = 11 where flightId = 4 and positionId = 31`,
+	} {
+		if _, err = db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	discrepancy := func() (d string) {
+		t.Helper()
+		if _, err := ReconcileFlight(4); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.QueryRow(`select discrepancy from v_inventory where positionId = 31`).Scan(&d); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if d := discrepancy(); d != "quantity" {
+		t.Errorf("discrepancy %q under the default site tolerance, expected quantity", d)
+	}
+	if _, err = CreateTolerance(north.Id, Tolerance{Absolute: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if d := discrepancy(); d != "" {
+		t.Errorf("discrepancy %q within the north tolerance, expected none", d)
+	}
+}
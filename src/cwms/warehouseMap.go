@@ -0,0 +1,283 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Map layout dimensions in pixels
+const (
+	mapSlotSize   = 24 // width and height of a slot cell
+	mapSlotGap    = 2  // gap between slots in a block
+	mapBlockGap   = 12 // gap between blocks in an aisle
+	mapAisleGap   = 16 // gap between aisle rows
+	mapLabelWidth = 48 // width of the aisle label column
+	mapMargin     = 10 // outer margin
+)
+
+// mapSlot holds the per position summary from the v_positionMap view
+type mapSlot struct {
+	PositionId      int    `db:"positionId"`
+	Aisle           string `db:"aisle"`
+	Block           string `db:"block"`
+	Slot            string `db:"slot"`
+	NumberException int    `db:"numberException"`
+	NumberEmpty     int    `db:"numberEmpty"`
+	NumberOccupied  int    `db:"numberOccupied"`
+	LastScanned     string `db:"lastScanned"`
+}
+
+type mapSlotList []mapSlot
+
+// MapFilter holds warehouse map selection and colouring information
+type MapFilter struct {
+	Aisle  string // Filter on Aisle, blank for the whole warehouse
+	Mode   string // Colouring mode: "discrepancy", "occupancy" or "age"
+	MaxAge int    // Scan age in days that is coloured as fully stale
+	SiteId int    // Filter on Site
+
+	siteQuery string // url query keeping slot links within the site
+}
+
+// toSqlStmt generates a sql statement
+func (mf MapFilter) toSqlStmt() (sqlstmt string, args []interface{}) {
+	sel := `select positionId, aisle, block, slot, numberException, numberEmpty, numberOccupied, lastScanned from v_positionMap where siteId = ? `
+	order := `order by aisle, cast(block as integer), block, cast(slot as integer), slot`
+	args = append(args, mf.SiteId)
+	if mf.Aisle != "" {
+		sqlstmt = fmt.Sprintf("%s and aisle = ? %s", sel, order)
+		args = append(args, mf.Aisle)
+	} else {
+		sqlstmt = fmt.Sprintf("%s %s", sel, order)
+	}
+	return
+}
+
+// fetchMapSlot returns the summary of a position of a site, sql.ErrNoRows when the site has no such position
+func fetchMapSlot(siteId, positionId int) (ms mapSlot, err error) {
+	err = db.QueryRow(`select positionId, aisle, block, slot, numberException, numberEmpty, numberOccupied, lastScanned from v_positionMap where siteId = ? and positionId = ?`,
+		siteId, positionId).Scan(StructForScan(&ms)...)
+	return
+}
+
+// fetchMapSlots performs a query on v_positionMap and returns the results in a mapSlotList
+func fetchMapSlots(mf MapFilter) (msl mapSlotList, err error) {
+	// Execute database query
+	var rows *sql.Rows
+	sqlstmt, args := mf.toSqlStmt()
+	if rows, err = db.Query(sqlstmt, args...); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	// Process query results
+	var ms mapSlot
+	for rows.Next() {
+		if err = rows.Scan(StructForScan(&ms)...); err != nil {
+			return
+		}
+		msl = append(msl, ms)
+	}
+	err = rows.Err()
+	return
+}
+
+// scanAge returns the number of days since the slot was last scanned, or -1 if it has never been scanned
+func (ms mapSlot) scanAge(now time.Time) float64 {
+	if ms.LastScanned == "" {
+		return -1
+	}
+	// go-sqlite3 stores time.Time values in the last layout
+	for _, layout := range []string{"2006-01-02 15:04:05.000", "2006-01-02 15:04:05", time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00"} {
+		if t, err := time.Parse(layout, ms.LastScanned); err == nil {
+			return now.Sub(t).Hours() / 24
+		}
+	}
+	return -1
+}
+
+// colour returns the fill colour and a short status description of a slot for the selected mode
+func (ms mapSlot) colour(mf MapFilter, now time.Time) (fill, status string) {
+	unscanned := ms.NumberException+ms.NumberEmpty+ms.NumberOccupied == 0
+	switch mf.Mode {
+	case "occupancy":
+		switch {
+		case unscanned:
+			return "#cccccc", "unscanned"
+		case ms.NumberOccupied > 0:
+			return "#337ab7", "occupied"
+		default:
+			return "#f5f5f5", "empty"
+		}
+	case "age":
+		age := ms.scanAge(now)
+		if age < 0 {
+			return "#cccccc", "never scanned"
+		}
+		// blend from green (fresh) to red (MaxAge days or older)
+		f := age / float64(mf.MaxAge)
+		if f > 1 {
+			f = 1
+		}
+		return fmt.Sprintf("#%02x%02x%02x", int(92+f*(217-92)), int(184+f*(83-184)), int(92+f*(79-92))),
+			fmt.Sprintf("scanned %.0f days ago", age)
+	default:
+		switch {
+		case ms.NumberException > 0:
+			return "#d9534f", fmt.Sprintf("%d discrepancies", ms.NumberException)
+		case unscanned:
+			return "#cccccc", "unscanned"
+		default:
+			return "#5cb85c", "ok"
+		}
+	}
+}
+
+// renderMapSvg draws the slots as a grid with one row per aisle and slots grouped by block
+func renderMapSvg(msl mapSlotList, mf MapFilter, now time.Time) string {
+	var body strings.Builder
+	y := mapMargin
+	width := 0
+	for i := 0; i < len(msl); {
+		// each aisle is a row labelled with the aisle name
+		aisle := msl[i].Aisle
+		fmt.Fprintf(&body, `<text x="%d" y="%d" class="aisle">%s</text>`+"\n",
+			mapMargin, y+mapSlotSize-mapSlotSize/3, template.HTMLEscapeString(aisle))
+		x := mapMargin + mapLabelWidth
+		block := msl[i].Block
+		for ; i < len(msl) && msl[i].Aisle == aisle; i++ {
+			ms := msl[i]
+			if ms.Block != block {
+				block = ms.Block
+				x += mapBlockGap - mapSlotGap
+			}
+			fill, status := ms.colour(mf, now)
+			fmt.Fprintf(&body, `<a href="/positions/%d%s" target="_top"><rect x="%d" y="%d" width="%d" height="%d" fill="%s"><title>aisle %s block %s slot %s: %s</title></rect></a>`+"\n",
+				ms.PositionId, mf.siteQuery, x, y, mapSlotSize, mapSlotSize, fill,
+				template.HTMLEscapeString(ms.Aisle), template.HTMLEscapeString(ms.Block), template.HTMLEscapeString(ms.Slot), status)
+			x += mapSlotSize + mapSlotGap
+		}
+		width = Max(width, x)
+		y += mapSlotSize + mapAisleGap
+	}
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		width+mapMargin, y+mapMargin, width+mapMargin, y+mapMargin)
+	svg.WriteString(`<style>rect { stroke: #999999; stroke-width: 1; } a:hover rect { stroke: #000000; stroke-width: 2; } .aisle { font: 12px sans-serif; }</style>` + "\n")
+	svg.WriteString(body.String())
+	svg.WriteString("</svg>\n")
+	return svg.String()
+}
+
+// handleMap is the endpoint for the warehouse map
+// accepts:
+//
+//	/map/?mode=discrepancy|occupancy|age&aisle=:aisle&days=:maxAge
+//	GET /api/v1/sites/:site/map?mode=discrepancy|occupancy|age&aisle=:aisle&days=:maxAge
+//
+// Writes an svg image of the warehouse grid coloured by the selected mode.
+func handleMap(w http.ResponseWriter, r *http.Request) {
+	// Fetch url parameters
+	urlParams := r.URL.Query()
+
+	mf := MapFilter{Aisle: urlParams.Get("aisle"), Mode: urlParams.Get("mode"), MaxAge: 30, SiteId: requestSite(r).Id}
+	mf.siteQuery = siteQuery(r)
+	if mf.Aisle == "all" {
+		mf.Aisle = ""
+	}
+	switch mf.Mode {
+	case "", "discrepancy", "occupancy", "age":
+	default:
+		apiFail(w, r, errBadRequest("unknown map mode %q", mf.Mode))
+		return
+	}
+	if d := urlParams.Get("days"); d != "" {
+		var err error
+		if mf.MaxAge, err = strconv.Atoi(d); err != nil || mf.MaxAge <= 0 {
+			apiFail(w, r, errBadRequest("invalid days %q", d))
+			return
+		}
+	}
+
+	msl, err := fetchMapSlots(mf)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write([]byte(renderMapSvg(msl, mf, time.Now())))
+}
+
+// handlePosition is the page of a position's inventory, linked from the slots of the warehouse map
+// accepts:
+//
+//	/positions/:id?site=:site
+func handlePosition(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(r.URL.Path, "/positions/"), "/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	ms, err := fetchMapSlot(requestSite(r).Id, id)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		pageFail(w, err)
+		return
+	}
+	wl, err := FetchInventory(AisleFilter{PositionId: id, SiteId: requestSite(r).Id})
+	if err != nil {
+		pageFail(w, err)
+		return
+	}
+	tm := map[string]interface{}{"Position": ms, "Inventory": wl, "SiteQuery": siteQuery(r)}
+	if err = executeTemplate("position.html", tm, w); err != nil {
+		log.Println(err)
+	}
+}
+
+// handleApiPositions is the endpoint for position details
+// accepts:
+//
+//	GET /api/v1/positions/:id
+//
+// Writes a json response with the inventory records held at the position.
+func handleApiPositions(w http.ResponseWriter, r *http.Request) {
+	var af AisleFilter
+	af.SiteId = requestSite(r).Id
+
+	var err error
+	if af.PositionId, err = pathInt(pathParam(r, "id"), "position id"); err != nil {
+		apiFail(w, r, err)
+		return
+	}
+	var exists bool
+	if err := db.QueryRow(`select exists (select 1 from positions where positionId = ? and siteId = ?)`, af.PositionId, af.SiteId).Scan(&exists); err != nil {
+		apiFail(w, r, err)
+		return
+	} else if !exists {
+		apiFail(w, r, errNotFound("position %d not found", af.PositionId))
+		return
+	}
+
+	// Fetch inventory filtered by position
+	wl, err := FetchInventory(af)
+	if err != nil {
+		apiFail(w, r, err)
+		return
+	}
+
+	// Send position inventory in json response
+	if err = jsonApi(w, r, wl, false); err != nil {
+		log.Println(err)
+	}
+}
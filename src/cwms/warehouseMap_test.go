@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScanAge(t *testing.T) {
+	now := time.Date(2020, 4, 14, 19, 22, 45, 0, time.UTC)
+	for _, test := range []struct {
+		lastScanned string
+		age         float64
+	}{
+		{"", -1},
+		{"not a time", -1},
+		{"2020-04-04 19:22:45.001", 10},
+		{"2020-04-04 19:22:45", 10},
+		{"2020-04-04T19:22:45Z", 10},
+		// as go-sqlite3 stores a time.Time
+		{"2020-04-04 19:22:45.159546953+00:00", 10},
+		{"2020-04-04 21:22:45+02:00", 10},
+	} {
+		age := mapSlot{LastScanned: test.lastScanned}.scanAge(now)
+		if test.age < 0 && age != -1 || test.age >= 0 && (age < test.age-0.01 || age > test.age+0.01) {
+			t.Errorf("scanAge(%q) = %v, expected %v", test.lastScanned, age, test.age)
+		}
+	}
+}
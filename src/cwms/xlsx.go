@@ -0,0 +1,245 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xlsxEpoch is day zero of spreadsheet date serial numbers
+var xlsxEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+const (
+	xlsxMainNs = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	xlsxRelNs  = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	xlsxPkgNs  = "http://schemas.openxmlformats.org/package/2006/relationships"
+
+	// styles.xml cellXfs: 0 default, 1 date, 2 bold header, 3 date and time
+	xlsxDateStyle     = 1
+	xlsxHeaderStyle   = 2
+	xlsxDateTimeStyle = 3
+)
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="` + xlsxPkgNs + `">
+<Relationship Id="rId1" Type="` + xlsxRelNs + `/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="` + xlsxMainNs + `">
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="4"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="14" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/><xf numFmtId="22" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/></cellXfs>
+</styleSheet>`
+
+// xlsxWriter streams inventory worksheets into an xlsx workbook
+// Numbers, dates and times are written as typed cells whatever the export date format.
+// Rows are written to the zip archive as they arrive, the workbook part listing the sheets is written on Close.
+type xlsxWriter struct {
+	zw      *zip.Writer
+	columns []wmsColumn
+	sheets  []string
+	sheet   io.Writer
+	row     int
+}
+
+// newXlsxWriter starts a workbook with the given columns
+func newXlsxWriter(w io.Writer, cl []wmsColumn) (xw *xlsxWriter, err error) {
+	xw = &xlsxWriter{zw: zip.NewWriter(w), columns: cl}
+	for _, p := range []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/styles.xml", xlsxStyles},
+	} {
+		if err = xw.writePart(p.name, p.content); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// writePart adds a complete part to the archive
+func (xw *xlsxWriter) writePart(name, content string) error {
+	f, err := xw.zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, content)
+	return err
+}
+
+// xlsxColumnName converts a 0 based column index to its spreadsheet letters
+func xlsxColumnName(i int) (name string) {
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return
+}
+
+// xlsxSheetName makes a valid worksheet name from s that differs from the names already used
+func xlsxSheetName(s string, used []string) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+	if name == "" {
+		name = "Inventory"
+	}
+	if len([]rune(name)) > 28 {
+		name = string([]rune(name)[:28])
+	}
+	base := name
+	for n := 2; ; n++ {
+		dup := false
+		for _, u := range used {
+			if strings.EqualFold(u, name) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			return name
+		}
+		name = fmt.Sprintf("%s~%d", base, n)
+	}
+}
+
+// StartSheet ends the current worksheet and starts a new one with a frozen header row
+func (xw *xlsxWriter) StartSheet(name string) (err error) {
+	if err = xw.endSheet(); err != nil {
+		return
+	}
+	name = xlsxSheetName(name, xw.sheets)
+	xw.sheets = append(xw.sheets, name)
+	if xw.sheet, err = xw.zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(xw.sheets))); err != nil {
+		return
+	}
+	if _, err = fmt.Fprintf(xw.sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="%s"><sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>
+<cols><col min="1" max="%d" width="16" customWidth="1"/></cols><sheetData>`, xlsxMainNs, len(xw.columns)); err != nil {
+		return
+	}
+
+	xw.row = 1
+	if _, err = fmt.Fprintf(xw.sheet, `<row r="1">`); err != nil {
+		return
+	}
+	for i, c := range xw.columns {
+		if err = xw.stringCell(i, c.Name, xlsxHeaderStyle); err != nil {
+			return
+		}
+	}
+	_, err = io.WriteString(xw.sheet, "</row>\n")
+	return
+}
+
+// endSheet closes the current worksheet, if any
+func (xw *xlsxWriter) endSheet() (err error) {
+	if xw.sheet != nil {
+		_, err = io.WriteString(xw.sheet, `</sheetData></worksheet>`)
+		xw.sheet = nil
+	}
+	return
+}
+
+// stringCell writes an inline string cell, keeping leading zeros
+func (xw *xlsxWriter) stringCell(col int, s string, style int) (err error) {
+	if _, err = fmt.Fprintf(xw.sheet, `<c r="%s%d" s="%d" t="inlineStr"><is><t xml:space="preserve">`, xlsxColumnName(col), xw.row, style); err != nil {
+		return
+	}
+	if err = xml.EscapeText(xw.sheet, []byte(s)); err != nil {
+		return
+	}
+	_, err = io.WriteString(xw.sheet, `</t></is></c>`)
+	return
+}
+
+// WriteRow writes a record to the current worksheet, starting a sheet if none has been started
+func (xw *xlsxWriter) WriteRow(record *Wms) (err error) {
+	if xw.sheet == nil {
+		if err = xw.StartSheet("Inventory"); err != nil {
+			return
+		}
+	}
+	xw.row++
+	if _, err = fmt.Fprintf(xw.sheet, `<row r="%d">`, xw.row); err != nil {
+		return
+	}
+	for i, c := range xw.columns {
+		switch v := c.value(record).(type) {
+		case int:
+			_, err = fmt.Fprintf(xw.sheet, `<c r="%s%d"><v>%d</v></c>`, xlsxColumnName(i), xw.row, v)
+		case time.Time:
+			if v.IsZero() {
+				continue
+			}
+			_, err = fmt.Fprintf(xw.sheet, `<c r="%s%d" s="%d"><v>%s</v></c>`, xlsxColumnName(i), xw.row, xlsxDateTimeStyle,
+				strconv.FormatFloat(v.UTC().Sub(xlsxEpoch).Hours()/24, 'f', -1, 64))
+		case string:
+			if v == "" {
+				continue
+			}
+			if t, perr := time.Parse("2006-01-02", v); perr == nil && c.date {
+				_, err = fmt.Fprintf(xw.sheet, `<c r="%s%d" s="%d"><v>%s</v></c>`, xlsxColumnName(i), xw.row, xlsxDateStyle,
+					strconv.Itoa(int(t.Sub(xlsxEpoch).Hours()/24)))
+			} else {
+				err = xw.stringCell(i, v, 0)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+	_, err = io.WriteString(xw.sheet, "</row>\n")
+	return
+}
+
+// Close ends the last worksheet, writes the workbook and closes the archive
+func (xw *xlsxWriter) Close() (err error) {
+	if len(xw.sheets) == 0 {
+		if err = xw.StartSheet("Inventory"); err != nil {
+			return
+		}
+	}
+	if err = xw.endSheet(); err != nil {
+		return
+	}
+
+	var wb, rels strings.Builder
+	fmt.Fprintf(&wb, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="%s" xmlns:r="%s"><sheets>`, xlsxMainNs, xlsxRelNs)
+	fmt.Fprintf(&rels, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="%s">`, xlsxPkgNs)
+	for i, name := range xw.sheets {
+		wb.WriteString(`<sheet name="`)
+		xml.EscapeText(&wb, []byte(name))
+		fmt.Fprintf(&wb, `" sheetId="%d" r:id="rId%d"/>`, i+1, i+1)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="%s/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, xlsxRelNs, i+1)
+	}
+	wb.WriteString(`</sheets></workbook>`)
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="%s/styles" Target="styles.xml"/></Relationships>`, len(xw.sheets)+1, xlsxRelNs)
+
+	if err = xw.writePart("xl/workbook.xml", wb.String()); err != nil {
+		return
+	}
+	if err = xw.writePart("xl/_rels/workbook.xml.rels", rels.String()); err != nil {
+		return
+	}
+	return xw.zw.Close()
+}